@@ -0,0 +1,80 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// WithAdaptiveChunkSize makes the client adjust the sub-batch size so that
+// Process calls take about target: the size is halved after a call slower
+// than target and grows by an item after a call of full size within it,
+// up to the service n or WithChunkSize. Zero or less means a fixed size.
+func WithAdaptiveChunkSize(target time.Duration) Option {
+	return func(c *Client) {
+		c.adaptive = nil
+		if target > 0 {
+			c.adaptive = &adaptiveChunk{target: target}
+		}
+	}
+}
+
+// adaptiveChunk is the sub-batch size adjusted to the observed latency
+// of the service, additive increase and multiplicative decrease.
+type adaptiveChunk struct {
+	target time.Duration
+
+	mu sync.Mutex
+	// size is the current sub-batch size, zero until the first sub-batch.
+	size uint64
+}
+
+// limit caps n, the largest sub-batch size allowed, with the current size
+// and returns the size of the next sub-batch.
+func (a *adaptiveChunk) limit(n uint64) uint64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.size == 0 || a.size > n {
+		a.size = n
+	}
+	return a.size
+}
+
+// current returns the current size capped by n without changing it.
+func (a *adaptiveChunk) current(n uint64) uint64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.size == 0 || a.size > n {
+		return n
+	}
+	return a.size
+}
+
+// observe adjusts the size after a Process call of items took latency.
+func (a *adaptiveChunk) observe(items int, latency time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	switch {
+	case latency > a.target:
+		if a.size /= 2; a.size == 0 {
+			a.size = 1
+		}
+	case uint64(items) >= a.size:
+		// Smaller sub-batches, such as the last one of a batch, tell
+		// nothing about a larger size.
+		a.size++
+	}
+}
+
+// currentChunkSize returns the size of the next sub-batch given the
+// current limits.
+func (c *Client) currentChunkSize() uint64 {
+	n, _ := c.limits()
+	n = c.chunkSize(n)
+	if c.adaptive != nil {
+		return c.adaptive.current(n)
+	}
+	return n
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// latencyService takes perItem per item of a batch to process it.
+type latencyService struct {
+	recordingService
+	perItem time.Duration
+}
+
+func (s *latencyService) Process(ctx context.Context, batch Batch) error {
+	time.Sleep(s.perItem * time.Duration(len(batch)))
+	return s.recordingService.Process(ctx, batch)
+}
+
+func TestClientAdaptiveChunkSize(t *testing.T) {
+	// Sub-batches of up to 5 items take up to the target.
+	service := &latencyService{
+		recordingService: recordingService{n: 40, p: time.Microsecond},
+		perItem:          time.Millisecond * 2,
+	}
+	client := NewClient(service, WithAdaptiveChunkSize(time.Millisecond*11))
+
+	if got := client.Stats().ChunkSize; got != 40 {
+		t.Errorf("expected the chunk size to start at n, got %d", got)
+	}
+	if err := client.ProcessAll(context.Background(), make(Batch, 200)); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := service.recorded()
+	if got := len(calls[0].batch); got != 40 {
+		t.Errorf("expected the first sub-batch of n items, got %d", got)
+	}
+	// Slow calls halve the size, fast ones grow it back by an item, so by
+	// the end it keeps within the target and above half of it.
+	for _, c := range calls[len(calls)-10 : len(calls)-1] {
+		if got := len(c.batch); got < 2 || got > 6 {
+			t.Errorf("expected the sub-batch size to settle around 5, got %d", got)
+		}
+	}
+	if got := client.Stats().ChunkSize; got < 2 || got > 6 {
+		t.Errorf("expected the chunk size to settle around 5, got %d", got)
+	}
+}
+
+func TestAdaptiveChunk(t *testing.T) {
+	a := &adaptiveChunk{target: time.Millisecond}
+
+	if got := a.limit(10); got != 10 {
+		t.Fatalf("expected the size to start at n, got %d", got)
+	}
+	a.observe(10, time.Millisecond*2)
+	if got := a.limit(10); got != 5 {
+		t.Errorf("expected a slow call to halve the size, got %d", got)
+	}
+	a.observe(3, time.Microsecond)
+	if got := a.limit(10); got != 5 {
+		t.Errorf("expected a smaller fast call to keep the size, got %d", got)
+	}
+	a.observe(5, time.Microsecond)
+	if got := a.limit(10); got != 6 {
+		t.Errorf("expected a full fast call to grow the size by one, got %d", got)
+	}
+	if got := a.limit(4); got != 4 {
+		t.Errorf("expected the size to be capped by n, got %d", got)
+	}
+	for i := 0; i < 5; i++ {
+		a.observe(1, time.Second)
+	}
+	if got := a.limit(10); got != 1 {
+		t.Errorf("expected the size to stay positive, got %d", got)
+	}
+}
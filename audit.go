@@ -0,0 +1,108 @@
+package main
+
+import "time"
+
+// AuditEvent is the lifecycle transition an AuditRecord is about.
+type AuditEvent string
+
+const (
+	// AuditEnqueued is recorded once a batch is accepted by the queue.
+	AuditEnqueued AuditEvent = "enqueued"
+	// AuditRejected is recorded instead of AuditEnqueued and
+	// AuditCompleted for a batch the queue doesn't accept, with the
+	// error it was rejected with.
+	AuditRejected AuditEvent = "rejected"
+	// AuditAttempt is recorded before every Process call of a sub-batch.
+	AuditAttempt AuditEvent = "attempt"
+	// AuditSucceeded is recorded once a sub-batch is processed.
+	AuditSucceeded AuditEvent = "succeeded"
+	// AuditRetry is recorded when a failed sub-batch is going to be
+	// retried.
+	AuditRetry AuditEvent = "retry"
+	// AuditDeadLettered is recorded when items are passed to the
+	// dead-letter hook, either a failed sub-batch or the items of a batch
+	// left unprocessed.
+	AuditDeadLettered AuditEvent = "dead_lettered"
+	// AuditMerged is recorded for every batch merged into another one by
+	// WithCoalesce. The events of processing are recorded for the batch
+	// it was merged into, the completion for the batch itself.
+	AuditMerged AuditEvent = "merged"
+	// AuditCompleted is recorded once a batch is finished.
+	AuditCompleted AuditEvent = "completed"
+)
+
+// AuditRecord is an entry of the audit trail of a batch.
+type AuditRecord struct {
+	// Time is when the transition happened by the client clock.
+	Time  time.Time
+	Event AuditEvent
+	// BatchID identifies the batch in the queue. It is empty for batches
+	// processed by ProcessAll.
+	BatchID string
+	// SubBatch is the zero-based index of the sub-batch of the event,
+	// -1 for the events of the whole batch.
+	SubBatch int
+	// Attempt is the number of the Process call of the sub-batch starting
+	// from 1 for AuditAttempt, AuditSucceeded and AuditRetry, zero
+	// otherwise.
+	Attempt int
+	// Items is the number of items the event is about.
+	Items int
+	// MergedInto is the ID of the batch a batch was merged into for
+	// AuditMerged, empty otherwise.
+	MergedInto string
+	// Error is the outcome of the transition, empty if it succeeded.
+	Error string
+}
+
+// AuditSink receives the audit trail of the client, e.g. to persist it.
+// Record is called for every transition of every batch, so it should be
+// fast, and it must be safe for concurrent use.
+type AuditSink interface {
+	Record(rec AuditRecord)
+	// Flush persists the records received so far. Close calls it once the
+	// client is done, so that no record is lost on shutdown.
+	Flush() error
+}
+
+// WithAuditSink makes the client record the lifecycle of every batch to
+// sink, see AuditEvent.
+func WithAuditSink(sink AuditSink) Option {
+	return func(c *Client) {
+		c.audit = sink
+	}
+}
+
+// recordAudit passes the record of event to the audit sink, if any.
+func (c *Client) recordAudit(event AuditEvent, batchID string, subBatch, attempt, items int, err error) {
+	if c.audit == nil {
+		return
+	}
+	rec := AuditRecord{
+		Time:     c.clock.Now(),
+		Event:    event,
+		BatchID:  batchID,
+		SubBatch: subBatch,
+		Attempt:  attempt,
+		Items:    items,
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	c.audit.Record(rec)
+}
+
+// recordMerged records that j was merged into the batch with the ID into.
+func (c *Client) recordMerged(j *job, into string) {
+	if c.audit == nil {
+		return
+	}
+	c.audit.Record(AuditRecord{
+		Time:       c.clock.Now(),
+		Event:      AuditMerged,
+		BatchID:    j.id,
+		SubBatch:   -1,
+		Items:      len(j.batch),
+		MergedInto: into,
+	})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryAuditSink keeps the audit records in memory.
+type memoryAuditSink struct {
+	mu      sync.Mutex
+	records []AuditRecord
+	flushes int
+}
+
+func (s *memoryAuditSink) Record(rec AuditRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, rec)
+}
+
+func (s *memoryAuditSink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushes++
+	return nil
+}
+
+func TestClientAuditSink(t *testing.T) {
+	sink := &memoryAuditSink{}
+	service := &flakyService{n: 2, p: time.Millisecond, failures: 1}
+	client := NewClient(service,
+		WithAuditSink(sink),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	start := time.Now()
+	if err := receiveResult(t, client.ProcessWithResult(make(Batch, 2))); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if sink.flushes != 1 {
+		t.Errorf("expected the sink to be flushed once on Close, got %d", sink.flushes)
+	}
+
+	var got []AuditRecord
+	for i, rec := range sink.records {
+		if rec.BatchID == "" || rec.BatchID != sink.records[0].BatchID {
+			t.Errorf("record %d: expected the batch ID %q, got %q", i, sink.records[0].BatchID, rec.BatchID)
+		}
+		if rec.Time.Before(start) || i > 0 && rec.Time.Before(sink.records[i-1].Time) {
+			t.Errorf("record %d: expected the records in time order, got %v", i, rec.Time)
+		}
+		rec.BatchID, rec.Time = "", time.Time{}
+		got = append(got, rec)
+	}
+	expected := []AuditRecord{
+		{Event: AuditEnqueued, SubBatch: -1, Items: 2},
+		{Event: AuditAttempt, SubBatch: 0, Attempt: 1, Items: 2},
+		{Event: AuditRetry, SubBatch: 0, Attempt: 1, Items: 2, Error: "temporary failure"},
+		{Event: AuditAttempt, SubBatch: 0, Attempt: 2, Items: 2},
+		{Event: AuditSucceeded, SubBatch: 0, Attempt: 2, Items: 2},
+		{Event: AuditCompleted, SubBatch: -1, Items: 2},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected records\n%+v\ngot\n%+v", expected, got)
+	}
+}
+
+func TestClientAuditSinkDeadLetter(t *testing.T) {
+	sink := &memoryAuditSink{}
+	client := NewClient(&recordingService{n: 2, p: time.Millisecond}, WithAuditSink(sink))
+
+	// Without Run the queued batch is dead-lettered on Close.
+	if err := client.Process(make(Batch, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	var events []AuditEvent
+	for _, rec := range sink.records {
+		events = append(events, rec.Event)
+	}
+	if want := []AuditEvent{AuditEnqueued, AuditDeadLettered, AuditCompleted}; !reflect.DeepEqual(events, want) {
+		t.Errorf("expected events %v, got %v", want, events)
+	}
+	if last := sink.records[len(sink.records)-1]; last.Error == "" {
+		t.Errorf("expected the batch to complete with an error, got %+v", last)
+	}
+}
+
+func TestClientAuditSinkRejected(t *testing.T) {
+	sink := &memoryAuditSink{}
+	client := NewClient(&recordingService{n: 2, p: time.Millisecond}, WithAuditSink(sink), WithQueueCapacity(1))
+
+	if err := client.Process(make(Batch, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Process(make(Batch, 2)); err == nil {
+		t.Fatal("expected the queue to be full")
+	}
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	var events []AuditEvent
+	for _, rec := range sink.records {
+		events = append(events, rec.Event)
+	}
+	// The rejected batch is never claimed to be enqueued.
+	if want := []AuditEvent{AuditEnqueued, AuditRejected}; !reflect.DeepEqual(events, want) {
+		t.Fatalf("expected events %v, got %v", want, events)
+	}
+	if rejected := sink.records[1]; rejected.Items != 2 || rejected.Error == "" {
+		t.Errorf("expected the rejection of 2 items with its error, got %+v", rejected)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrDropped reports if a queued batch was evicted to make room for a newer one.
+var ErrDropped = errors.New("batch dropped from full queue")
+
+// BackpressurePolicy decides what Process and its variants do when the
+// queue is full. ProcessBlocking always waits for a free slot.
+type BackpressurePolicy int
+
+const (
+	// BackpressureReject returns ErrQueueFull. It is the default.
+	BackpressureReject BackpressurePolicy = iota
+	// BackpressureBlock waits for a free slot like ProcessBlocking.
+	BackpressureBlock
+	// BackpressureDropOldest evicts the batch queued the longest ago among
+	// the batches with the lowest priority to make room. Batches with
+	// a higher priority than the new one are never evicted: if all queued
+	// batches have one, ErrQueueFull is returned. The evicted batch is
+	// passed to the dead-letter hook and finished with ErrDropped.
+	BackpressureDropOldest
+)
+
+func (p BackpressurePolicy) String() string {
+	switch p {
+	case BackpressureReject:
+		return "reject"
+	case BackpressureBlock:
+		return "block"
+	case BackpressureDropOldest:
+		return "drop-oldest"
+	default:
+		return fmt.Sprintf("BackpressurePolicy(%d)", int(p))
+	}
+}
+
+// WithBackpressure sets what the client does with a batch that doesn't fit
+// the queue.
+func WithBackpressure(policy BackpressurePolicy) Option {
+	return func(c *Client) {
+		c.backpressure = policy
+	}
+}
+
+// dropOldest evicts the oldest queued job with the lowest priority to make
+// room for a new one with the given priority. It reports whether there was
+// a job to evict.
+func (c *Client) dropOldest(priority int) bool {
+	j := c.queue.removeOldest(priority)
+	if j == nil {
+		return false
+	}
+
+	c.logger.Infof("Queue is full, dropping a batch of %d items", len(j.batch))
+	c.sendToDeadLetter(j.id, j.named, -1, j.batch, ErrDropped)
+	j.finish(ErrDropped)
+	return true
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowService records batches like recordingService but holds every call
+// until release is closed.
+type slowService struct {
+	recordingService
+
+	started chan struct{}
+	release chan struct{}
+}
+
+func newSlowService() *slowService {
+	return &slowService{
+		recordingService: recordingService{n: 10, p: time.Millisecond},
+		started:          make(chan struct{}, 10),
+		release:          make(chan struct{}),
+	}
+}
+
+func (s *slowService) Process(ctx context.Context, batch Batch) error {
+	s.started <- struct{}{}
+	<-s.release
+	return s.recordingService.Process(ctx, batch)
+}
+
+func TestClientBackpressure(t *testing.T) {
+	tests := []struct {
+		policy  BackpressurePolicy
+		err     error
+		blocks  bool
+		want    []string
+		dropped []string
+	}{
+		{policy: BackpressureReject, err: ErrQueueFull, want: []string{"a", "b", "c"}},
+		{policy: BackpressureBlock, blocks: true, want: []string{"a", "b", "c", "d"}},
+		{policy: BackpressureDropOldest, want: []string{"a", "b", "d"}, dropped: []string{"c"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy.String(), func(t *testing.T) {
+			var mu sync.Mutex
+			var dropped []string
+			service := newSlowService()
+			client := NewClient(service,
+				WithOrdered(true),
+				WithQueueCapacity(1),
+				WithBackpressure(tt.policy),
+				WithDeadLetter(func(batch Batch, err error) {
+					if !errors.Is(err, ErrDropped) {
+						t.Errorf("expected %v, got %v", ErrDropped, err)
+					}
+					mu.Lock()
+					dropped = append(dropped, batch[0].ID)
+					mu.Unlock()
+				}),
+			)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go client.Run(ctx)
+
+			// a is held by the service, b by Run waiting for the worker
+			// and c fills the queue.
+			if err := client.Process(Batch{{ID: "a"}}); err != nil {
+				t.Fatal(err)
+			}
+			<-service.started
+			if err := client.Process(Batch{{ID: "b"}}); err != nil {
+				t.Fatal(err)
+			}
+			for client.queue.len() != 0 {
+				time.Sleep(time.Millisecond)
+			}
+			if err := client.Process(Batch{{ID: "c"}}); err != nil {
+				t.Fatal(err)
+			}
+
+			errc := make(chan error, 1)
+			go func() {
+				errc <- client.Process(Batch{{ID: "d"}})
+			}()
+
+			if tt.blocks {
+				select {
+				case err := <-errc:
+					t.Fatalf("Process returned %v while the queue is full", err)
+				case <-time.After(time.Millisecond * 50):
+				}
+				close(service.release)
+			}
+
+			select {
+			case err := <-errc:
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expected %v, got %v", tt.err, err)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("Process did not return")
+			}
+
+			if !tt.blocks {
+				close(service.release)
+			}
+			if err := client.Shutdown(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, c := range service.recorded() {
+				got = append(got, c.batch[0].ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected batches %v, got %v", tt.want, got)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if !reflect.DeepEqual(dropped, tt.dropped) {
+				t.Errorf("expected dropped batches %v, got %v", tt.dropped, dropped)
+			}
+		})
+	}
+}
+
+func TestClientDropOldestResult(t *testing.T) {
+	client := NewClient(&testService{n: 2, p: time.Millisecond},
+		WithQueueCapacity(1),
+		WithBackpressure(BackpressureDropOldest),
+	)
+
+	result := client.ProcessWithResult(Batch{{ID: "a"}})
+	if err := client.Process(Batch{{ID: "b"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, ErrDropped) {
+			t.Errorf("expected %v, got %v", ErrDropped, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no result for the dropped batch")
+	}
+}
+
+func TestClientDropOldestPriority(t *testing.T) {
+	var dropped []string
+	client := NewClient(&testService{n: 2, p: time.Millisecond},
+		WithQueueCapacity(3),
+		WithBackpressure(BackpressureDropOldest),
+		WithDeadLetter(func(batch Batch, err error) {
+			dropped = append(dropped, batch[0].ID)
+		}),
+	)
+
+	for _, b := range []struct {
+		id       string
+		priority int
+	}{
+		{"high", 10}, {"low", 0}, {"low2", 0},
+	} {
+		if err := client.ProcessWithPriority(Batch{{ID: b.id}}, b.priority); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The oldest low-priority batch goes first, the high-priority one stays.
+	if err := client.Process(Batch{{ID: "new"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Process(Batch{{ID: "new2"}}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"low", "low2"}; !reflect.DeepEqual(dropped, want) {
+		t.Fatalf("expected dropped batches %v, got %v", want, dropped)
+	}
+
+	// Nothing queued has a priority as low as -1.
+	if err := client.ProcessWithPriority(Batch{{ID: "lowest"}}, -1); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expected %v, got %v", ErrQueueFull, err)
+	}
+	if err := client.ProcessWithPriority(Batch{{ID: "higher"}}, 20); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"low", "low2", "new"}; !reflect.DeepEqual(dropped, want) {
+		t.Errorf("expected dropped batches %v, got %v", want, dropped)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrDuplicate reports if a batch was submitted with the ID of a batch
+// submitted earlier, see ProcessWithBatchID.
+var ErrDuplicate = errors.New("duplicate batch ID")
+
+// ErrEmptyBatchID reports if ProcessWithBatchID was given an empty ID.
+var ErrEmptyBatchID = errors.New("empty batch ID")
+
+// ProcessWithBatchID enqueues batch like Process under the caller's own id
+// instead of a generated one, e.g. to keep processing idempotent end to end.
+// The ID names the batch in the logs, the traces and the audit trail, the
+// dead-letter hook gets the errors of the batch wrapped in a BatchError
+// carrying it, and the idempotency keys of its sub-batches are derived from
+// it and from the offsets of their items, e.g. "id/0:100", so that
+// a resubmitted batch gets the same keys for the same items, however the
+// sub-batches are split the second time. The batch may be cancelled with
+// Cancel(id).
+//
+// A batch submitted while another one with the same ID is pending, or
+// within the window set by WithBatchIDWindow after it was finished, is
+// ignored and ErrDuplicate is returned. Callers only after idempotency can
+// treat it as a success. A batch the queue doesn't accept doesn't take
+// the ID.
+func (c *Client) ProcessWithBatchID(id string, batch Batch) error {
+	if id == "" {
+		return ErrEmptyBatchID
+	}
+	return c.enqueue(&job{batch: batch, id: id, named: true}, false)
+}
+
+// WithBatchIDWindow makes the client remember the ID of a batch submitted
+// with ProcessWithBatchID for d after the batch is finished, ignoring the
+// batches submitted with the same ID meanwhile. Zero, the default, only
+// ignores them while the batch is pending.
+func WithBatchIDWindow(d time.Duration) Option {
+	return func(c *Client) {
+		c.batchIDs.window = d
+	}
+}
+
+type batchIDContextKey struct{}
+
+// withBatchID returns a copy of ctx carrying the batch ID id supplied by
+// the caller.
+func withBatchID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, batchIDContextKey{}, id)
+}
+
+// BatchError is an error of a batch submitted with ProcessWithBatchID,
+// as passed to the dead-letter hook.
+type BatchError struct {
+	// ID is the ID the batch was submitted with.
+	ID  string
+	Err error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("batch %s: %v", e.ID, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// batchIDs tracks the IDs taken by batches submitted with
+// ProcessWithBatchID.
+type batchIDs struct {
+	window time.Duration
+
+	mu sync.Mutex
+	// taken maps an ID to the time its batch was finished,
+	// zero while it is pending.
+	taken map[string]time.Time
+}
+
+// claim takes id at now, reporting false if it is taken already.
+func (b *batchIDs) claim(id string, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for other, finished := range b.taken {
+		if !finished.IsZero() && now.Sub(finished) >= b.window {
+			delete(b.taken, other)
+		}
+	}
+	if _, ok := b.taken[id]; ok {
+		return false
+	}
+	if b.taken == nil {
+		b.taken = make(map[string]time.Time)
+	}
+	b.taken[id] = time.Time{}
+	return true
+}
+
+// release keeps id taken until the window after now, when its batch
+// was finished.
+func (b *batchIDs) release(id string, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.taken[id]; ok {
+		b.taken[id] = now
+	}
+}
+
+// forget frees id right away.
+func (b *batchIDs) forget(id string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.taken, id)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientProcessWithBatchID(t *testing.T) {
+	clock := newFakeClock()
+	// Every batch fits a sub-batch, so that none waits for virtual time.
+	service := &recordingService{n: 3, p: time.Millisecond}
+	client := NewClient(service, WithClock(clock), WithBatchIDWindow(time.Minute))
+
+	if err := client.ProcessWithBatchID("order-1", numberedBatch(0, 3)); err != nil {
+		t.Fatal(err)
+	}
+	// The second submission is ignored while the first is pending.
+	if err := client.ProcessWithBatchID("order-1", numberedBatch(0, 3)); !errors.Is(err, ErrDuplicate) {
+		t.Fatalf("expected %v, got %v", ErrDuplicate, err)
+	}
+	if n := client.Stats().QueueLength; n != 1 {
+		t.Fatalf("expected 1 queued batch, got %d", n)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+	flush := func() {
+		flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer flushCancel()
+		if err := client.Flush(flushCtx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	flush()
+	if calls := len(service.recorded()); calls != 1 {
+		t.Fatalf("expected the batch to be processed once, got %d calls", calls)
+	}
+
+	// The ID stays taken for the window after the batch is finished.
+	clock.Advance(time.Minute - time.Second)
+	if err := client.ProcessWithBatchID("order-1", numberedBatch(0, 3)); !errors.Is(err, ErrDuplicate) {
+		t.Fatalf("expected %v within the window, got %v", ErrDuplicate, err)
+	}
+	if err := client.ProcessWithBatchID("order-2", numberedBatch(3, 1)); err != nil {
+		t.Fatalf("expected another ID to be accepted, got %v", err)
+	}
+	flush()
+	clock.Advance(time.Second)
+	if err := client.ProcessWithBatchID("order-1", numberedBatch(0, 3)); err != nil {
+		t.Fatalf("expected the ID to be free after the window, got %v", err)
+	}
+	flush()
+	if calls := len(service.recorded()); calls != 3 {
+		t.Errorf("expected the accepted batches to be processed, got %d calls", calls)
+	}
+
+	if err := client.ProcessWithBatchID("", numberedBatch(0, 1)); !errors.Is(err, ErrEmptyBatchID) {
+		t.Errorf("expected %v, got %v", ErrEmptyBatchID, err)
+	}
+}
+
+func TestClientProcessWithBatchIDQueueFull(t *testing.T) {
+	client := NewClient(&recordingService{n: 2, p: time.Millisecond}, WithQueueCapacity(1))
+
+	if err := client.Process(numberedBatch(0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.ProcessWithBatchID("order-1", numberedBatch(1, 1)); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expected %v, got %v", ErrQueueFull, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+	flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer flushCancel()
+	if err := client.Flush(flushCtx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The batch wasn't accepted, so it may be submitted again.
+	if err := client.ProcessWithBatchID("order-1", numberedBatch(1, 1)); err != nil {
+		t.Errorf("expected the ID to be free, got %v", err)
+	}
+}
+
+func TestClientBatchIDPropagation(t *testing.T) {
+	logger := &fakeLogger{}
+	letters := &deadLetters{}
+	service := &keyService{keys: map[string][]string{}}
+	client := NewClient(service, WithLogger(logger), WithDeadLetter(letters.add))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	// Without retries the first attempt of every sub-batch fails.
+	if err := client.ProcessWithBatchID("order-1", numberedBatch(0, 4)); err != nil {
+		t.Fatal(err)
+	}
+	flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer flushCancel()
+	if err := client.Flush(flushCtx); err != nil {
+		t.Fatal(err)
+	}
+
+	service.mu.Lock()
+	keys := service.keys
+	service.mu.Unlock()
+	if got := keys["0"]; len(got) != 1 || got[0] != "order-1/0:2" {
+		t.Errorf("expected the key of the first sub-batch derived from the batch ID, got %q", got)
+	}
+	if got := keys["2"]; len(got) != 1 || got[0] != "order-1/2:4" {
+		t.Errorf("expected the key of the second sub-batch derived from the batch ID, got %q", got)
+	}
+
+	_, errs := letters.recorded()
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 dead letters, got %d", len(errs))
+	}
+	for _, err := range errs {
+		var batchErr *BatchError
+		if !errors.As(err, &batchErr) || batchErr.ID != "order-1" {
+			t.Errorf("expected a BatchError of order-1, got %v", err)
+		}
+	}
+	for _, e := range logger.logged("ERROR") {
+		if !strings.HasPrefix(e.format, "batch order-1: ") {
+			t.Errorf("expected the batch ID in %q", e)
+		}
+	}
+}
+
+func TestClientBatchIDKeysChunkSize(t *testing.T) {
+	// The same batch is split into sub-batches of two items and then of
+	// one, which must not reuse a key for other items.
+	items := make(map[string]string)
+	for _, chunk := range []uint64{2, 1} {
+		service := &keyService{keys: map[string][]string{}}
+		client := NewClient(service, WithChunkSize(chunk), WithRetryPolicy(RetryPolicy{MaxAttempts: 2}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go client.Run(ctx)
+		if err := client.ProcessWithBatchID("order-1", numberedBatch(0, 6)); err != nil {
+			t.Fatal(err)
+		}
+		flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := client.Flush(flushCtx)
+		flushCancel()
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		service.mu.Lock()
+		for first, keys := range service.keys {
+			if prev, ok := items[keys[0]]; ok && prev != first {
+				t.Errorf("chunk size %d: key %q covered the items from %s before, now from %s", chunk, keys[0], prev, first)
+			}
+			items[keys[0]] = first
+		}
+		service.mu.Unlock()
+	}
+}
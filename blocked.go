@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// defaultBlockedCooldown is how long the client waits before probing
+// the service again once it reported ErrBlocked.
+const defaultBlockedCooldown = time.Minute
+
+// WithBlockedCooldown sets how long the client waits before probing
+// the service again once it reported ErrBlocked.
+func WithBlockedCooldown(cooldown time.Duration) Option {
+	return func(c *Client) {
+		c.cooldown = cooldown
+	}
+}
+
+// blockGate holds processing back while the service is blocked.
+type blockGate struct {
+	mu      sync.Mutex
+	blocked bool
+	// open is closed while the gate isn't blocked.
+	open chan struct{}
+}
+
+// newBlockGate creates an open gate.
+func newBlockGate() *blockGate {
+	open := make(chan struct{})
+	close(open)
+	return &blockGate{open: open}
+}
+
+// block closes the gate. It reports whether the gate was open before.
+func (g *blockGate) block() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.blocked {
+		return false
+	}
+	g.blocked = true
+	g.open = make(chan struct{})
+	return true
+}
+
+// unblock opens the gate. It reports whether the gate was blocked before.
+func (g *blockGate) unblock() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if !g.blocked {
+		return false
+	}
+	g.blocked = false
+	close(g.open)
+	return true
+}
+
+// opened returns a channel closed once the gate is open,
+// or nil if it is open already.
+func (g *blockGate) opened() <-chan struct{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if !g.blocked {
+		return nil
+	}
+	return g.open
+}
+
+// Wait blocks until the gate is open or ctx is done.
+func (g *blockGate) Wait(ctx context.Context) error {
+	g.mu.Lock()
+	open := g.open
+	g.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-open:
+		return nil
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingService reports ErrBlocked for the first blocked calls.
+type blockingService struct {
+	blocked int
+
+	mu    sync.Mutex
+	calls int
+	items int
+	at    []time.Time
+}
+
+func (s *blockingService) GetLimits() (uint64, time.Duration) {
+	return 2, time.Millisecond
+}
+
+func (s *blockingService) Process(ctx context.Context, batch Batch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls++
+	if s.calls <= s.blocked {
+		return ErrBlocked
+	}
+	s.items += len(batch)
+	s.at = append(s.at, time.Now())
+	return nil
+}
+
+func TestClientBlocked(t *testing.T) {
+	const cooldown = time.Millisecond * 20
+
+	service := &blockingService{blocked: 3}
+	client := NewClient(service, WithBlockedCooldown(cooldown))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	start := time.Now()
+	first := client.ProcessWithResult(make(Batch, 4))
+
+	// Wait for the service to block the client before enqueuing more.
+	deadline := time.After(time.Second)
+	for client.gate.opened() == nil {
+		select {
+		case <-deadline:
+			t.Fatal("client was not blocked")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	second := client.ProcessWithResult(make(Batch, 2))
+
+	if err := receiveResult(t, first); err != nil {
+		t.Fatalf("expected the first batch to succeed, got %v", err)
+	}
+	if err := receiveResult(t, second); err != nil {
+		t.Fatalf("expected the second batch to succeed, got %v", err)
+	}
+
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	if service.items != 6 {
+		t.Errorf("expected 6 processed items, got %d", service.items)
+	}
+	if service.calls != 6 {
+		t.Errorf("expected 3 blocked and 3 successful calls, got %d calls", service.calls)
+	}
+	for i, at := range service.at {
+		if elapsed := at.Sub(start); elapsed < cooldown*3 {
+			t.Errorf("successful call %d happened %v after start, expected at least %v", i, elapsed, cooldown*3)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen reports if a call to the service was rejected
+// by the open circuit breaker.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// BreakerState is a state of the circuit breaker.
+type BreakerState int
+
+const (
+	// BreakerClosed lets every call through.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen rejects every call until the cooldown passes.
+	BreakerOpen
+	// BreakerHalfOpen lets a single probe call through.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// WithCircuitBreaker makes the client stop calling the service after
+// threshold consecutive failures. While the breaker is open sub-batches
+// fail with ErrCircuitOpen right away, without retries. Once cooldown
+// passes a single probe call is let through: if it succeeds the breaker
+// closes, otherwise it opens again.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(c *Client) {
+		c.breaker = newBreaker(threshold, cooldown)
+	}
+}
+
+// breaker is a circuit breaker around the service calls.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+	clock     Clock
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	// probing is set while the half-open probe call is in flight.
+	probing bool
+}
+
+// newBreaker creates a closed breaker.
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &breaker{threshold: threshold, cooldown: cooldown, clock: realClock{}}
+}
+
+// allow reports whether a call may be made. It returns ErrCircuitOpen
+// if the breaker is open or the half-open probe is in flight already.
+// Every allowed call must be followed by done or release.
+func (b *breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if b.clock.Now().Sub(b.openedAt) < b.cooldown {
+			return ErrCircuitOpen
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return nil
+	case BreakerHalfOpen:
+		if b.probing {
+			return ErrCircuitOpen
+		}
+		b.probing = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// done records the outcome of an allowed call.
+func (b *breaker) done(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if !failed {
+		b.state = BreakerClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		b.state = BreakerOpen
+		b.openedAt = b.clock.Now()
+	}
+}
+
+// release gives back an allowed call that tells nothing about the service,
+// e.g. because the client is shutting down.
+func (b *breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// State returns the current state of the breaker.
+func (b *breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// BreakerState returns the state of the client circuit breaker.
+// A client without a breaker is always BreakerClosed.
+func (c *Client) BreakerState() BreakerState {
+	if c.breaker == nil {
+		return BreakerClosed
+	}
+	return c.breaker.State()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// switchService fails every call while fail is set.
+type switchService struct {
+	fail  atomic.Bool
+	calls atomic.Int32
+}
+
+func (s *switchService) GetLimits() (uint64, time.Duration) {
+	return 1, time.Millisecond
+}
+
+func (s *switchService) Process(ctx context.Context, batch Batch) error {
+	s.calls.Add(1)
+	if s.fail.Load() {
+		return errors.New("service is down")
+	}
+	return nil
+}
+
+func TestBreakerTransitions(t *testing.T) {
+	const cooldown = time.Millisecond * 20
+	b := newBreaker(2, cooldown)
+
+	for i := 0; i < 2; i++ {
+		if err := b.allow(); err != nil {
+			t.Fatalf("call %d: unexpected error: %v", i, err)
+		}
+		b.done(true)
+	}
+	if state := b.State(); state != BreakerOpen {
+		t.Fatalf("expected %v after 2 failures, got %v", BreakerOpen, state)
+	}
+	if err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected %v, got %v", ErrCircuitOpen, err)
+	}
+
+	time.Sleep(cooldown)
+	if err := b.allow(); err != nil {
+		t.Fatalf("expected the probe to be allowed, got %v", err)
+	}
+	if state := b.State(); state != BreakerHalfOpen {
+		t.Fatalf("expected %v after the cooldown, got %v", BreakerHalfOpen, state)
+	}
+	if err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected a single probe, got %v", err)
+	}
+
+	b.done(true)
+	if state := b.State(); state != BreakerOpen {
+		t.Fatalf("expected %v after a failed probe, got %v", BreakerOpen, state)
+	}
+
+	time.Sleep(cooldown)
+	if err := b.allow(); err != nil {
+		t.Fatalf("expected the probe to be allowed, got %v", err)
+	}
+	b.done(false)
+	if state := b.State(); state != BreakerClosed {
+		t.Fatalf("expected %v after a successful probe, got %v", BreakerClosed, state)
+	}
+}
+
+func TestClientCircuitBreaker(t *testing.T) {
+	const cooldown = time.Millisecond * 30
+
+	service := &switchService{}
+	service.fail.Store(true)
+
+	var letters []error
+	client := NewClient(service,
+		WithCircuitBreaker(2, cooldown),
+		WithDeadLetter(func(batch Batch, err error) {
+			letters = append(letters, err)
+		}),
+	)
+
+	if err := client.ProcessAll(context.Background(), make(Batch, 4)); err == nil {
+		t.Fatal("expected an error")
+	}
+	if calls := service.calls.Load(); calls != 2 {
+		t.Errorf("expected the breaker to open after 2 calls, got %d", calls)
+	}
+	if state := client.BreakerState(); state != BreakerOpen {
+		t.Errorf("expected %v, got %v", BreakerOpen, state)
+	}
+	if len(letters) != 4 || !errors.Is(letters[2], ErrCircuitOpen) || !errors.Is(letters[3], ErrCircuitOpen) {
+		t.Errorf("expected every sub-batch to be dead-lettered, the last two short-circuited, got %v", letters)
+	}
+
+	service.fail.Store(false)
+	time.Sleep(cooldown)
+
+	if err := client.ProcessAll(context.Background(), make(Batch, 2)); err != nil {
+		t.Fatalf("expected the recovered service to succeed, got %v", err)
+	}
+	if state := client.BreakerState(); state != BreakerClosed {
+		t.Errorf("expected %v, got %v", BreakerClosed, state)
+	}
+	if calls := service.calls.Load(); calls != 4 {
+		t.Errorf("expected 4 calls, got %d", calls)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrCancelled reports if a batch was cancelled with Client.Cancel.
+var ErrCancelled = errors.New("batch cancelled")
+
+// ProcessWithID enqueues batch like Process and returns the ID to cancel
+// it with.
+func (c *Client) ProcessWithID(batch Batch) (string, error) {
+	j := &job{batch: batch, id: newID()}
+	if err := c.enqueue(j, false); err != nil {
+		return "", err
+	}
+	return j.id, nil
+}
+
+// Cancel stops processing of the batch with the given ID returned by
+// ProcessWithID. A queued batch is skipped once dequeued, an in-flight one
+// has its current Process call cancelled and its remaining sub-batches
+// skipped. The skipped items are passed to the dead-letter hook and the
+// batch is finished with an error wrapping ErrUnprocessed and ErrCancelled.
+// Sub-batches already processed are not rolled back.
+// Cancel reports whether the batch was still pending.
+func (c *Client) Cancel(id string) bool {
+	return c.cancels.cancel(id)
+}
+
+// cancels tracks the batches that can be cancelled by ID
+// from enqueue until they are finished.
+type cancels struct {
+	mu      sync.Mutex
+	batches map[string]*batchCancel
+}
+
+// batchCancel is the cancellation state of a single batch.
+type batchCancel struct {
+	cancelled bool
+	// cancel cancels the processing context of the batch once it is
+	// in flight.
+	cancel context.CancelCauseFunc
+}
+
+func (cs *cancels) add(id string) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if cs.batches == nil {
+		cs.batches = make(map[string]*batchCancel)
+	}
+	cs.batches[id] = &batchCancel{}
+}
+
+func (cs *cancels) remove(id string) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	delete(cs.batches, id)
+}
+
+func (cs *cancels) has(id string) bool {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	_, ok := cs.batches[id]
+	return ok
+}
+
+func (cs *cancels) cancel(id string) bool {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	b, ok := cs.batches[id]
+	if !ok || b.cancelled {
+		return false
+	}
+	b.cancelled = true
+	if b.cancel != nil {
+		b.cancel(ErrCancelled)
+	}
+	return true
+}
+
+// start returns the context to process the batch with the given ID with,
+// which is cancelled along with ctx and by Cancel, and a function to
+// release it once the batch is done. It fails with ErrCancelled if the
+// batch has been cancelled already.
+func (cs *cancels) start(ctx context.Context, id string) (context.Context, func(), error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	b, ok := cs.batches[id]
+	if !ok {
+		return ctx, func() {}, nil
+	}
+	if b.cancelled {
+		return nil, nil, ErrCancelled
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	b.cancel = cancel
+	return ctx, func() { cancel(nil) }, nil
+}
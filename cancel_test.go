@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// deadLetters records the batches passed to the dead-letter hook.
+type deadLetters struct {
+	mu      sync.Mutex
+	batches []Batch
+	errs    []error
+}
+
+func (d *deadLetters) add(batch Batch, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.batches = append(d.batches, batch)
+	d.errs = append(d.errs, err)
+}
+
+func (d *deadLetters) recorded() ([]Batch, []error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]Batch(nil), d.batches...), append([]error(nil), d.errs...)
+}
+
+func TestClientCancel(t *testing.T) {
+	service := newSlowService()
+	letters := &deadLetters{}
+	client := NewClient(service, WithDeadLetter(letters.add))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	id, err := client.ProcessWithID(numberedBatch(0, 30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-service.started
+	if err := client.Process(numberedBatch(100, 5)); err != nil {
+		t.Fatal(err)
+	}
+	<-service.started
+
+	if !client.Cancel(id) {
+		t.Fatal("expected the batch to be cancelled")
+	}
+	close(service.release)
+	if err := client.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls := service.recorded(); len(calls) != 2 {
+		t.Errorf("expected the cancelled batch to stop after its first sub-batch, got %d calls", len(calls))
+	}
+	batches, errs := letters.recorded()
+	if len(batches) != 1 || len(batches[0]) != 20 || batches[0][0].ID != "10" {
+		t.Fatalf("expected the 20 items after the first sub-batch in the dead letter, got %v", batches)
+	}
+	if !errors.Is(errs[0], ErrUnprocessed) || !errors.Is(errs[0], ErrCancelled) {
+		t.Errorf("expected %v and %v, got %v", ErrUnprocessed, ErrCancelled, errs[0])
+	}
+	if client.Cancel(id) {
+		t.Error("expected a finished batch not to be cancelled")
+	}
+}
+
+func TestClientCancelQueued(t *testing.T) {
+	service := &recordingService{n: 10, p: time.Millisecond}
+	letters := &deadLetters{}
+	client := NewClient(service, WithDeadLetter(letters.add))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	client.Pause()
+	id, err := client.ProcessWithID(numberedBatch(0, 5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !client.Cancel(id) {
+		t.Fatal("expected the batch to be cancelled")
+	}
+	client.Resume()
+	if err := client.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls := service.recorded(); len(calls) != 0 {
+		t.Errorf("expected the cancelled batch to be skipped, got %d calls", len(calls))
+	}
+	if batches, errs := letters.recorded(); len(batches) != 1 || len(batches[0]) != 5 || !errors.Is(errs[0], ErrCancelled) {
+		t.Errorf("expected the whole batch in the dead letter, got %v: %v", batches, errs)
+	}
+	if client.Cancel("unknown") {
+		t.Error("expected an unknown batch not to be cancelled")
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// CallRecord is an entry of the call log of WithCallLog: a Process call
+// the client made to the service.
+type CallRecord struct {
+	// Time is when the call was made by the client clock.
+	Time  time.Time `json:"time"`
+	Batch Batch     `json:"batch"`
+	// Error is the error of the call, empty if it succeeded.
+	Error string `json:"error,omitempty"`
+}
+
+// WithCallLog makes the client append a CallRecord to w for every Process
+// call, retries included, as a line of JSON, e.g. to replay the traffic
+// against another service with ReplayCallLog. Writes are serialized and
+// hold up the call they record, so w should be fast, e.g. buffered.
+// A failed write is logged and the call log is turned off.
+func WithCallLog(w io.Writer) Option {
+	return func(c *Client) {
+		c.callLog = &callLog{enc: json.NewEncoder(w)}
+	}
+}
+
+// callLog writes the CallRecords of a client.
+type callLog struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	failed bool
+}
+
+// recordCall appends the record of a Process call of batch made at start
+// to the call log, if any.
+func (c *Client) recordCall(start time.Time, batch Batch, err error) {
+	if c.callLog == nil {
+		return
+	}
+	rec := CallRecord{Time: start, Batch: batch}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+
+	l := c.callLog
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.failed {
+		return
+	}
+	if err := l.enc.Encode(rec); err != nil {
+		l.failed = true
+		c.logger.Errorf("Error writing call log, turning it off: %v", err)
+	}
+}
+
+// ReplayCallLog replays the calls recorded by WithCallLog in r against
+// service, each at the same time after the first one as it was made
+// originally, so that service gets the traffic the client sent. The calls
+// overlap if they took longer than the time to the next one did. It
+// returns once every call is done or ctx is done, with the errors of the
+// failed calls joined together.
+func ReplayCallLog(ctx context.Context, r io.Reader, service Service) error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		errs = append(errs, err)
+	}
+
+	dec := json.NewDecoder(r)
+	var first time.Time
+	start := time.Now()
+	for i := 0; ; i++ {
+		var rec CallRecord
+		if err := dec.Decode(&rec); err != nil {
+			if !errors.Is(err, io.EOF) {
+				fail(fmt.Errorf("read call log: %w", err))
+			}
+			break
+		}
+		if i == 0 {
+			first = rec.Time
+		}
+		if err := sleep(ctx, realClock{}, time.Until(start.Add(rec.Time.Sub(first)))); err != nil {
+			fail(err)
+			break
+		}
+
+		wg.Add(1)
+		go func(i int, batch Batch) {
+			defer wg.Done()
+			if err := service.Process(ctx, batch); err != nil {
+				fail(fmt.Errorf("call %d: %w", i, err))
+			}
+		}(i, rec.Batch)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
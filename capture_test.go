@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientCallLogReplay(t *testing.T) {
+	const p = 20 * time.Millisecond
+	var log bytes.Buffer
+	service := &failingService{
+		recordingService: recordingService{n: 2, p: p},
+		fail:             map[string]bool{"2": true},
+	}
+	client := NewClient(service, WithCallLog(&log))
+
+	if err := client.ProcessAll(context.Background(), numberedBatch(0, 5)); err == nil {
+		t.Fatal("expected the failed sub-batch to fail the batch")
+	}
+
+	var records []CallRecord
+	dec := json.NewDecoder(bytes.NewReader(log.Bytes()))
+	for dec.More() {
+		var rec CallRecord
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+	if len(records) != 3 {
+		t.Fatalf("expected 3 recorded calls, got %d", len(records))
+	}
+	if records[0].Error != "" || records[1].Error != "failed sub-batch starting with 2" {
+		t.Errorf("expected the outcomes to be recorded, got %+v", records)
+	}
+
+	replayed := &recordingService{n: 2, p: p}
+	start := time.Now()
+	if err := ReplayCallLog(context.Background(), bytes.NewReader(log.Bytes()), replayed); err != nil {
+		t.Fatal(err)
+	}
+	calls := replayed.recorded()
+	if len(calls) != len(records) {
+		t.Fatalf("expected %d replayed calls, got %d", len(records), len(calls))
+	}
+	for i, call := range calls {
+		if !reflect.DeepEqual(call.batch, records[i].Batch) {
+			t.Errorf("call %d: expected %v, got %v", i, records[i].Batch, call.batch)
+		}
+		// Every call is replayed no earlier than it was originally made.
+		if offset, want := call.at.Sub(start), records[i].Time.Sub(records[0].Time); offset < want {
+			t.Errorf("call %d: expected it at %v, got %v", i, want, offset)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 2*p {
+		t.Errorf("expected the replay to take the original %v, took %v", 2*p, elapsed)
+	}
+}
+
+func TestReplayCallLogErrors(t *testing.T) {
+	service := &failingService{
+		recordingService: recordingService{n: 2, p: time.Millisecond},
+		fail:             map[string]bool{"1": true},
+	}
+	log := `{"time":"2020-01-01T00:00:00Z","batch":[{"id":"0"}]}
+{"time":"2020-01-01T00:00:00Z","batch":[{"id":"1"}]}
+not json
+`
+	err := ReplayCallLog(context.Background(), strings.NewReader(log), service)
+	if err == nil || !strings.Contains(err.Error(), "call 1: failed sub-batch starting with 1") || !strings.Contains(err.Error(), "read call log") {
+		t.Errorf("expected the failed call and the malformed line, got %v", err)
+	}
+	if calls := len(service.recorded()); calls != 2 {
+		t.Errorf("expected the calls before the malformed line to be replayed, got %d", calls)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import "time"
+
+// Clock tells the time and measures out waits for the client, so that
+// tests can run it in virtual time. Implementations must be safe for
+// concurrent use.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// NewTimer returns a timer sending the time on its channel once
+	// d has passed.
+	NewTimer(d time.Duration) Timer
+	// NewTicker returns a ticker sending the time on its channel every d.
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is a single pending event of a Clock, like time.Timer.
+type Timer interface {
+	// C returns the channel the time is sent on.
+	C() <-chan time.Time
+	// Stop prevents the timer from firing. It reports whether the timer
+	// was still pending.
+	Stop() bool
+}
+
+// Ticker is a periodic event of a Clock, like time.Ticker.
+type Ticker interface {
+	// C returns the channel the ticks are sent on.
+	C() <-chan time.Time
+	// Stop turns the ticker off.
+	Stop()
+}
+
+// WithClock makes the client use clock instead of the system time for
+// rate limiting, retries and every other wait.
+func WithClock(clock Clock) Option {
+	return func(c *Client) {
+		c.clock = clock
+	}
+}
+
+// realClock is the Clock of the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t realTimer) Stop() bool {
+	return t.t.Stop()
+}
+
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t realTicker) Stop() {
+	t.t.Stop()
+}
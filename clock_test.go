@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock whose time only moves on Advance.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+	// added is closed and replaced whenever a timer is added.
+	added chan struct{}
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), added: make(chan struct{})}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) Timer {
+	return c.add(d, 0)
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) Ticker {
+	return fakeTicker{c.add(d, d)}
+}
+
+func (c *fakeClock) add(d, period time.Duration) *fakeTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &fakeTimer{clock: c, at: c.now.Add(d), period: period, c: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.c <- c.now
+		return t
+	}
+	c.timers = append(c.timers, t)
+	close(c.added)
+	c.added = make(chan struct{})
+	return t
+}
+
+// Advance moves the time forward by d, firing the timers due by then.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		for !t.at.After(c.now) {
+			select {
+			case t.c <- t.at:
+			default:
+				// Like time.Ticker, a slow receiver misses ticks.
+			}
+			if t.period == 0 {
+				break
+			}
+			t.at = t.at.Add(t.period)
+		}
+		if t.at.After(c.now) {
+			pending = append(pending, t)
+		}
+	}
+	c.timers = pending
+}
+
+// waitTimers waits until at least n timers are pending, for the code under
+// test to get to the wait Advance should end.
+func (c *fakeClock) waitTimers(t *testing.T, n int) {
+	t.Helper()
+
+	deadline := time.After(time.Second * 5)
+	for {
+		c.mu.Lock()
+		pending, added := len(c.timers), c.added
+		c.mu.Unlock()
+		if pending >= n {
+			return
+		}
+		select {
+		case <-added:
+		case <-deadline:
+			t.Fatalf("expected %d pending timers, got %d", n, pending)
+		}
+	}
+}
+
+// fakeTimer is a Timer of a fakeClock, or a ticker if it has a period.
+type fakeTimer struct {
+	clock  *fakeClock
+	at     time.Time
+	period time.Duration
+	c      chan time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	for i, pending := range t.clock.timers {
+		if pending == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// fakeTicker is a fakeTimer with a period as a Ticker.
+type fakeTicker struct {
+	*fakeTimer
+}
+
+func (t fakeTicker) Stop() {
+	t.fakeTimer.Stop()
+}
+
+func TestClientFakeClock(t *testing.T) {
+	const p = time.Hour
+	clock := newFakeClock()
+	service := &recordingService{n: 2, p: p}
+	client := NewClient(service, WithClock(clock))
+	slots := recordSlots(client.limiter)
+	start := clock.Now()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- client.ProcessAll(context.Background(), make(Batch, 7))
+	}()
+
+	// The first sub-batch goes out immediately, each of the others after
+	// an interval of virtual time.
+	for i := 1; i < 4; i++ {
+		clock.waitTimers(t, 1)
+		if n := len(service.recorded()); n != i {
+			t.Fatalf("expected %d calls before the interval %d passed, got %d", i, i, n)
+		}
+		clock.Advance(p)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	got := slots()
+	if len(got) != 4 {
+		t.Fatalf("expected 4 slots, got %v", got)
+	}
+	for i, slot := range got {
+		if want := start.Add(time.Duration(i) * p); !slot.Equal(want) {
+			t.Errorf("slot %d: expected %v, got %v", i, want, slot)
+		}
+	}
+	if last := client.Stats().LastProcessTime; !last.Equal(start.Add(3 * p)) {
+		t.Errorf("expected the last process time in virtual time, got %v", last)
+	}
+}
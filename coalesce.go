@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// WithCoalesce makes Run merge small queued batches into larger ones before
+// processing them, so that the service gets fewer Process calls. Once Run
+// dequeues a batch it waits up to window for more until they add up to
+// the service n, then processes them as one batch with the items in
+// enqueue order. Every merged batch is finished with the outcome of the
+// whole merged batch, which is processed with the context of the first
+// one. The merged batch gets an ID of its own, which AuditMerged records
+// and BatchReport.Merged link the merged batches to. Batches with their own
+// limits or an ID to cancel them with are never merged. Zero or less means
+// no coalescing.
+func WithCoalesce(window time.Duration) Option {
+	return func(c *Client) {
+		c.coalesce = window
+	}
+}
+
+// mergeable reports whether j may be coalesced with other jobs.
+func (c *Client) mergeable(j *job) bool {
+	return j.n == 0 && j.p == 0 && j.tier == "" && !c.cancels.has(j.id)
+}
+
+// coalesceJobs merges first with the jobs dequeued after it within the
+// coalescing window until they have n items, and returns the jobs to
+// dispatch in order: the merged job, followed by a job that couldn't be
+// merged, if any.
+func (c *Client) coalesceJobs(ctx context.Context, first *job) []*job {
+	if !c.mergeable(first) {
+		return []*job{first}
+	}
+
+	timer := c.clock.NewTimer(c.coalesce)
+	defer timer.Stop()
+
+	jobs := []*job{first}
+	items := len(first.batch)
+	var rest *job
+	for {
+		n, _ := c.limits()
+		if uint64(items) >= c.chunkSize(n) {
+			break
+		}
+
+		j := c.queue.pop()
+		if j == nil {
+			if !c.waitQueued(ctx, timer.C()) {
+				break
+			}
+			continue
+		}
+		if !c.mergeable(j) {
+			rest = j
+			break
+		}
+		jobs = append(jobs, j)
+		items += len(j.batch)
+	}
+
+	merged := first
+	if len(jobs) > 1 {
+		batch := make(Batch, 0, items)
+		for _, j := range jobs {
+			batch = append(batch, j.batch...)
+		}
+		merged = &job{
+			// The merged batch is named in the audit trail and in
+			// the reports, AuditMerged links the jobs to it.
+			id:       newID(),
+			batch:    batch,
+			priority: first.priority,
+			enqueued: first.enqueued,
+			ctx:      first.ctx,
+			merged:   jobs,
+		}
+		for _, j := range jobs {
+			c.recordMerged(j, merged.id)
+		}
+	}
+	if rest != nil {
+		return []*job{merged, rest}
+	}
+	return []*job{merged}
+}
+
+// waitQueued waits for a job to be queued. It returns false if timeout
+// fires, ctx is done or the client is closed first.
+func (c *Client) waitQueued(ctx context.Context, timeout <-chan time.Time) bool {
+	// Make room for a job even in a queue without capacity.
+	c.queue.receiving(true)
+	defer c.queue.receiving(false)
+
+	select {
+	case <-c.queue.ready:
+		return true
+	case <-timeout:
+	case <-ctx.Done():
+	case <-c.closing:
+	}
+	return false
+}
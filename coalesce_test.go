@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestClientCoalesce(t *testing.T) {
+	service := &recordingService{n: 4, p: time.Millisecond}
+	client := NewClient(service, WithCoalesce(time.Millisecond*50))
+
+	results := make([]<-chan error, 5)
+	for i := range results {
+		results[i] = client.ProcessWithResult(numberedBatch(i, 1))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	for _, result := range results {
+		if err := receiveResult(t, result); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The first four batches fill a sub-batch, the fifth waits out the
+	// window on its own.
+	calls := service.recorded()
+	if len(calls) != 2 {
+		t.Fatalf("expected 2 calls, got %d", len(calls))
+	}
+	if got := fmt.Sprint(ids(calls[0].batch), ids(calls[1].batch)); got != "[0 1 2 3] [4]" {
+		t.Errorf("expected the items in enqueue order, got %v", got)
+	}
+}
+
+func TestClientCoalesceWindow(t *testing.T) {
+	service := &recordingService{n: 10, p: time.Millisecond}
+	client := NewClient(service, WithCoalesce(time.Millisecond*100))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	// The batches arrive one by one, all within the window of the first.
+	for i := 0; i < 5; i++ {
+		if err := client.Process(numberedBatch(i, 1)); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+	if err := client.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := service.recorded()
+	if len(calls) != 1 || len(calls[0].batch) != 5 {
+		t.Errorf("expected a single call of 5 items, got %v", calls)
+	}
+}
+
+func TestClientCoalesceUnmergeable(t *testing.T) {
+	service := &recordingService{n: 10, p: time.Millisecond}
+	client := NewClient(service, WithCoalesce(time.Millisecond*20), WithOrdered(true))
+
+	if err := client.Process(numberedBatch(0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.ProcessWithLimits(numberedBatch(1, 2), 1, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Process(numberedBatch(3, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+	if err := client.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The batch with its own limits stops the merging and keeps its place.
+	var got []string
+	for _, c := range service.recorded() {
+		got = append(got, fmt.Sprint(ids(c.batch)))
+	}
+	if fmt.Sprint(got) != "[[0] [1] [2] [3]]" {
+		t.Errorf("expected the batch with limits processed on its own in order, got %v", got)
+	}
+}
+
+func TestClientCoalesceAudit(t *testing.T) {
+	sink := &memoryAuditSink{}
+	reports := make(chan BatchReport, 1)
+	service := &recordingService{n: 2, p: time.Millisecond}
+	client := NewClient(service,
+		WithCoalesce(time.Millisecond*50),
+		WithAuditSink(sink),
+		WithOnComplete(func(r BatchReport) { reports <- r }),
+	)
+
+	results := []<-chan error{
+		client.ProcessWithResult(numberedBatch(0, 1)),
+		client.ProcessWithResult(numberedBatch(1, 1)),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+	for _, result := range results {
+		if err := receiveResult(t, result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	report := <-reports
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	// Every batch links to the merged one, whose ID the processing
+	// events carry.
+	var merged []string
+	for _, rec := range sink.records {
+		switch rec.Event {
+		case AuditMerged:
+			if rec.MergedInto != report.ID {
+				t.Errorf("expected batch %s merged into %q, got %q", rec.BatchID, report.ID, rec.MergedInto)
+			}
+			merged = append(merged, rec.BatchID)
+		case AuditAttempt, AuditSucceeded:
+			if rec.BatchID == "" || rec.BatchID != report.ID {
+				t.Errorf("expected the %s record of the merged batch %q, got %q", rec.Event, report.ID, rec.BatchID)
+			}
+		}
+	}
+	if len(merged) != 2 || fmt.Sprint(merged) != fmt.Sprint(report.Merged) {
+		t.Errorf("expected the merged batches %v in the audit trail, got %v", report.Merged, merged)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidConfig reports if a Config breaks one of its invariants.
+var ErrInvalidConfig = errors.New("invalid config")
+
+// Config gathers the tunables of a client in one place for
+// NewClientFromConfig, e.g. to load them from a file at startup.
+// The fields are passed to the options named after them, e.g. Workers to
+// WithWorkers and Retry to WithRetryPolicy, see there for their meaning.
+// Start from DefaultConfig, as the zero values are those of the options,
+// not the client defaults. Hooks, such as the logger or the dead-letter
+// hook, are still passed as options.
+type Config struct {
+	// N and P are the service limits. NewClientFromConfig takes them from
+	// the service if they are zero.
+	N uint64
+	P time.Duration
+
+	QueueCapacity  int
+	MaxQueuedItems int
+	Retry          RetryPolicy
+	ProcessTimeout time.Duration
+
+	ChunkSize         uint64
+	AdaptiveChunkSize time.Duration
+	MaxWeight         uint64
+	Burst             int
+	Jitter            float64
+	WaitFirst         bool
+	ItemRateLimit     bool
+	Smoothing         uint64
+	Tiers             []Tier
+
+	Workers              int
+	Ordered              bool
+	InFlightLimit        int
+	MaxConcurrentBatches int
+	FailFast             bool
+	Dedup                bool
+	BatchTTL             time.Duration
+	MaxBatchDuration     time.Duration
+	Coalesce             time.Duration
+	BatchIDWindow        time.Duration
+	StatusTTL            time.Duration
+
+	BlockedCooldown     time.Duration
+	HealthCheckInterval time.Duration
+	LimitsRefresh       time.Duration
+	ThroughputWindow    time.Duration
+	ErrorRateWindow     int
+	// ErrorRateThreshold and ErrorRateCooldown are the arguments of
+	// WithErrorRateValve, which is off if ErrorRateThreshold is zero.
+	ErrorRateThreshold float64
+	ErrorRateCooldown  time.Duration
+}
+
+// DefaultConfig returns the configuration of a client created by NewClient
+// without options, but for the service limits.
+func DefaultConfig() Config {
+	return Config{
+		QueueCapacity:       defaultQueueCapacity,
+		BlockedCooldown:     defaultBlockedCooldown,
+		HealthCheckInterval: defaultHealthInterval,
+		ThroughputWindow:    defaultThroughputWindow,
+		ErrorRateWindow:     defaultErrorRateWindow,
+		StatusTTL:           defaultStatusTTL,
+	}
+}
+
+// Validate checks the invariants of cfg, returning an error wrapping
+// ErrInvalidConfig for every field breaking them.
+func (cfg Config) Validate() error {
+	var errs []error
+	check := func(ok bool, field string, value any, want string) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%w: %s must be %s, got %v", ErrInvalidConfig, field, want, value))
+		}
+	}
+	check(cfg.N > 0, "N", cfg.N, "positive")
+	check(cfg.P > 0, "P", cfg.P, "positive")
+	check(cfg.Retry.MaxAttempts >= 0, "Retry.MaxAttempts", cfg.Retry.MaxAttempts, "non-negative")
+	check(cfg.ErrorRateThreshold >= 0 && cfg.ErrorRateThreshold <= 1, "ErrorRateThreshold", cfg.ErrorRateThreshold, "within [0, 1]")
+	for _, f := range []struct {
+		field string
+		value int
+	}{
+		{"QueueCapacity", cfg.QueueCapacity},
+		{"MaxQueuedItems", cfg.MaxQueuedItems},
+		{"Burst", cfg.Burst},
+		{"Workers", cfg.Workers},
+		{"InFlightLimit", cfg.InFlightLimit},
+		{"MaxConcurrentBatches", cfg.MaxConcurrentBatches},
+		{"ErrorRateWindow", cfg.ErrorRateWindow},
+	} {
+		check(f.value >= 0, f.field, f.value, "non-negative")
+	}
+	check(cfg.Jitter >= 0, "Jitter", cfg.Jitter, "non-negative")
+	names := make(map[string]bool, len(cfg.Tiers))
+	for i, tier := range cfg.Tiers {
+		field := fmt.Sprintf("Tiers[%d]", i)
+		check(tier.Name != "" && !names[tier.Name], field+".Name", fmt.Sprintf("%q", tier.Name), "unique and non-empty")
+		check(tier.Share > 0, field+".Share", tier.Share, "positive")
+		names[tier.Name] = true
+	}
+	for _, f := range []struct {
+		field string
+		value time.Duration
+	}{
+		{"Retry.BaseDelay", cfg.Retry.BaseDelay},
+		{"Retry.MaxDelay", cfg.Retry.MaxDelay},
+		{"ProcessTimeout", cfg.ProcessTimeout},
+		{"AdaptiveChunkSize", cfg.AdaptiveChunkSize},
+		{"BatchTTL", cfg.BatchTTL},
+		{"MaxBatchDuration", cfg.MaxBatchDuration},
+		{"Coalesce", cfg.Coalesce},
+		{"BatchIDWindow", cfg.BatchIDWindow},
+		{"StatusTTL", cfg.StatusTTL},
+		{"BlockedCooldown", cfg.BlockedCooldown},
+		{"HealthCheckInterval", cfg.HealthCheckInterval},
+		{"LimitsRefresh", cfg.LimitsRefresh},
+		{"ThroughputWindow", cfg.ThroughputWindow},
+		{"ErrorRateCooldown", cfg.ErrorRateCooldown},
+	} {
+		check(f.value >= 0, f.field, f.value, "non-negative")
+	}
+	return errors.Join(errs...)
+}
+
+// NewClientFromConfig creates a new client to the external service
+// configured by cfg, followed by opts for what cfg doesn't cover.
+// It takes the limits missing from cfg from the service and fails if the
+// resulting configuration is invalid, see Config.Validate.
+func NewClientFromConfig(service Service, cfg Config, opts ...Option) (*Client, error) {
+	if cfg.N == 0 || cfg.P == 0 {
+		n, p := service.GetLimits()
+		if cfg.N == 0 {
+			cfg.N = n
+		}
+		if cfg.P == 0 {
+			cfg.P = p
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	options := []Option{
+		withLimits(cfg.N, cfg.P),
+		WithQueueCapacity(cfg.QueueCapacity),
+		WithMaxQueuedItems(cfg.MaxQueuedItems),
+		WithRetryPolicy(cfg.Retry),
+		WithProcessTimeout(cfg.ProcessTimeout),
+		WithChunkSize(cfg.ChunkSize),
+		WithAdaptiveChunkSize(cfg.AdaptiveChunkSize),
+		WithMaxWeight(cfg.MaxWeight),
+		WithBurst(cfg.Burst),
+		WithJitter(cfg.Jitter),
+		WithWaitFirst(cfg.WaitFirst),
+		WithItemRateLimit(cfg.ItemRateLimit),
+		WithSmoothing(cfg.Smoothing),
+		WithTiers(cfg.Tiers...),
+		WithWorkers(cfg.Workers),
+		WithOrdered(cfg.Ordered),
+		WithInFlightLimit(cfg.InFlightLimit),
+		WithMaxConcurrentBatches(cfg.MaxConcurrentBatches),
+		WithFailFast(cfg.FailFast),
+		WithDedup(cfg.Dedup),
+		WithBatchTTL(cfg.BatchTTL),
+		WithMaxBatchDuration(cfg.MaxBatchDuration),
+		WithCoalesce(cfg.Coalesce),
+		WithBatchIDWindow(cfg.BatchIDWindow),
+		WithStatusTTL(cfg.StatusTTL),
+		WithBlockedCooldown(cfg.BlockedCooldown),
+		WithHealthCheckInterval(cfg.HealthCheckInterval),
+		WithLimitsRefresh(cfg.LimitsRefresh),
+		WithThroughputWindow(cfg.ThroughputWindow),
+		WithErrorRateWindow(cfg.ErrorRateWindow),
+		WithErrorRateValve(cfg.ErrorRateThreshold, cfg.ErrorRateCooldown),
+	}
+	return NewClient(service, append(options, opts...)...), nil
+}
+
+// withLimits makes the client use the limits n and p instead of those
+// reported by the service, until they are refreshed.
+func withLimits(n uint64, p time.Duration) Option {
+	return func(c *Client) {
+		c.n, c.p = n, p
+		c.limiter.setInterval(p)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigValidate(t *testing.T) {
+	valid := DefaultConfig()
+	valid.N, valid.P = 2, time.Millisecond
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("expected the default config to be valid, got %v", err)
+	}
+
+	tests := []struct {
+		field  string
+		modify func(cfg *Config)
+	}{
+		{"N", func(cfg *Config) { cfg.N = 0 }},
+		{"P", func(cfg *Config) { cfg.P = 0 }},
+		{"P", func(cfg *Config) { cfg.P = -time.Second }},
+		{"QueueCapacity", func(cfg *Config) { cfg.QueueCapacity = -1 }},
+		{"MaxQueuedItems", func(cfg *Config) { cfg.MaxQueuedItems = -1 }},
+		{"Retry.MaxAttempts", func(cfg *Config) { cfg.Retry.MaxAttempts = -1 }},
+		{"Retry.BaseDelay", func(cfg *Config) { cfg.Retry.BaseDelay = -time.Second }},
+		{"Retry.MaxDelay", func(cfg *Config) { cfg.Retry.MaxDelay = -time.Second }},
+		{"ProcessTimeout", func(cfg *Config) { cfg.ProcessTimeout = -time.Second }},
+		{"AdaptiveChunkSize", func(cfg *Config) { cfg.AdaptiveChunkSize = -time.Second }},
+		{"Burst", func(cfg *Config) { cfg.Burst = -1 }},
+		{"Jitter", func(cfg *Config) { cfg.Jitter = -0.1 }},
+		{"Workers", func(cfg *Config) { cfg.Workers = -1 }},
+		{"InFlightLimit", func(cfg *Config) { cfg.InFlightLimit = -1 }},
+		{"MaxConcurrentBatches", func(cfg *Config) { cfg.MaxConcurrentBatches = -1 }},
+		{"BatchTTL", func(cfg *Config) { cfg.BatchTTL = -time.Second }},
+		{"MaxBatchDuration", func(cfg *Config) { cfg.MaxBatchDuration = -time.Second }},
+		{"Coalesce", func(cfg *Config) { cfg.Coalesce = -time.Second }},
+		{"BatchIDWindow", func(cfg *Config) { cfg.BatchIDWindow = -time.Second }},
+		{"StatusTTL", func(cfg *Config) { cfg.StatusTTL = -time.Second }},
+		{"BlockedCooldown", func(cfg *Config) { cfg.BlockedCooldown = -time.Second }},
+		{"HealthCheckInterval", func(cfg *Config) { cfg.HealthCheckInterval = -time.Second }},
+		{"LimitsRefresh", func(cfg *Config) { cfg.LimitsRefresh = -time.Second }},
+		{"ThroughputWindow", func(cfg *Config) { cfg.ThroughputWindow = -time.Second }},
+		{"ErrorRateWindow", func(cfg *Config) { cfg.ErrorRateWindow = -1 }},
+		{"ErrorRateThreshold", func(cfg *Config) { cfg.ErrorRateThreshold = 1.5 }},
+		{"ErrorRateThreshold", func(cfg *Config) { cfg.ErrorRateThreshold = -0.5 }},
+		{"ErrorRateCooldown", func(cfg *Config) { cfg.ErrorRateCooldown = -time.Second }},
+		{"Tiers[0].Name", func(cfg *Config) { cfg.Tiers = []Tier{{Share: 1}} }},
+		{"Tiers[1].Name", func(cfg *Config) { cfg.Tiers = []Tier{{Name: "high", Share: 1}, {Name: "high", Share: 1}} }},
+		{"Tiers[0].Share", func(cfg *Config) { cfg.Tiers = []Tier{{Name: "high"}} }},
+	}
+	for _, tt := range tests {
+		cfg := valid
+		tt.modify(&cfg)
+		err := cfg.Validate()
+		if !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("%s: expected %v, got %v", tt.field, ErrInvalidConfig, err)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.field+" must be") {
+			t.Errorf("%s: expected the error to name the field, got %q", tt.field, err)
+		}
+	}
+
+	// Every invalid field is reported at once.
+	cfg := valid
+	cfg.N, cfg.Workers = 0, -1
+	if err := cfg.Validate(); !strings.Contains(err.Error(), "N must be") || !strings.Contains(err.Error(), "Workers must be") {
+		t.Errorf("expected both fields to be reported, got %q", err)
+	}
+}
+
+func TestNewClientFromConfig(t *testing.T) {
+	service := &recordingService{n: 5, p: time.Second}
+
+	cfg := DefaultConfig()
+	cfg.QueueCapacity = 3
+	cfg.Retry = RetryPolicy{MaxAttempts: 4, BaseDelay: time.Millisecond}
+	cfg.Workers = 2
+	client, err := NewClientFromConfig(service, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The limits missing from the config come from the service.
+	if n, p := client.limits(); n != 5 || p != time.Second {
+		t.Errorf("expected the service limits, got n=%d p=%v", n, p)
+	}
+	if client.capacity != 3 || client.retry != cfg.Retry || client.workers != 2 {
+		t.Errorf("expected the config to be applied, got capacity %d retry %+v workers %d", client.capacity, client.retry, client.workers)
+	}
+
+	cfg.N, cfg.P = 2, time.Millisecond
+	client, err = NewClientFromConfig(service, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, p := client.limits(); n != 2 || p != time.Millisecond {
+		t.Errorf("expected the configured limits, got n=%d p=%v", n, p)
+	}
+	if interval := client.limiter.interval; interval != time.Millisecond {
+		t.Errorf("expected the limiter to follow the configured p, got %v", interval)
+	}
+
+	cfg.QueueCapacity = -1
+	if client, err := NewClientFromConfig(service, cfg); !errors.Is(err, ErrInvalidConfig) || client != nil {
+		t.Errorf("expected %v and no client, got %v, %v", ErrInvalidConfig, client, err)
+	}
+	// Invalid service limits fail as early.
+	if _, err := NewClientFromConfig(&recordingService{n: 0, p: time.Second}, DefaultConfig()); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("expected %v for invalid service limits, got %v", ErrInvalidConfig, err)
+	}
+}
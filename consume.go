@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ConsumeFrom collects the items received from src into batches and
+// submits them with Process, so that a stream of items is processed in
+// rate-limited batches. A batch is submitted once it has batchSize items
+// or flushInterval after its first item, whichever comes first; batchSize
+// less than 1 means 1 and zero or less flushInterval means no interval.
+//
+// ConsumeFrom returns nil once src is closed and the context error once
+// ctx is done, submitting the items collected so far either way. It stops
+// with an error wrapping the Process error if a batch is not accepted, in
+// which case the items of that batch are lost; with BackpressureBlock it
+// waits for room in the queue instead.
+func (c *Client) ConsumeFrom(ctx context.Context, src <-chan Item, batchSize int, flushInterval time.Duration) error {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	var batch Batch
+	// timer, if set, fires flushInterval after the first item of batch.
+	var timer Timer
+	var due <-chan time.Time
+	flush := func() error {
+		if timer != nil {
+			timer.Stop()
+			timer, due = nil, nil
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		err := c.Process(batch)
+		batch = nil
+		if err != nil {
+			return fmt.Errorf("consume: %w", err)
+		}
+		return nil
+	}
+
+	for {
+		select {
+		case item, ok := <-src:
+			if !ok {
+				return flush()
+			}
+			batch = append(batch, item)
+			if len(batch) >= batchSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			} else if len(batch) == 1 && flushInterval > 0 {
+				timer = c.clock.NewTimer(flushInterval)
+				due = timer.C()
+			}
+		case <-due:
+			if err := flush(); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			if err := flush(); err != nil {
+				return err
+			}
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// queuedSizes pops the batches queued in client and returns their sizes.
+func queuedSizes(client *Client) []int {
+	var sizes []int
+	for j := client.queue.pop(); j != nil; j = client.queue.pop() {
+		sizes = append(sizes, len(j.batch))
+	}
+	return sizes
+}
+
+// waitQueueLength waits until client has n batches queued.
+func waitQueueLength(t *testing.T, client *Client, n int) {
+	t.Helper()
+
+	deadline := time.After(time.Second * 5)
+	for client.Stats().QueueLength < n {
+		select {
+		case <-deadline:
+			t.Fatalf("expected %d queued batches, got %d", n, client.Stats().QueueLength)
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+func TestClientConsumeFrom(t *testing.T) {
+	clock := newFakeClock()
+	client := NewClient(&recordingService{n: 2, p: time.Millisecond}, WithClock(clock))
+
+	src := make(chan Item)
+	done := make(chan error, 1)
+	go func() {
+		done <- client.ConsumeFrom(context.Background(), src, 2, time.Second)
+	}()
+
+	for _, item := range numberedBatch(0, 5) {
+		src <- item
+	}
+	waitQueueLength(t, client, 2)
+
+	// The last item is flushed on the interval while the source is idle.
+	clock.waitTimers(t, 1)
+	if n := client.Stats().QueueLength; n != 2 {
+		t.Fatalf("expected the last item to wait for the interval, got %d batches", n)
+	}
+	clock.Advance(time.Second)
+	waitQueueLength(t, client, 3)
+
+	src <- Item{ID: "5"}
+	close(src)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(queuedSizes(client)); got != "[2 2 1 1]" {
+		t.Errorf("expected batches of [2 2 1 1] items, got %s", got)
+	}
+}
+
+func TestClientConsumeFromCancel(t *testing.T) {
+	client := NewClient(&recordingService{n: 2, p: time.Millisecond})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	src := make(chan Item)
+	done := make(chan error, 1)
+	go func() {
+		done <- client.ConsumeFrom(ctx, src, 10, 0)
+	}()
+
+	src <- Item{ID: "0"}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
+	if got := fmt.Sprint(queuedSizes(client)); got != "[1]" {
+		t.Errorf("expected the collected item to be submitted, got batches of %s items", got)
+	}
+}
+
+func TestClientConsumeFromQueueFull(t *testing.T) {
+	client := NewClient(&recordingService{n: 2, p: time.Millisecond}, WithQueueCapacity(1))
+
+	src := make(chan Item, 2)
+	src <- Item{ID: "0"}
+	src <- Item{ID: "1"}
+	close(src)
+	if err := client.ConsumeFrom(context.Background(), src, 1, 0); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("expected %v, got %v", ErrQueueFull, err)
+	}
+}
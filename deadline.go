@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrBatchTimeout reports if a batch took longer to process than the client
+// maximum, see WithMaxBatchDuration.
+var ErrBatchTimeout = errors.New("batch processing timed out")
+
+// WithMaxBatchDuration makes the client give up on a batch that is still
+// being processed d after it started, so that a batch with many sub-batches
+// doesn't hold a worker forever. Unlike WithProcessTimeout it limits all the
+// sub-batches of the batch along with their retries and rate limit waits.
+// The remaining sub-batches are skipped and the ones in flight are
+// cancelled. The skipped items are passed to the dead-letter hook and the
+// batch error wraps ErrUnprocessed and ErrBatchTimeout. Zero or less means
+// no limit.
+func WithMaxBatchDuration(d time.Duration) Option {
+	return func(c *Client) {
+		c.maxBatchDuration = d
+	}
+}
+
+// batchDeadline returns a copy of ctx cancelled with ErrBatchTimeout once
+// the client maximum batch duration has passed by its clock, and the
+// function releasing it.
+func (c *Client) batchDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.maxBatchDuration <= 0 {
+		return ctx, func() {}
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	timer := c.clock.NewTimer(c.maxBatchDuration)
+	go func() {
+		select {
+		case <-timer.C():
+			cancel(ErrBatchTimeout)
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		timer.Stop()
+		cancel(nil)
+	}
+}
+
+// timedOut adds ErrBatchTimeout to err if the batch of the sub-batch that
+// failed with it timed out meanwhile, so that a sub-batch cut short tells
+// why.
+func timedOut(ctx context.Context, err error) error {
+	if err == nil || !errors.Is(context.Cause(ctx), ErrBatchTimeout) || errors.Is(err, ErrBatchTimeout) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", err, ErrBatchTimeout)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestClientMaxBatchDuration(t *testing.T) {
+	const p = time.Hour
+	clock := newFakeClock()
+	service := &recordingService{n: 2, p: p}
+	letters := &deadLetters{}
+	client := NewClient(service,
+		WithClock(clock),
+		WithMaxBatchDuration(p+p/2),
+		WithDeadLetter(letters.add),
+	)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- client.ProcessAll(context.Background(), numberedBatch(0, 8))
+	}()
+	// The four sub-batches would take three intervals, the batch times out
+	// while the third one waits for the limiter.
+	clock.waitTimers(t, 2)
+	clock.Advance(p)
+	clock.waitTimers(t, 2)
+	clock.Advance(p / 2)
+
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the batch to be aborted")
+	}
+	if !errors.Is(err, ErrBatchTimeout) || !errors.Is(err, ErrUnprocessed) {
+		t.Fatalf("expected %v and %v, got %v", ErrBatchTimeout, ErrUnprocessed, err)
+	}
+	if calls := len(service.recorded()); calls != 2 {
+		t.Errorf("expected 2 calls before the timeout, got %d", calls)
+	}
+
+	batches, errs := letters.recorded()
+	if len(batches) != 2 || fmt.Sprint(ids(batches[0])) != "[4 5]" || fmt.Sprint(ids(batches[1])) != "[6 7]" {
+		t.Fatalf("expected the items after the timeout to be dead-lettered, got %v", batches)
+	}
+	for i, err := range errs {
+		if !errors.Is(err, ErrBatchTimeout) {
+			t.Errorf("dead letter %d: expected %v, got %v", i, ErrBatchTimeout, err)
+		}
+	}
+}
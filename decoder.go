@@ -0,0 +1,33 @@
+package main
+
+import (
+	"io"
+	"strings"
+)
+
+// Decoder decodes a request body into a batch, e.g. CSV rows or protobuf
+// messages into an item each.
+type Decoder interface {
+	Decode(r io.Reader) (Batch, error)
+}
+
+// DecoderFunc adapts a function to a Decoder.
+type DecoderFunc func(r io.Reader) (Batch, error)
+
+func (f DecoderFunc) Decode(r io.Reader) (Batch, error) {
+	return f(r)
+}
+
+// WithDecoder makes the HTTP handlers of the client decode request bodies
+// of mediaType, e.g. "text/csv", with d. It takes precedence over the
+// built-in JSON decoders for their media types. A decoder doesn't know the
+// limit on the items of a request, so the batch it returns is checked
+// against it once the whole body is decoded.
+func WithDecoder(mediaType string, d Decoder) Option {
+	return func(c *Client) {
+		if c.decoders == nil {
+			c.decoders = make(map[string]Decoder)
+		}
+		c.decoders[strings.ToLower(mediaType)] = d
+	}
+}
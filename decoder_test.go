@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// decodeCSV decodes a CSV row per item, its ID the first field and its
+// payload the fields as a JSON array.
+func decodeCSV(r io.Reader) (Batch, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	batch := make(Batch, len(rows))
+	for i, row := range rows {
+		payload, err := json.Marshal(row)
+		if err != nil {
+			return nil, err
+		}
+		batch[i] = Item{ID: row[0], Payload: payload}
+	}
+	return batch, nil
+}
+
+func TestHandleRequestDecoder(t *testing.T) {
+	client := NewClient(&recordingService{n: 2, p: time.Millisecond}, WithDecoder("text/csv", DecoderFunc(decodeCSV)))
+
+	req := httptest.NewRequest("POST", "/process", strings.NewReader("a,1\nb,2\nc,3\n"))
+	req.Header.Set("Content-Type", "text/csv; charset=utf-8")
+	rr := httptest.NewRecorder()
+	handleRequest(client, rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d %s", http.StatusOK, rr.Code, rr.Body)
+	}
+
+	queued := client.DrainQueue()
+	if len(queued) != 1 {
+		t.Fatalf("expected a queued batch, got %v", queued)
+	}
+	expected := Batch{
+		{ID: "a", Payload: []byte(`["a","1"]`)},
+		{ID: "b", Payload: []byte(`["b","2"]`)},
+		{ID: "c", Payload: []byte(`["c","3"]`)},
+	}
+	if !reflect.DeepEqual(queued[0], expected) {
+		t.Errorf("expected batch %v, got %v", expected, queued[0])
+	}
+
+	// JSON is still decoded by default.
+	rr = httptest.NewRecorder()
+	handleRequest(client, rr, httptest.NewRequest("POST", "/process", strings.NewReader("[1, 2]")))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected %d for JSON, got %d %s", http.StatusOK, rr.Code, rr.Body)
+	}
+}
+
+func TestHandleRequestDecoderMaxItems(t *testing.T) {
+	client := NewClient(&recordingService{n: 2, p: time.Millisecond}, WithDecoder("text/csv", DecoderFunc(decodeCSV)))
+
+	req := httptest.NewRequest("POST", "/process", strings.NewReader("a\nb\nc\n"))
+	req.Header.Set("Content-Type", "text/csv")
+	if _, err := decodeRequest(req, 2, client.decoders); !errors.Is(err, ErrTooManyItems) {
+		t.Errorf("expected ErrTooManyItems, got %v", err)
+	}
+}
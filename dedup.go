@@ -0,0 +1,30 @@
+package main
+
+// WithDedup makes the client drop items with the same ID as an earlier item
+// of their batch before processing it, so that the service gets every item
+// once. The remaining items keep their order. Items without an ID are
+// never dropped as they can't be told apart.
+func WithDedup(dedup bool) Option {
+	return func(c *Client) {
+		c.dedup = dedup
+	}
+}
+
+// dedupBatch returns batch without the items whose non-empty IDs occurred
+// earlier in it. batch itself is left untouched.
+func dedupBatch(batch Batch) Batch {
+	seen := make(map[string]struct{}, len(batch))
+	unique := make(Batch, 0, len(batch))
+	for _, item := range batch {
+		if item.ID == "" {
+			unique = append(unique, item)
+			continue
+		}
+		if _, ok := seen[item.ID]; ok {
+			continue
+		}
+		seen[item.ID] = struct{}{}
+		unique = append(unique, item)
+	}
+	return unique
+}
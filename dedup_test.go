@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestClientDedup(t *testing.T) {
+	batch := Batch{{ID: "1"}, {ID: "2"}, {ID: "1"}, {ID: ""}, {ID: "3"}, {ID: "2"}, {ID: ""}}
+
+	tests := []struct {
+		name  string
+		dedup bool
+		want  []string
+	}{
+		{name: "off", dedup: false, want: []string{"1", "2", "1", "", "3", "2", ""}},
+		{name: "on", dedup: true, want: []string{"1", "2", "", "3", ""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &recordingService{n: 10, p: time.Millisecond}
+			client := NewClient(service, WithDedup(tt.dedup))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go client.Run(ctx)
+
+			receiveResult(t, client.ProcessWithResult(batch))
+
+			var got []string
+			for _, c := range service.recorded() {
+				for _, item := range c.batch {
+					got = append(got, item.ID)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected items %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
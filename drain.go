@@ -0,0 +1,25 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrDrained reports if a batch was removed from the queue by DrainQueue.
+var ErrDrained = errors.New("batch drained from queue")
+
+// DrainQueue removes every batch waiting in the queue and returns them in
+// the order they would have been processed, e.g. to inspect the backlog or
+// to move it to another client. Batches already in flight are left alone.
+// The removed batches are not passed to the dead-letter hook as the caller
+// takes them over. They are finished with an error wrapping ErrUnprocessed
+// and ErrDrained. It is safe to call while Process and Run are running.
+func (c *Client) DrainQueue() []Batch {
+	jobs := c.queue.popAll()
+	batches := make([]Batch, len(jobs))
+	for i, j := range jobs {
+		batches[i] = j.batch
+		j.finish(fmt.Errorf("%w: %w", ErrUnprocessed, ErrDrained))
+	}
+	return batches
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestClientDrainQueue(t *testing.T) {
+	client := NewClient(&recordingService{n: 2, p: time.Millisecond})
+
+	result := client.ProcessWithResult(numberedBatch(0, 1))
+	if err := client.ProcessWithPriority(numberedBatch(1, 2), 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Process(numberedBatch(3, 3)); err != nil {
+		t.Fatal(err)
+	}
+
+	batches := client.DrainQueue()
+	if len(batches) != 3 {
+		t.Fatalf("expected 3 batches, got %d", len(batches))
+	}
+	// The batch with the higher priority would have been processed first.
+	for i, want := range []string{"1", "0", "3"} {
+		if got := batches[i][0].ID; got != want {
+			t.Errorf("batch %d: expected it to start with item %s, got %s", i, want, got)
+		}
+	}
+	if got := client.Stats().QueueLength; got != 0 {
+		t.Errorf("expected an empty queue, got %d batches", got)
+	}
+	if err := receiveResult(t, result); !errors.Is(err, ErrDrained) || !errors.Is(err, ErrUnprocessed) {
+		t.Errorf("expected %v and %v, got %v", ErrDrained, ErrUnprocessed, err)
+	}
+	if batches := client.DrainQueue(); len(batches) != 0 {
+		t.Errorf("expected nothing to drain, got %v", batches)
+	}
+}
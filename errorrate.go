@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// defaultErrorRateWindow is the number of recent Process calls the error
+// rate is measured over.
+const defaultErrorRateWindow = 100
+
+// WithErrorRateWindow sets the number of recent Process calls the error
+// rate in Stats and of WithErrorRateValve is measured over,
+// defaultErrorRateWindow by default. Zero or less keeps the default.
+func WithErrorRateWindow(calls int) Option {
+	return func(c *Client) {
+		if calls > 0 {
+			c.errorRate = newErrorRate(calls)
+		}
+	}
+}
+
+// WithErrorRateValve makes the client hold processing back for cooldown
+// once more than threshold of the Process calls in the error rate window
+// failed, e.g. 0.5 for half of them. Unlike WithCircuitBreaker it trips on
+// a service failing part of the calls, not only on consecutive failures.
+// Like Pause, Run stops dequeuing and batches in flight hold before their
+// next Process call. The window is measured afresh after the cooldown.
+// Calls failing with ErrBlocked or because the client is shutting down
+// don't count.
+func WithErrorRateValve(threshold float64, cooldown time.Duration) Option {
+	return func(c *Client) {
+		c.valveThreshold = threshold
+		c.valveCooldown = cooldown
+	}
+}
+
+// errorRate keeps the outcomes of the last Process calls in a ring.
+type errorRate struct {
+	mu sync.Mutex
+	// failed are the outcomes, next is where the next one goes.
+	failed   []bool
+	next     int
+	calls    int
+	failures int
+}
+
+func newErrorRate(window int) *errorRate {
+	return &errorRate{failed: make([]bool, window)}
+}
+
+// add records the outcome of a call. It returns the error rate and reports
+// whether the window is full.
+func (r *errorRate) add(failed bool) (float64, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.calls == len(r.failed) {
+		if r.failed[r.next] {
+			r.failures--
+		}
+	} else {
+		r.calls++
+	}
+	r.failed[r.next] = failed
+	if failed {
+		r.failures++
+	}
+	r.next = (r.next + 1) % len(r.failed)
+	return float64(r.failures) / float64(r.calls), r.calls == len(r.failed)
+}
+
+// rate returns the ratio of failed calls in the window, zero if there
+// were none.
+func (r *errorRate) rate() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.calls == 0 {
+		return 0
+	}
+	return float64(r.failures) / float64(r.calls)
+}
+
+// reset forgets all calls.
+func (r *errorRate) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.failed {
+		r.failed[i] = false
+	}
+	r.next, r.calls, r.failures = 0, 0, 0
+}
+
+// observeCall records the outcome of a Process call made with ctx in the
+// error rate, closing the valve if it crosses the threshold.
+func (c *Client) observeCall(ctx context.Context, err error) {
+	if errors.Is(err, ErrBlocked) || ctx.Err() != nil {
+		return
+	}
+	rate, full := c.errorRate.add(err != nil)
+	if c.valveThreshold <= 0 || !full || rate <= c.valveThreshold {
+		return
+	}
+	if !c.valve.block() {
+		return
+	}
+	c.logger.Errorf("Error rate of %.0f%% crossed the threshold, holding processing back for %v", rate*100, c.valveCooldown)
+
+	go func() {
+		timer := c.clock.NewTimer(c.valveCooldown)
+		defer timer.Stop()
+		select {
+		case <-timer.C():
+		case <-c.done:
+		}
+		c.errorRate.reset()
+		if c.valve.unblock() {
+			c.logger.Infof("Processing resumed after the error rate cooldown")
+		}
+	}()
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// patternService fails the calls whose zero-based number modulo the length
+// of fail is set in fail.
+type patternService struct {
+	fail []bool
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (s *patternService) GetLimits() (uint64, time.Duration) {
+	return 1, time.Millisecond
+}
+
+func (s *patternService) Process(ctx context.Context, batch Batch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	call := s.calls
+	s.calls++
+	if s.fail[call%len(s.fail)] {
+		return errors.New("degraded")
+	}
+	return nil
+}
+
+func (s *patternService) called() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls
+}
+
+func TestClientErrorRateValve(t *testing.T) {
+	clock := newFakeClock()
+	// 3 out of every 5 calls fail.
+	service := &patternService{fail: []bool{false, true, true, true, false}}
+	logger := &fakeLogger{}
+	client := NewClient(service,
+		WithClock(clock),
+		// The calls go out at once, so that none waits for virtual time.
+		WithBurst(100),
+		WithLogger(logger),
+		WithErrorRateWindow(10),
+		WithErrorRateValve(0.5, time.Minute),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	if err := client.ProcessAndWait(ctx, numberedBatch(0, 10)); err == nil {
+		t.Fatal("expected the failed sub-batches to fail the batch")
+	}
+	if rate := client.Stats().ErrorRate; rate != 0.6 {
+		t.Errorf("expected an error rate of 0.6, got %v", rate)
+	}
+	if n := len(logger.logged("ERROR")); n == 0 {
+		t.Error("expected the valve to be logged")
+	}
+
+	// The valve is closed for the cooldown, so the next batch waits.
+	result := client.ProcessWithResult(numberedBatch(10, 1))
+	select {
+	case err := <-result:
+		t.Fatalf("expected the batch to wait for the cooldown, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if calls := service.called(); calls != 10 {
+		t.Fatalf("expected no calls during the cooldown, got %d", calls)
+	}
+
+	clock.waitTimers(t, 1)
+	clock.Advance(time.Minute)
+	if err := receiveResult(t, result); err != nil {
+		t.Fatal(err)
+	}
+	// The window starts afresh after the cooldown.
+	if rate := client.Stats().ErrorRate; rate != 0 {
+		t.Errorf("expected the error rate to be reset, got %v", rate)
+	}
+}
+
+func TestClientErrorRateBelowThreshold(t *testing.T) {
+	// 2 out of every 5 calls fail.
+	service := &patternService{fail: []bool{false, true, false, true, false}}
+	client := NewClient(service, WithBurst(100), WithErrorRateWindow(10), WithErrorRateValve(0.5, time.Hour))
+
+	if err := client.ProcessAll(context.Background(), numberedBatch(0, 20)); err == nil {
+		t.Fatal("expected the failed sub-batches to fail the batch")
+	}
+	if calls := service.called(); calls != 20 {
+		t.Errorf("expected every sub-batch to be processed, got %d calls", calls)
+	}
+	if rate := client.Stats().ErrorRate; rate != 0.4 {
+		t.Errorf("expected an error rate of 0.4, got %v", rate)
+	}
+}
+
+func TestErrorRate(t *testing.T) {
+	r := newErrorRate(4)
+	outcomes := []struct {
+		failed bool
+		rate   float64
+		full   bool
+	}{
+		{failed: true, rate: 1, full: false},
+		{failed: false, rate: 0.5, full: false},
+		{failed: false, rate: 1.0 / 3, full: false},
+		{failed: true, rate: 0.5, full: true},
+		// The first call drops out of the window.
+		{failed: false, rate: 0.25, full: true},
+		{failed: true, rate: 0.5, full: true},
+	}
+	for i, o := range outcomes {
+		rate, full := r.add(o.failed)
+		if rate != o.rate || full != o.full {
+			t.Errorf("call %d: expected rate %v full %v, got %v %v", i, o.rate, o.full, rate, full)
+		}
+	}
+	r.reset()
+	if rate := r.rate(); rate != 0 {
+		t.Errorf("expected no error rate after reset, got %v", rate)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// metricsContentType is the content type of the Prometheus text exposition
+// format.
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// metricsPrefix namespaces the metric names served by /metrics.
+const metricsPrefix = "batch_client_"
+
+// handleMetrics writes the Stats of client in the Prometheus text
+// exposition format, so that Prometheus can scrape them without the client
+// depending on its libraries.
+func handleMetrics(client *Client, w http.ResponseWriter, r *http.Request) {
+	stats := client.Stats()
+
+	var buf bytes.Buffer
+	gauge := func(name, help string, value float64) {
+		writeMetric(&buf, name, help, "gauge", value)
+	}
+	counter := func(name, help string, value float64) {
+		writeMetric(&buf, name, help, "counter", value)
+	}
+	gauge("queue_length", "Number of batches waiting in the queue.", float64(stats.QueueLength))
+	gauge("queued_items", "Number of items of the batches waiting in the queue.", float64(stats.QueuedItems))
+	gauge("in_flight_batches", "Number of batches being processed.", float64(stats.InFlightBatches))
+	counter("processed_items_total", "Number of items the service processed successfully.", float64(stats.TotalProcessed))
+	counter("errors_total", "Number of failed Process calls to the service.", float64(stats.TotalErrors))
+	counter("retries_total", "Number of Process calls retrying a failed sub-batch.", float64(stats.TotalRetries))
+	counter("throttled_seconds_total", "Time sub-batches spent waiting for the rate limiter.", stats.TotalThrottled.Seconds())
+	if !stats.LastProcessTime.IsZero() {
+		gauge("last_process_timestamp_seconds", "Time of the last Process call that processed items.",
+			float64(stats.LastProcessTime.UnixNano())/float64(time.Second))
+	}
+	gauge("chunk_size", "Size of the next sub-batch.", float64(stats.ChunkSize))
+	gauge("throughput_items_per_second", "Recent rate of successfully processed items.", stats.Throughput.ItemsPerSecond)
+	gauge("throughput_sub_batches_per_second", "Recent rate of successfully processed sub-batches.", stats.Throughput.SubBatchesPerSecond)
+	gauge("error_rate", "Ratio of failed Process calls among the recent ones.", stats.ErrorRate)
+	paused := 0.0
+	if stats.Paused {
+		paused = 1
+	}
+	gauge("paused", "Whether the client is paused.", paused)
+
+	if len(stats.BatchSizes) > 0 {
+		name := metricsPrefix + "enqueued_batches_total"
+		fmt.Fprintf(&buf, "# HELP %s Number of enqueued batches by their size bucket.\n", name)
+		fmt.Fprintf(&buf, "# TYPE %s counter\n", name)
+		for _, b := range stats.BatchSizes {
+			fmt.Fprintf(&buf, "%s{max_items=\"%d\"} %d\n", name, b.Max, b.Count)
+		}
+	}
+
+	w.Header().Set("Content-Type", metricsContentType)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		client.logger.Errorf("Error writing metrics: %v", err)
+	}
+}
+
+// writeMetric writes a metric with a single sample to buf.
+func writeMetric(buf *bytes.Buffer, name, help, typ string, value float64) {
+	name = metricsPrefix + name
+	fmt.Fprintf(buf, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(buf, "# TYPE %s %s\n", name, typ)
+	fmt.Fprintf(buf, "%s %s\n", name, strconv.FormatFloat(value, 'g', -1, 64))
+}
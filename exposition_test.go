@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	metricComment = regexp.MustCompile(`^# (HELP|TYPE) ([a-zA-Z_:][a-zA-Z0-9_:]*) (.+)$`)
+	metricSample  = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)(\{[a-zA-Z_][a-zA-Z0-9_]*="[^"]*"(,[a-zA-Z_][a-zA-Z0-9_]*="[^"]*")*\})? (\S+)$`)
+)
+
+// parseMetrics parses an exposition in the Prometheus text format into
+// the types of the metrics and the values of their samples by name and
+// labels, failing on any malformed line.
+func parseMetrics(t *testing.T, exposition string) (types map[string]string, samples map[string]float64) {
+	t.Helper()
+
+	types, samples = map[string]string{}, map[string]float64{}
+	scanner := bufio.NewScanner(strings.NewReader(exposition))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := metricComment.FindStringSubmatch(line); m != nil {
+			if m[1] == "TYPE" {
+				if m[3] != "counter" && m[3] != "gauge" {
+					t.Errorf("unexpected type in %q", line)
+				}
+				types[m[2]] = m[3]
+			}
+			continue
+		}
+		m := metricSample.FindStringSubmatch(line)
+		if m == nil {
+			t.Errorf("malformed line %q", line)
+			continue
+		}
+		if _, ok := types[m[1]]; !ok {
+			t.Errorf("sample %q before the type of its metric", line)
+		}
+		value, err := strconv.ParseFloat(m[4], 64)
+		if err != nil {
+			t.Errorf("malformed value in %q: %v", line, err)
+		}
+		samples[m[1]+m[2]] = value
+	}
+	return types, samples
+}
+
+func TestHandleMetrics(t *testing.T) {
+	client := NewClient(&recordingService{n: 2, p: time.Millisecond})
+	if err := client.ProcessAll(context.Background(), make(Batch, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Process(make(Batch, 5)); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(newMux(client, DefaultServerConfig()))
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != metricsContentType {
+		t.Errorf("expected content type %q, got %q", metricsContentType, ct)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	types, samples := parseMetrics(t, string(body))
+	expected := map[string]float64{
+		"batch_client_queue_length":                          1,
+		"batch_client_queued_items":                          5,
+		"batch_client_in_flight_batches":                     0,
+		"batch_client_processed_items_total":                 3,
+		"batch_client_errors_total":                          0,
+		"batch_client_retries_total":                         0,
+		"batch_client_chunk_size":                            2,
+		"batch_client_error_rate":                            0,
+		"batch_client_paused":                                0,
+		`batch_client_enqueued_batches_total{max_items="8"}`: 1,
+	}
+	for name, value := range expected {
+		if got, ok := samples[name]; !ok || got != value {
+			t.Errorf("expected %s %v, got %v (present: %v)", name, value, got, ok)
+		}
+	}
+	for _, name := range []string{
+		"batch_client_throttled_seconds_total",
+		"batch_client_last_process_timestamp_seconds",
+		"batch_client_throughput_items_per_second",
+		"batch_client_throughput_sub_batches_per_second",
+	} {
+		if _, ok := samples[name]; !ok {
+			t.Errorf("expected a sample of %s", name)
+		}
+	}
+	if typ := types["batch_client_processed_items_total"]; typ != "counter" {
+		t.Errorf("expected the processed items to be a counter, got %q", typ)
+	}
+	if typ := types["batch_client_queue_length"]; typ != "gauge" {
+		t.Errorf("expected the queue length to be a gauge, got %q", typ)
+	}
+}
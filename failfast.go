@@ -0,0 +1,19 @@
+package main
+
+import "errors"
+
+// ErrFailFast reports if items were skipped because an earlier sub-batch
+// of their batch failed, see WithFailFast.
+var ErrFailFast = errors.New("skipped after a failed sub-batch")
+
+// WithFailFast makes the client give up on a batch once one of its
+// sub-batches fails terminally, i.e. after its retries, instead of trying
+// the others too. The remaining sub-batches are skipped and the ones in
+// flight, with WithInFlightLimit, are cancelled. The skipped items are
+// passed to the dead-letter hook and the batch error wraps ErrUnprocessed
+// and ErrFailFast along with the error of the failed sub-batch.
+func WithFailFast(failFast bool) Option {
+	return func(c *Client) {
+		c.failFast = failFast
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientFailFast(t *testing.T) {
+	for _, failFast := range []bool{false, true} {
+		name := "best effort"
+		if failFast {
+			name = "fail fast"
+		}
+		t.Run(name, func(t *testing.T) {
+			service := &failingService{
+				recordingService: recordingService{n: 1, p: time.Millisecond},
+				fail:             map[string]bool{"1": true},
+			}
+			letters := &deadLetters{}
+			client := NewClient(service, WithFailFast(failFast), WithDeadLetter(letters.add))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go client.Run(ctx)
+
+			// The second of the four sub-batches fails.
+			err := receiveResult(t, client.ProcessWithResult(numberedBatch(0, 4)))
+			if err == nil || !strings.Contains(err.Error(), "failed sub-batch starting with 1") {
+				t.Fatalf("expected the sub-batch error, got %v", err)
+			}
+
+			calls := service.recorded()
+			batches, errs := letters.recorded()
+			if !failFast {
+				if len(calls) != 4 || errors.Is(err, ErrFailFast) {
+					t.Errorf("expected every sub-batch to be attempted, got %d calls and %v", len(calls), err)
+				}
+				return
+			}
+
+			if len(calls) != 2 {
+				t.Errorf("expected the sub-batches after the failed one not to be attempted, got %d calls", len(calls))
+			}
+			if !errors.Is(err, ErrUnprocessed) || !errors.Is(err, ErrFailFast) {
+				t.Errorf("expected %v and %v, got %v", ErrUnprocessed, ErrFailFast, err)
+			}
+			if len(batches) != 2 || fmt.Sprint(ids(batches[1])) != "[2 3]" || !errors.Is(errs[1], ErrFailFast) {
+				t.Errorf("expected the skipped items to be dead-lettered, got %v: %v", batches, errs)
+			}
+		})
+	}
+}
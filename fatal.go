@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrFatal reports if the service failed with an error classified as fatal,
+// see WithFatal.
+var ErrFatal = errors.New("fatal service error")
+
+// WithFatal sets the classifier telling the errors of the service after
+// which processing is pointless, e.g. revoked credentials. A sub-batch
+// failing with a fatal error isn't retried and Run stops as if its context
+// was done: batches in flight are cancelled, the queued ones are passed
+// to the dead-letter hook, and Run returns an error wrapping ErrFatal and
+// the service error. ErrBlocked is handled before and never reaches it.
+// Without Run, e.g. with ProcessAll, only the sub-batch fails.
+// By default no error is fatal.
+func WithFatal(fatal func(err error) bool) Option {
+	return func(c *Client) {
+		c.fatal = fatal
+	}
+}
+
+// checkFatal returns err wrapped in ErrFatal and stops Run if err is fatal,
+// and nil otherwise.
+func (c *Client) checkFatal(err error) error {
+	if c.fatal == nil || !c.fatal(err) {
+		return nil
+	}
+	err = fmt.Errorf("%w: %w", ErrFatal, err)
+	if stop := c.stop.Load(); stop != nil {
+		c.logger.Errorf("Stopping after a fatal error: %v", err)
+		(*stop)(err)
+	}
+	return err
+}
+
+// stoppable returns a copy of ctx that checkFatal cancels with the fatal
+// error, for Run.
+func (c *Client) stoppable(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	c.stop.Store(&cancel)
+	return ctx, func() { cancel(nil) }
+}
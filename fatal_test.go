@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var errRevoked = errors.New("credentials revoked")
+
+// revokingService fails the sub-batch starting with revokeOn with
+// errRevoked.
+type revokingService struct {
+	recordingService
+	revokeOn string
+}
+
+func (s *revokingService) Process(ctx context.Context, batch Batch) error {
+	s.recordingService.Process(ctx, batch)
+	if batch[0].ID == s.revokeOn {
+		return errRevoked
+	}
+	return nil
+}
+
+func TestClientFatalError(t *testing.T) {
+	service := &revokingService{recordingService: recordingService{n: 2, p: time.Millisecond}, revokeOn: "2"}
+	letters := &deadLetters{}
+	client := NewClient(service,
+		WithWorkers(1),
+		WithDeadLetter(letters.add),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}),
+		WithFatal(func(err error) bool { return errors.Is(err, errRevoked) }),
+	)
+
+	first := client.ProcessWithResult(numberedBatch(0, 6))
+	second := client.ProcessWithResult(numberedBatch(10, 4))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Run(context.Background())
+	}()
+
+	var runErr error
+	select {
+	case runErr = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Run to stop after the fatal error")
+	}
+	if !errors.Is(runErr, ErrFatal) || !errors.Is(runErr, errRevoked) {
+		t.Fatalf("expected Run to return %v with %v, got %v", ErrFatal, errRevoked, runErr)
+	}
+
+	// The fatal sub-batch isn't retried and nothing is processed after it.
+	if calls := len(service.recorded()); calls != 2 {
+		t.Errorf("expected 2 calls, got %d", calls)
+	}
+	if err := receiveResult(t, first); !errors.Is(err, errRevoked) || !errors.Is(err, ErrUnprocessed) {
+		t.Errorf("expected the first batch to fail with %v and the rest unprocessed, got %v", errRevoked, err)
+	}
+	if err := receiveResult(t, second); !errors.Is(err, ErrUnprocessed) || !errors.Is(err, ErrFatal) {
+		t.Errorf("expected the second batch unprocessed because of %v, got %v", ErrFatal, err)
+	}
+
+	var lettered int
+	batches, _ := letters.recorded()
+	for _, b := range batches {
+		lettered += len(b)
+	}
+	if lettered != 8 {
+		t.Errorf("expected the 8 items left to be dead-lettered, got %d", lettered)
+	}
+	if err := client.Process(numberedBatch(20, 1)); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected the client to be closed, got %v", err)
+	}
+}
+
+func TestClientFatalErrorProcessAll(t *testing.T) {
+	service := &revokingService{recordingService: recordingService{n: 2, p: time.Millisecond}, revokeOn: "0"}
+	client := NewClient(service, WithFatal(func(err error) bool { return errors.Is(err, errRevoked) }))
+
+	err := client.ProcessAll(context.Background(), numberedBatch(0, 4))
+	if !errors.Is(err, ErrFatal) || !errors.Is(err, errRevoked) {
+		t.Errorf("expected %v with %v, got %v", ErrFatal, errRevoked, err)
+	}
+	// Without Run only the sub-batch fails.
+	if calls := len(service.recorded()); calls != 2 {
+		t.Errorf("expected the other sub-batch to be processed, got %d calls", calls)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// Flush waits until every batch enqueued so far and every batch enqueued
+// while it waits has been processed, or until ctx is done. Unlike Shutdown
+// it leaves the client accepting new batches. Batches processed by
+// ProcessAll are not waited for.
+func (c *Client) Flush(ctx context.Context) error {
+	select {
+	case <-c.pending.idle():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pendingJobs counts the jobs enqueued but not finished yet.
+type pendingJobs struct {
+	mu sync.Mutex
+	n  int
+	// idleCh is closed once n drops to zero, nil while nobody waits.
+	idleCh chan struct{}
+}
+
+func (p *pendingJobs) add() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.n++
+}
+
+func (p *pendingJobs) done() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.n--
+	if p.n == 0 && p.idleCh != nil {
+		close(p.idleCh)
+		p.idleCh = nil
+	}
+}
+
+// idle returns a channel closed once there are no pending jobs.
+func (p *pendingJobs) idle() <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.n == 0 {
+		closed := make(chan struct{})
+		close(closed)
+		return closed
+	}
+	if p.idleCh == nil {
+		p.idleCh = make(chan struct{})
+	}
+	return p.idleCh
+}
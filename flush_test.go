@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestClientFlush(t *testing.T) {
+	service := &recordingService{n: 2, p: time.Millisecond * 5}
+	client := NewClient(service, WithWorkers(2))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	for i := 0; i < 3; i++ {
+		if err := client.Process(numberedBatch(i*4, 4)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	flushCtx, cancelFlush := context.WithTimeout(ctx, time.Second)
+	defer cancelFlush()
+	if err := client.Flush(flushCtx); err != nil {
+		t.Fatal(err)
+	}
+	if calls := len(service.recorded()); calls != 6 {
+		t.Errorf("expected 6 calls before Flush returned, got %d", calls)
+	}
+	if stats := client.Stats(); stats.QueueLength != 0 || stats.InFlightBatches != 0 {
+		t.Errorf("expected nothing queued or in flight, got %+v", stats)
+	}
+
+	// The client keeps accepting batches.
+	receiveResult(t, client.ProcessWithResult(numberedBatch(12, 2)))
+	if err := client.Flush(flushCtx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientFlushTimeout(t *testing.T) {
+	client := NewClient(&testService{n: 2, p: time.Millisecond})
+	if err := client.Process(Batch{Item{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing runs the queue.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	if err := client.Flush(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
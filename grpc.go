@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// The gRPC front-end has a single Batcher service:
+//
+//	service Batcher {
+//	  rpc Submit(BatchRequest) returns (BatchResponse);
+//	}
+//
+// The messages are encoded as JSON rather than protobuf, so that items keep
+// the same format as over HTTP and the service needs no generated code.
+// Callers use the "json" content subtype, e.g. with
+// grpc.CallContentSubtype("json").
+
+// grpcSubmitMethod is the full name of the Submit method.
+const grpcSubmitMethod = "/batcher.Batcher/Submit"
+
+// BatchRequest is the request of the Submit method.
+type BatchRequest struct {
+	// Items are the JSON values of the items, as the elements of
+	// a JSON array posted over HTTP.
+	Items []json.RawMessage `json:"items"`
+	// BatchID is the caller's own ID for the batch, if any, as for
+	// Client.ProcessWithBatchID. A batch resubmitted with the same ID is
+	// rejected with AlreadyExists.
+	BatchID string `json:"batch_id,omitempty"`
+}
+
+// BatchResponse is the response of the Submit method, with the same
+// fields as the response of /process.
+type BatchResponse struct {
+	// ID identifies the batch in the queue, see Client.Status.
+	ID string `json:"id"`
+	// Accepted is the number of items enqueued.
+	Accepted int `json:"accepted"`
+	// Invalid is the number of invalid items dropped under
+	// ServerConfig.LenientItems.
+	Invalid int `json:"invalid,omitempty"`
+	// Duplicates is the number of items dropped by WithDedup.
+	Duplicates int `json:"duplicates,omitempty"`
+}
+
+// grpcRequestIDKey is the metadata key carrying the ID of a Submit
+// request, the counterpart of the X-Request-ID header.
+const grpcRequestIDKey = "x-request-id"
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// jsonCodec encodes gRPC messages as JSON.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+// batcherServer is the gRPC counterpart of requestHandler.
+type batcherServer struct {
+	client *Client
+	// maxItems is the maximum number of items in a request,
+	// zero or less means no limit.
+	maxItems int
+	// lenient makes the server drop invalid items instead of rejecting
+	// the whole request.
+	lenient bool
+}
+
+// Submit enqueues the batch in req for processing by the client.
+// It rejects the same requests as requestHandler does, and takes the
+// request ID from the x-request-id metadata the same way, returning it in
+// the x-request-id header.
+func (s *batcherServer) Submit(ctx context.Context, req *BatchRequest) (*BatchResponse, error) {
+	client := s.client
+
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(grpcRequestIDKey); len(values) > 0 {
+			id = values[0]
+		}
+	}
+	if id == "" {
+		id = newID()
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(grpcRequestIDKey, id))
+	ctx = withRequestID(ctx, id)
+	logger := client.loggerFor(ctx)
+
+	if s.maxItems > 0 && len(req.Items) > s.maxItems {
+		logger.Infof("Bad request: %v", ErrTooManyItems)
+		return nil, status.Error(codes.ResourceExhausted, "too many items")
+	}
+
+	batch := make(Batch, len(req.Items))
+	for i, raw := range req.Items {
+		batch[i] = newItem(raw)
+	}
+	batch, invalid, duplicates, err := client.admit(logger, batch, s.lenient)
+	if errors.Is(err, errInvalidItems) {
+		return nil, status.Errorf(codes.InvalidArgument, "%d invalid items", invalid)
+	}
+	if errors.Is(err, ErrEmptyBatch) {
+		return nil, status.Error(codes.InvalidArgument, "empty batch")
+	}
+
+	j := &job{batch: batch, ctx: ctx}
+	if req.BatchID != "" {
+		j.id, j.named = req.BatchID, true
+	}
+	if err := client.enqueueRequest(j, defaultEnqueueTimeout); err != nil {
+		logger.Errorf("Error enqueuing batch of %d items: %v", len(batch), err)
+		switch {
+		case errors.Is(err, errEnqueueTimeout):
+			return nil, status.Error(codes.Unavailable, "queue is full")
+		case errors.Is(err, ErrQueueFull), errors.Is(err, ErrTooManyItems):
+			return nil, status.Error(codes.ResourceExhausted, "queue is full")
+		case errors.Is(err, ErrDuplicate):
+			return nil, status.Error(codes.AlreadyExists, "duplicate batch ID")
+		case errors.Is(err, ErrClosed):
+			return nil, status.Error(codes.Unavailable, "client is closed")
+		case errors.Is(err, context.Canceled):
+			return nil, status.Error(codes.Canceled, "request cancelled")
+		case errors.Is(err, context.DeadlineExceeded):
+			return nil, status.Error(codes.DeadlineExceeded, "request cancelled")
+		default:
+			return nil, status.Error(codes.Internal, "enqueue batch error")
+		}
+	}
+	return &BatchResponse{ID: j.id, Accepted: len(batch), Invalid: invalid, Duplicates: duplicates}, nil
+}
+
+// batcherServiceDesc describes the Batcher service to grpc.Server.
+var batcherServiceDesc = grpc.ServiceDesc{
+	ServiceName: "batcher.Batcher",
+	HandlerType: (*interface {
+		Submit(context.Context, *BatchRequest) (*BatchResponse, error)
+	})(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Submit",
+		Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+			req := new(BatchRequest)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			s := srv.(*batcherServer)
+			if interceptor == nil {
+				return s.Submit(ctx, req)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: grpcSubmitMethod}
+			return interceptor(ctx, req, info, func(ctx context.Context, req any) (any, error) {
+				return s.Submit(ctx, req.(*BatchRequest))
+			})
+		},
+	}},
+	Metadata: "batcher",
+}
+
+// newGRPCServer creates a gRPC server submitting batches to client with
+// the request settings of cfg.
+func newGRPCServer(client *Client, cfg ServerConfig, opts ...grpc.ServerOption) *grpcServer {
+	server := grpc.NewServer(opts...)
+	server.RegisterService(&batcherServiceDesc, &batcherServer{client: client, maxItems: cfg.MaxRequestItems, lenient: cfg.LenientItems})
+	return &grpcServer{server}
+}
+
+// grpcServer adapts grpc.Server to the frontend interface.
+type grpcServer struct {
+	*grpc.Server
+}
+
+// Shutdown stops the server gracefully, waiting for pending requests until
+// ctx is done, when it closes the remaining connections.
+func (s *grpcServer) Shutdown(ctx context.Context) error {
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		s.Stop()
+		return fmt.Errorf("grpc: %w", ctx.Err())
+	}
+}
+
+// Close stops the server right away.
+func (s *grpcServer) Close() error {
+	s.Stop()
+	return nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// dialBatcher starts a gRPC server for client with the request settings
+// of cfg on an in-memory connection and returns a connection to it.
+func dialBatcher(t *testing.T, client *Client, cfg ServerConfig) *grpc.ClientConn {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+	server := newGRPCServer(client, cfg)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.CallContentSubtype("json")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func submit(conn *grpc.ClientConn, items ...string) (*BatchResponse, error) {
+	req := &BatchRequest{}
+	for _, item := range items {
+		req.Items = append(req.Items, json.RawMessage(item))
+	}
+	var resp BatchResponse
+	err := conn.Invoke(context.Background(), grpcSubmitMethod, req, &resp)
+	return &resp, err
+}
+
+func TestGRPCSubmit(t *testing.T) {
+	client := NewClient(&testService{n: 2, p: time.Millisecond})
+	conn := dialBatcher(t, client, DefaultServerConfig())
+
+	resp, err := submit(conn, `{"id":"a"}`, `2`, `"c"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Accepted != 3 {
+		t.Errorf("expected 3 items accepted, got %d", resp.Accepted)
+	}
+	if got := client.Stats().QueueLength; got != 1 {
+		t.Fatalf("expected the batch to be enqueued, queue length is %d", got)
+	}
+
+	j := client.queue.pop()
+	if j == nil {
+		t.Fatal("expected a queued batch")
+	}
+	for i, want := range []string{"a", "2", "c"} {
+		if got := j.batch[i].ID; got != want {
+			t.Errorf("item %d: expected ID %q, got %q", i, want, got)
+		}
+	}
+}
+
+func TestGRPCSubmitRejected(t *testing.T) {
+	client := NewClient(&testService{n: 2, p: time.Millisecond}, WithQueueCapacity(1))
+	conn := dialBatcher(t, client, DefaultServerConfig())
+
+	tests := []struct {
+		name  string
+		items []string
+		code  codes.Code
+	}{
+		{"empty", nil, codes.InvalidArgument},
+		{"invalid", []string{`1`, `null`}, codes.InvalidArgument},
+		{"accepted", []string{`1`}, codes.OK},
+		{"queue full", []string{`2`}, codes.ResourceExhausted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := submit(conn, tt.items...)
+			if code := status.Code(err); code != tt.code {
+				t.Errorf("expected %v, got %v", tt.code, err)
+			}
+		})
+	}
+
+	client.close()
+	if _, err := submit(conn, `3`); status.Code(err) != codes.Unavailable {
+		t.Errorf("expected %v, got %v", codes.Unavailable, err)
+	}
+}
+
+func TestGRPCSubmitRequestID(t *testing.T) {
+	client := NewClient(&testService{n: 2, p: time.Millisecond})
+	conn := dialBatcher(t, client, DefaultServerConfig())
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), grpcRequestIDKey, "req-42")
+	var header metadata.MD
+	var resp BatchResponse
+	req := &BatchRequest{Items: []json.RawMessage{json.RawMessage(`1`)}}
+	if err := conn.Invoke(ctx, grpcSubmitMethod, req, &resp, grpc.Header(&header)); err != nil {
+		t.Fatal(err)
+	}
+	if got := header.Get(grpcRequestIDKey); len(got) != 1 || got[0] != "req-42" {
+		t.Errorf("expected the request ID of the request, got %v", got)
+	}
+
+	j := client.queue.pop()
+	if j == nil {
+		t.Fatal("expected a queued batch")
+	}
+	if resp.ID == "" || resp.ID != j.id {
+		t.Errorf("expected the ID %q of the queued batch, got %q", j.id, resp.ID)
+	}
+	if id, _ := RequestID(j.ctx); id != "req-42" {
+		t.Errorf("expected the batch to carry the request ID, got %q", id)
+	}
+
+	header = nil
+	if err := conn.Invoke(context.Background(), grpcSubmitMethod, req, &resp, grpc.Header(&header)); err != nil {
+		t.Fatal(err)
+	}
+	if got := header.Get(grpcRequestIDKey); len(got) != 1 || got[0] == "" {
+		t.Errorf("expected a generated request ID, got %v", got)
+	}
+}
+
+func TestGRPCSubmitBatchID(t *testing.T) {
+	client := NewClient(&testService{n: 2, p: time.Millisecond}, WithDedup(true))
+	conn := dialBatcher(t, client, DefaultServerConfig())
+
+	req := &BatchRequest{
+		Items:   []json.RawMessage{json.RawMessage(`{"id":"a"}`), json.RawMessage(`{"id":"a"}`), json.RawMessage(`{"id":"b"}`)},
+		BatchID: "order-1",
+	}
+	var resp BatchResponse
+	if err := conn.Invoke(context.Background(), grpcSubmitMethod, req, &resp); err != nil {
+		t.Fatal(err)
+	}
+	expected := BatchResponse{ID: "order-1", Accepted: 2, Duplicates: 1}
+	if resp != expected {
+		t.Errorf("expected response %+v, got %+v", expected, resp)
+	}
+
+	err := conn.Invoke(context.Background(), grpcSubmitMethod, req, &resp)
+	if code := status.Code(err); code != codes.AlreadyExists {
+		t.Errorf("expected %v for the resubmitted batch, got %v", codes.AlreadyExists, err)
+	}
+	if got := client.Stats().QueueLength; got != 1 {
+		t.Errorf("expected only the first batch to be enqueued, queue length is %d", got)
+	}
+}
+
+func TestGRPCSubmitRequestSettings(t *testing.T) {
+	cfg := DefaultServerConfig()
+	cfg.MaxRequestItems = 3
+	cfg.LenientItems = true
+	client := NewClient(&testService{n: 2, p: time.Millisecond})
+	conn := dialBatcher(t, client, cfg)
+
+	resp, err := submit(conn, `1`, `null`, `2`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Accepted != 2 || resp.Invalid != 1 {
+		t.Errorf("expected 2 items accepted and 1 dropped, got %+v", resp)
+	}
+	if _, err := submit(conn, `1`, `2`, `3`, `4`); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected %v, got %v", codes.ResourceExhausted, err)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// defaultHealthInterval is how often Run checks an unhealthy service again.
+const defaultHealthInterval = time.Second
+
+// HealthChecker is implemented by services that can tell whether they are
+// able to process batches. The client checks a service implementing it
+// before dequeuing every batch, and while it is unhealthy batches wait in
+// the queue. Services not implementing it are assumed to be healthy.
+type HealthChecker interface {
+	// Healthy returns nil if the service is healthy.
+	Healthy(ctx context.Context) error
+}
+
+// WithHealthCheckInterval sets how often Run checks the health of an
+// unhealthy service again, see HealthChecker. Zero or less means the
+// default of a second.
+func WithHealthCheckInterval(interval time.Duration) Option {
+	return func(c *Client) {
+		if interval <= 0 {
+			interval = defaultHealthInterval
+		}
+		c.healthInterval = interval
+	}
+}
+
+// checkHealth returns the error of the service health check, if the
+// service is a HealthChecker.
+func (c *Client) checkHealth(ctx context.Context) error {
+	if h, ok := c.service.(HealthChecker); ok {
+		return h.Healthy(ctx)
+	}
+	return nil
+}
+
+// waitHealthy waits until the service is healthy before Run dequeues,
+// checking it every health interval. It returns false if ctx is done or
+// the client is closed first.
+func (c *Client) waitHealthy(ctx context.Context) bool {
+	for {
+		err := c.checkHealth(ctx)
+		if err == nil {
+			return true
+		}
+		c.logger.Infof("Service is unhealthy, checking again in %v: %v", c.healthInterval, err)
+
+		timer := c.clock.NewTimer(c.healthInterval)
+		select {
+		case <-timer.C():
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		case <-c.closing:
+			timer.Stop()
+			return false
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// healthService is a recordingService reporting its health.
+type healthService struct {
+	recordingService
+	healthy atomic.Bool
+}
+
+func (s *healthService) Healthy(ctx context.Context) error {
+	if !s.healthy.Load() {
+		return errors.New("unhealthy")
+	}
+	return nil
+}
+
+func TestClientHealthCheck(t *testing.T) {
+	service := &healthService{recordingService: recordingService{n: 2, p: time.Millisecond}}
+	client := NewClient(service, WithHealthCheckInterval(time.Millisecond*5))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	if err := client.Process(numberedBatch(0, 3)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 50)
+
+	rr := httptest.NewRecorder()
+	handleReadyz(client, rr, httptest.NewRequest("GET", "/readyz", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected %v while the service is unhealthy, got %v", http.StatusServiceUnavailable, rr.Code)
+	}
+	if calls := len(service.recorded()); calls != 0 {
+		t.Fatalf("expected no calls while the service is unhealthy, got %d", calls)
+	}
+
+	service.healthy.Store(true)
+	waitCalls(t, &service.recordingService, 2, time.Second)
+
+	rr = httptest.NewRecorder()
+	handleReadyz(client, rr, httptest.NewRequest("GET", "/readyz", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected %v once the service is healthy, got %v", http.StatusOK, rr.Code)
+	}
+}
+
+func TestClientHealthCheckShutdown(t *testing.T) {
+	service := &healthService{recordingService: recordingService{n: 2, p: time.Millisecond}}
+	client := NewClient(service, WithHealthCheckInterval(time.Hour))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	if err := client.Process(numberedBatch(0, 2)); err != nil {
+		t.Fatal(err)
+	}
+	// Wait for Run to block on the health check.
+	time.Sleep(time.Millisecond * 20)
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(ctx, time.Second)
+	defer cancelShutdown()
+	if err := client.Shutdown(shutdownCtx); err != nil {
+		t.Fatalf("expected Shutdown not to wait for the health check, got %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+// WithOnEnqueue makes the client call fn with every batch accepted by the
+// queue. Run waits for the call before it takes the batch up, so it comes
+// before the OnDequeue call of the batch. A batch the queue rejects isn't
+// passed to fn. fn is called on the goroutine of the caller of Process, so
+// it should be fast.
+func WithOnEnqueue(fn func(batch Batch)) Option {
+	return func(c *Client) {
+		c.onEnqueue = fn
+	}
+}
+
+// WithOnDequeue makes the client call fn with every batch Run takes from the
+// queue to process. Batches merged by WithCoalesce are passed one by one.
+// fn is called by Run before it takes the next batch, so it should be fast.
+func WithOnDequeue(fn func(batch Batch)) Option {
+	return func(c *Client) {
+		c.onDequeue = fn
+	}
+}
+
+// queueHook calls hook with batch, if set. A panic of the hook is logged
+// and otherwise ignored, so that it doesn't take Run down.
+func (c *Client) queueHook(what string, hook func(batch Batch), batch Batch) {
+	if hook == nil {
+		return
+	}
+	var err error
+	defer recoverPanic(c.logger, what, &err)
+	hook(batch)
+}
+
+// dequeued passes j, taken up by Run, to the OnDequeue hook once it has
+// been announced to the OnEnqueue hook.
+func (c *Client) dequeued(j *job) {
+	if j.announced != nil {
+		<-j.announced
+	}
+	c.queueHook("OnDequeue", c.onDequeue, j.batch)
+}
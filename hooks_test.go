@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientQueueHooks(t *testing.T) {
+	var mu sync.Mutex
+	var events []string
+	hook := func(name string) func(Batch) {
+		return func(batch Batch) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, fmt.Sprint(name, ids(batch)))
+		}
+	}
+	service := &recordingService{n: 2, p: time.Millisecond}
+	client := NewClient(service, WithOnEnqueue(hook("enqueue")), WithOnDequeue(hook("dequeue")))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	for _, batch := range []Batch{numberedBatch(0, 3), numberedBatch(3, 1)} {
+		if err := receiveResult(t, client.ProcessWithResult(batch)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []string{"enqueue[0 1 2]", "dequeue[0 1 2]", "enqueue[3]", "dequeue[3]"}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("expected events %v, got %v", expected, events)
+	}
+}
+
+func TestClientQueueHookPanic(t *testing.T) {
+	logger := &fakeLogger{}
+	client := NewClient(&recordingService{n: 2, p: time.Millisecond},
+		WithLogger(logger),
+		WithOnDequeue(func(Batch) { panic("boom") }),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	// Run survives the hook and goes on processing the batch.
+	if err := receiveResult(t, client.ProcessWithResult(numberedBatch(0, 2))); err != nil {
+		t.Fatal(err)
+	}
+	if len(logger.logged("ERROR")) == 0 {
+		t.Error("expected the panic to be logged")
+	}
+}
+
+func TestClientOnEnqueueRejected(t *testing.T) {
+	var mu sync.Mutex
+	var enqueued []string
+	client := NewClient(&recordingService{n: 2, p: time.Millisecond},
+		WithQueueCapacity(1),
+		WithOnEnqueue(func(batch Batch) {
+			mu.Lock()
+			defer mu.Unlock()
+			enqueued = append(enqueued, fmt.Sprint(ids(batch)))
+		}),
+	)
+
+	if err := client.Process(numberedBatch(0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Process(numberedBatch(1, 1)); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expected %v, got %v", ErrQueueFull, err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if fmt.Sprint(enqueued) != "[[0]]" {
+		t.Errorf("expected only the queued batch to be announced, got %v", enqueued)
+	}
+}
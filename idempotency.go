@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type idempotencyKeyContextKey struct{}
+
+// IdempotencyKey returns the idempotency key of the sub-batch passed to
+// Service.Process along with ctx. Every sub-batch gets its own key, which
+// stays the same across the retries of the sub-batch, so Process may skip
+// a sub-batch it has already processed. When only the failed items of a
+// PartialService are retried, the key of the retry is that of the
+// sub-batch followed by the positions of the items in it, e.g. "key/1,3",
+// so that it isn't taken for the call of the whole sub-batch. The keys of
+// a batch submitted with ProcessWithBatchID are derived from its ID and
+// the offsets of the items, so they stay the same for the same items when
+// the batch is submitted again.
+func IdempotencyKey(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(idempotencyKeyContextKey{}).(string)
+	return key, ok
+}
+
+// withIdempotencyKey returns a copy of ctx carrying the idempotency key of
+// the sub-batch sub.
+func withIdempotencyKey(ctx context.Context, sub subBatchRange) context.Context {
+	key := newID()
+	if sub.named {
+		key = fmt.Sprintf("%s/%d:%d", sub.batch, sub.start, sub.end)
+	}
+	return context.WithValue(ctx, idempotencyKeyContextKey{}, key)
+}
+
+// withPartialKey returns a copy of ctx whose idempotency key is that of
+// the sub-batch in ctx narrowed down to the items at offsets, the
+// positions of the items sent in the sub-batch.
+func withPartialKey(ctx context.Context, offsets []int) context.Context {
+	key, ok := IdempotencyKey(ctx)
+	if !ok {
+		return ctx
+	}
+	positions := make([]string, len(offsets))
+	for i, offset := range offsets {
+		positions[i] = strconv.Itoa(offset)
+	}
+	return context.WithValue(ctx, idempotencyKeyContextKey{}, key+"/"+strings.Join(positions, ","))
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// keyService fails the first attempt of every sub-batch and records the
+// idempotency keys of all attempts by the first item ID of the sub-batch.
+type keyService struct {
+	mu   sync.Mutex
+	keys map[string][]string
+}
+
+func (s *keyService) GetLimits() (uint64, time.Duration) {
+	return 2, time.Millisecond
+}
+
+func (s *keyService) Process(ctx context.Context, batch Batch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, _ := IdempotencyKey(ctx)
+	id := batch[0].ID
+	s.keys[id] = append(s.keys[id], key)
+	if len(s.keys[id]) == 1 {
+		return errors.New("temporary failure")
+	}
+	return nil
+}
+
+func TestClientIdempotencyKey(t *testing.T) {
+	service := &keyService{keys: map[string][]string{}}
+	client := NewClient(service, WithRetryPolicy(RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}))
+
+	if err := client.ProcessAll(context.Background(), numberedBatch(0, 4)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(service.keys) != 2 {
+		t.Fatalf("expected 2 sub-batches, got %d", len(service.keys))
+	}
+	seen := map[string]bool{}
+	for id, keys := range service.keys {
+		if len(keys) != 2 {
+			t.Fatalf("sub-batch %s: expected 2 attempts, got %d", id, len(keys))
+		}
+		if keys[0] == "" || keys[0] != keys[1] {
+			t.Errorf("sub-batch %s: expected the same key on every attempt, got %q", id, keys)
+		}
+		if seen[keys[0]] {
+			t.Errorf("sub-batch %s: key %q is shared with another sub-batch", id, keys[0])
+		}
+		seen[keys[0]] = true
+	}
+}
+
+// partialKeyService fails items 1 and 3 on the first call, item 3 on the
+// second one, and records the idempotency key of every call.
+type partialKeyService struct {
+	mu   sync.Mutex
+	keys []string
+}
+
+func (s *partialKeyService) GetLimits() (uint64, time.Duration) {
+	return 4, time.Millisecond
+}
+
+func (s *partialKeyService) Process(ctx context.Context, batch Batch) error {
+	return errors.New("unexpected Process call")
+}
+
+func (s *partialKeyService) ProcessItems(ctx context.Context, batch Batch) ([]ItemResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, _ := IdempotencyKey(ctx)
+	s.keys = append(s.keys, key)
+	results := make([]ItemResult, len(batch))
+	for i, item := range batch {
+		if item.ID == "3" || item.ID == "1" && len(s.keys) == 1 {
+			results[i].Err = errors.New("temporary failure")
+		}
+	}
+	if len(s.keys) == 3 {
+		results = make([]ItemResult, len(batch))
+	}
+	return results, nil
+}
+
+func TestClientIdempotencyKeyPartialRetry(t *testing.T) {
+	service := &partialKeyService{}
+	client := NewClient(service, WithRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}))
+
+	if err := client.ProcessAll(context.Background(), numberedBatch(0, 4)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every retry tells the items it carries apart from the earlier calls.
+	keys := service.keys
+	if len(keys) != 3 || keys[0] == "" || keys[1] != keys[0]+"/1,3" || keys[2] != keys[0]+"/3" {
+		t.Errorf("expected the keys of the retries narrowed down to the failed items, got %q", keys)
+	}
+}
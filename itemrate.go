@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// WithItemRateLimit makes the client limit the rate of items rather than
+// of sub-batches: instead of one sub-batch per p it sends as many as keep
+// the items of any p long window within n. Sub-batches smaller than n, such
+// as the last ones of batches, then leave room for more items in the same
+// window. WithBurst and WithJitter don't apply to it.
+func WithItemRateLimit(enabled bool) Option {
+	return func(c *Client) {
+		c.items = nil
+		if enabled {
+			c.items = &itemLimiter{clock: realClock{}}
+		}
+	}
+}
+
+// itemLimiter spaces calls to the external service so that no more than
+// n items are sent within any window, however many goroutines share it.
+type itemLimiter struct {
+	mu     sync.Mutex
+	n      uint64
+	window time.Duration
+	// calls are the calls still counting towards the window of the next
+	// call, in order.
+	calls []itemCall
+	clock Clock
+
+	// reserved, if set, is called with every slot handed out, in order.
+	reserved func(slot time.Time, items int)
+}
+
+// itemCall is a call of items made at a slot.
+type itemCall struct {
+	at    time.Time
+	items uint64
+}
+
+// setLimits changes the limits for the calls not reserved yet.
+func (l *itemLimiter) setLimits(n uint64, window time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.n, l.window = n, window
+}
+
+// reserve returns the earliest slot from now on, but not before the slots
+// handed out already, in which a call of items keeps every window within
+// the limit, and records the call.
+func (l *itemLimiter) reserve(now time.Time, items uint64) time.Time {
+	slot := now
+	if last := len(l.calls) - 1; last >= 0 && l.calls[last].at.After(slot) {
+		slot = l.calls[last].at
+	}
+
+	for {
+		// Calls a window before the slot no longer count, neither will
+		// they for the later slots.
+		for len(l.calls) > 0 && !l.calls[0].at.Add(l.window).After(slot) {
+			l.calls = l.calls[1:]
+		}
+		var sum uint64
+		for _, c := range l.calls {
+			sum += c.items
+		}
+		// A call larger than n still goes once the window is empty.
+		if len(l.calls) == 0 || sum+items <= l.n {
+			break
+		}
+		slot = l.calls[0].at.Add(l.window)
+	}
+
+	l.calls = append(l.calls, itemCall{at: slot, items: items})
+	return slot
+}
+
+// Wait blocks until the caller may make a call of items or ctx is done.
+func (l *itemLimiter) Wait(ctx context.Context, items int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	now := l.clock.Now()
+	slot := l.reserve(now, uint64(items))
+	if l.reserved != nil {
+		l.reserved(slot, items)
+	}
+	l.mu.Unlock()
+
+	return sleep(ctx, l.clock, slot.Sub(now))
+}
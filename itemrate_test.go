@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// checkItemWindows checks that the calls of items made at slots never
+// have more than n items within a window.
+func checkItemWindows(t *testing.T, slots []time.Time, items []int, n int, window time.Duration) {
+	t.Helper()
+
+	for i := range slots {
+		sum := 0
+		for j := 0; j <= i; j++ {
+			if slots[i].Sub(slots[j]) < window {
+				sum += items[j]
+			}
+		}
+		if sum > n {
+			t.Errorf("call %d: %d items within a window, expected at most %d", i, sum, n)
+		}
+	}
+}
+
+func TestClientItemRateLimit(t *testing.T) {
+	service := &recordingService{n: 4, p: time.Millisecond * 20}
+	client := NewClient(service, WithItemRateLimit(true), WithChunkSize(3))
+
+	var mu sync.Mutex
+	var slots []time.Time
+	var items []int
+	client.items.reserved = func(slot time.Time, n int) {
+		mu.Lock()
+		defer mu.Unlock()
+		slots = append(slots, slot)
+		items = append(items, n)
+	}
+
+	// Sub-batches of 3, 3, 3 and 1 items, then 2, 2.
+	if err := client.ProcessAll(context.Background(), make(Batch, 10)); err != nil {
+		t.Fatal(err)
+	}
+	client.chunk = 2
+	if err := client.ProcessAll(context.Background(), make(Batch, 4)); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(slots) != 6 {
+		t.Fatalf("expected 6 calls, got %d", len(slots))
+	}
+	checkItemWindows(t, slots, items, int(service.n), service.p)
+
+	// The last sub-batch of the first batch fits the window of the one
+	// before, and so does the second sub-batch of 2 items.
+	if d := slots[3].Sub(slots[2]); d >= service.p {
+		t.Errorf("expected the sub-batch of 1 item to share the window, it waited %v", d)
+	}
+	if d := slots[5].Sub(slots[4]); d >= service.p {
+		t.Errorf("expected the second sub-batch of 2 items to share the window, it waited %v", d)
+	}
+}
+
+func TestItemLimiterReserve(t *testing.T) {
+	l := &itemLimiter{}
+	l.setLimits(4, time.Second)
+	start := time.Now()
+
+	want := []struct {
+		items uint64
+		at    time.Duration
+	}{
+		{items: 3, at: 0},
+		{items: 1, at: 0},
+		{items: 2, at: time.Second},
+		{items: 2, at: time.Second},
+		{items: 3, at: time.Second * 2},
+		// Larger than n, it waits for an empty window.
+		{items: 5, at: time.Second * 3},
+		{items: 1, at: time.Second * 4},
+	}
+	for i, w := range want {
+		if got := l.reserve(start, w.items).Sub(start); got != w.at {
+			t.Errorf("call %d of %d items: expected a slot at %v, got %v", i, w.items, w.at, got)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// limiter spaces calls to the external service so that they happen no more
+// often than once per interval, however many goroutines share it.
+// It is a token bucket refilled with a token per interval: up to burst
+// calls saved up while idle may go out right away.
+type limiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	burst    int
+	// jitter is the largest random extra delay added to an interval
+	// as a fraction of it.
+	jitter float64
+	// waitFirst makes the first call wait for an interval too.
+	waitFirst bool
+	clock     Clock
+	// next is when the bucket has no saved-up calls any more if no call
+	// is made till then. The slot of the next call is up to burst-1
+	// intervals earlier.
+	next time.Time
+
+	// reserved, if set, is called with every slot handed out, in order.
+	// Tests use it to check the spacing without depending on scheduling.
+	reserved func(slot time.Time)
+}
+
+// newLimiter creates a limiter allowing one call per interval without
+// bursts. The first call is allowed immediately.
+func newLimiter(interval time.Duration) *limiter {
+	return &limiter{interval: interval, burst: 1, clock: realClock{}}
+}
+
+// setBurst changes the number of calls allowed to go out at once.
+// Values less than 1 mean 1.
+func (l *limiter) setBurst(burst int) {
+	if burst < 1 {
+		burst = 1
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.burst = burst
+}
+
+// setJitter makes every interval longer by a random fraction of it up to
+// jitter. Values less than 0 mean 0.
+func (l *limiter) setJitter(jitter float64) {
+	if jitter < 0 {
+		jitter = 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.jitter = jitter
+}
+
+// setWaitFirst makes the first call wait for an interval like the others
+// instead of going out immediately.
+func (l *limiter) setWaitFirst(waitFirst bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.waitFirst = waitFirst
+}
+
+// setInterval changes the interval for the calls not reserved yet.
+func (l *limiter) setInterval(interval time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.interval = interval
+}
+
+// Wait blocks until the caller may make the next call or ctx is done.
+func (l *limiter) Wait(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	now := l.clock.Now()
+	if l.next.IsZero() && l.waitFirst {
+		// The slot of the first call is an interval from now.
+		l.next = now.Add(time.Duration(l.burst) * l.interval)
+	}
+	if l.next.Before(now) {
+		l.next = now
+	}
+	slot := l.next.Add(-time.Duration(l.burst-1) * l.interval)
+	if slot.Before(now) {
+		slot = now
+	}
+	interval := l.interval
+	if l.jitter > 0 {
+		interval += time.Duration(rand.Float64() * l.jitter * float64(interval))
+	}
+	l.next = l.next.Add(interval)
+	if l.reserved != nil {
+		l.reserved(slot)
+	}
+	l.mu.Unlock()
+
+	return sleep(ctx, l.clock, slot.Sub(now))
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// call is a single Process call seen by recordingService.
+type call struct {
+	at    time.Time
+	batch Batch
+}
+
+// recordingService records every Process call it gets.
+type recordingService struct {
+	n uint64
+	p time.Duration
+
+	mu    sync.Mutex
+	calls []call
+}
+
+func (s *recordingService) GetLimits() (uint64, time.Duration) {
+	return s.n, s.p
+}
+
+func (s *recordingService) Process(ctx context.Context, batch Batch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls = append(s.calls, call{at: time.Now(), batch: batch})
+	return nil
+}
+
+func (s *recordingService) recorded() []call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]call(nil), s.calls...)
+}
+
+// waitCalls waits until service has got at least n calls.
+func waitCalls(t *testing.T, service *recordingService, n int, timeout time.Duration) []call {
+	t.Helper()
+
+	deadline := time.After(timeout)
+	for {
+		if calls := service.recorded(); len(calls) >= n {
+			return calls
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("expected %d calls, got %d", n, len(service.recorded()))
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+// recordSlots makes l record the slots it hands out and returns
+// a function returning the slots recorded so far.
+func recordSlots(l *limiter) func() []time.Time {
+	var mu sync.Mutex
+	var slots []time.Time
+	l.reserved = func(slot time.Time) {
+		mu.Lock()
+		defer mu.Unlock()
+		slots = append(slots, slot)
+	}
+	return func() []time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]time.Time(nil), slots...)
+	}
+}
+
+// checkSlots checks that every slot is at least p after the previous one.
+func checkSlots(t *testing.T, slots []time.Time, p time.Duration) {
+	t.Helper()
+
+	for i := 1; i < len(slots); i++ {
+		if gap := slots[i].Sub(slots[i-1]); gap < p {
+			t.Errorf("calls %d and %d are %v apart, expected at least %v", i-1, i, gap, p)
+		}
+	}
+}
+
+func TestLimiterWait(t *testing.T) {
+	const interval = time.Millisecond * 20
+	l := newLimiter(interval)
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := l.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed < interval*3 {
+		t.Errorf("expected 4 calls to take at least %v, took %v", interval*3, elapsed)
+	}
+}
+
+func TestLimiterWaitCancel(t *testing.T) {
+	l := newLimiter(time.Hour)
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+
+	if err := l.Wait(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestClientSharedRateLimit(t *testing.T) {
+	service := &recordingService{
+		n: 2,
+		p: time.Millisecond * 20,
+	}
+
+	client := NewClient(service)
+	slots := recordSlots(client.limiter)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	for i := 0; i < 3; i++ {
+		if err := client.Process(make(Batch, 4)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	calls := waitCalls(t, service, 6, time.Second)
+	checkSlots(t, slots(), service.p)
+	for i, c := range calls {
+		if uint64(len(c.batch)) > service.n {
+			t.Errorf("call %d got %d items, expected at most %d", i, len(c.batch), service.n)
+		}
+	}
+}
+
+func TestLimiterBurst(t *testing.T) {
+	const interval = time.Millisecond * 20
+	l := newLimiter(interval)
+	l.setBurst(3)
+	slots := recordSlots(l)
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := l.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := slots()
+	for i, slot := range got[:3] {
+		if d := slot.Sub(start); d > time.Millisecond*5 {
+			t.Errorf("slot %d: expected to go out right away, got %v after the start", i, d)
+		}
+	}
+	// The bucket is empty after the burst, so the next slot is a full
+	// interval after the first one and the later ones follow the rate.
+	if d := got[3].Sub(got[0]); d < interval {
+		t.Errorf("expected the call after the burst %v after the first one, got %v", interval, d)
+	}
+	checkSlots(t, got[3:], interval)
+	if elapsed := time.Since(start); elapsed < interval*2 {
+		t.Errorf("expected 5 calls with a burst of 3 to take at least %v, took %v", interval*2, elapsed)
+	}
+}
+
+func TestClientBurst(t *testing.T) {
+	service := &recordingService{n: 1, p: time.Millisecond * 20}
+	client := NewClient(service, WithBurst(2))
+	slots := recordSlots(client.limiter)
+
+	start := time.Now()
+	if err := client.ProcessAll(context.Background(), make(Batch, 4)); err != nil {
+		t.Fatal(err)
+	}
+
+	got := slots()
+	if len(got) != 4 {
+		t.Fatalf("expected 4 slots, got %d", len(got))
+	}
+	if d := got[1].Sub(start); d > time.Millisecond*5 {
+		t.Errorf("expected the first 2 sub-batches to go out right away, the second one did after %v", d)
+	}
+	if d := got[2].Sub(got[0]); d < service.p {
+		t.Errorf("expected the sub-batch after the burst %v after the first one, got %v", service.p, d)
+	}
+	checkSlots(t, got[2:], service.p)
+}
+
+func TestClientJitter(t *testing.T) {
+	const jitter = 0.5
+	service := &recordingService{n: 1, p: time.Millisecond * 10}
+	client := NewClient(service, WithJitter(jitter))
+	slots := recordSlots(client.limiter)
+
+	if err := client.ProcessAll(context.Background(), make(Batch, 10)); err != nil {
+		t.Fatal(err)
+	}
+
+	got := slots()
+	checkSlots(t, got, service.p)
+
+	gaps := make(map[time.Duration]bool)
+	var total time.Duration
+	for i := 1; i < len(got); i++ {
+		gap := got[i].Sub(got[i-1])
+		gaps[gap] = true
+		total += gap
+	}
+	if len(gaps) < 2 {
+		t.Errorf("expected the intervals to vary, got %v", gaps)
+	}
+	maxAvg := time.Duration(float64(service.p) * (1 + jitter))
+	if avg := total / time.Duration(len(got)-1); avg > maxAvg {
+		t.Errorf("expected the average interval within %v, got %v", maxAvg, avg)
+	}
+}
+
+func TestClientWaitFirst(t *testing.T) {
+	const p = time.Millisecond * 50
+	for _, waitFirst := range []bool{false, true} {
+		t.Run(fmt.Sprint(waitFirst), func(t *testing.T) {
+			service := &recordingService{n: 1, p: p}
+			client := NewClient(service, WithWaitFirst(waitFirst))
+
+			start := time.Now()
+			if err := client.ProcessAll(context.Background(), make(Batch, 2)); err != nil {
+				t.Fatal(err)
+			}
+
+			calls := service.recorded()
+			if len(calls) != 2 {
+				t.Fatalf("expected 2 calls, got %d", len(calls))
+			}
+			first := calls[0].at.Sub(start)
+			if waitFirst && first < p {
+				t.Errorf("expected the first call after %v, got %v", p, first)
+			}
+			if !waitFirst && first >= p/2 {
+				t.Errorf("expected the first call immediately, got %v", first)
+			}
+			if gap := calls[1].at.Sub(calls[0].at); gap < p {
+				t.Errorf("expected the second call %v after the first, got %v", p, gap)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidLimits reports if the service limits don't allow processing:
+// n is zero or p isn't positive.
+var ErrInvalidLimits = errors.New("invalid service limits")
+
+// WithLimitsRefresh makes Run poll the service limits every interval
+// and apply them to the batches being processed. Zero disables refreshing.
+func WithLimitsRefresh(interval time.Duration) Option {
+	return func(c *Client) {
+		c.refresh = interval
+	}
+}
+
+// limits returns the current service limits.
+func (c *Client) limits() (n uint64, p time.Duration) {
+	c.limitsMu.RLock()
+	defer c.limitsMu.RUnlock()
+	return c.n, c.p
+}
+
+// checkLimits returns an error wrapping ErrInvalidLimits if the current
+// limits don't allow processing.
+func (c *Client) checkLimits() error {
+	if n, p := c.limits(); c.chunkSize(n) == 0 || p <= 0 {
+		return fmt.Errorf("%w: n=%d p=%v", ErrInvalidLimits, n, p)
+	}
+	return nil
+}
+
+// setLimits updates the service limits used by the client.
+func (c *Client) setLimits(n uint64, p time.Duration) {
+	c.limitsMu.Lock()
+	defer c.limitsMu.Unlock()
+
+	c.n, c.p = n, p
+	c.limiter.setInterval(c.callInterval(n, p))
+	c.setTierLimits(c.callInterval(n, p))
+	if c.items != nil {
+		c.items.setLimits(n, p)
+	}
+}
+
+// refreshLimits polls the service limits until ctx is done.
+func (c *Client) refreshLimits(ctx context.Context) {
+	ticker := c.clock.NewTicker(c.refresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+
+		n, p := c.service.GetLimits()
+		if n == 0 || p <= 0 {
+			c.logger.Errorf("Ignoring invalid service limits: n=%d p=%v", n, p)
+			continue
+		}
+		if curN, curP := c.limits(); n != curN || p != curP {
+			c.logger.Infof("Service limits changed: n=%d p=%v", n, p)
+			c.setLimits(n, p)
+		}
+	}
+}
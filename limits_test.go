@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// changingService reports limits that can be changed at any time.
+type changingService struct {
+	recordingService
+
+	limitsMu sync.Mutex
+}
+
+func (s *changingService) GetLimits() (uint64, time.Duration) {
+	s.limitsMu.Lock()
+	defer s.limitsMu.Unlock()
+	return s.n, s.p
+}
+
+func (s *changingService) setLimits(n uint64, p time.Duration) {
+	s.limitsMu.Lock()
+	defer s.limitsMu.Unlock()
+	s.n, s.p = n, p
+}
+
+func TestClientLimitsRefresh(t *testing.T) {
+	service := &changingService{recordingService: recordingService{n: 2, p: time.Millisecond}}
+	client := NewClient(service, WithLimitsRefresh(time.Millisecond*5))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	receiveResult(t, client.ProcessWithResult(make(Batch, 6)))
+	service.setLimits(3, time.Millisecond*2)
+
+	deadline := time.After(time.Second)
+	for {
+		if n, p := client.limits(); n == 3 && p == time.Millisecond*2 {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("limits were not refreshed")
+		case <-time.After(time.Millisecond):
+		}
+	}
+
+	receiveResult(t, client.ProcessWithResult(make(Batch, 6)))
+
+	var sizes []int
+	for _, c := range service.recorded() {
+		sizes = append(sizes, len(c.batch))
+	}
+	want := []int{2, 2, 2, 3, 3}
+	if len(sizes) != len(want) {
+		t.Fatalf("expected sub-batches of %v items, got %v", want, sizes)
+	}
+	for i := range want {
+		if sizes[i] != want[i] {
+			t.Fatalf("expected sub-batches of %v items, got %v", want, sizes)
+		}
+	}
+}
+
+func TestClientLimitsRefreshIgnoresInvalid(t *testing.T) {
+	service := &changingService{recordingService: recordingService{n: 2, p: time.Millisecond}}
+	logger := &fakeLogger{}
+	client := NewClient(service, WithLimitsRefresh(time.Millisecond), WithLogger(logger))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	service.setLimits(0, time.Millisecond)
+	time.Sleep(time.Millisecond * 20)
+
+	if n, _ := client.limits(); n != 2 {
+		t.Errorf("expected invalid limits to be ignored, got n=%d", n)
+	}
+	if len(logger.logged("ERROR")) == 0 {
+		t.Error("expected invalid limits to be logged")
+	}
+}
+
+func TestClientProcessZeroN(t *testing.T) {
+	var letters []Batch
+	client := NewClient(&testService{n: 0, p: time.Millisecond}, WithDeadLetter(func(batch Batch, err error) {
+		letters = append(letters, batch)
+	}))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- client.ProcessAll(context.Background(), make(Batch, 3))
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrInvalidLimits) || !errors.Is(err, ErrUnprocessed) {
+			t.Errorf("expected %v and %v, got %v", ErrInvalidLimits, ErrUnprocessed, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("processing a batch with n of zero did not stop")
+	}
+	if len(letters) != 1 || len(letters[0]) != 3 {
+		t.Errorf("expected all 3 items as a dead letter, got %v", letters)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// NoopService is a Service with the given limits that returns from Process
+// right away, e.g. to measure the overhead of the client itself.
+type NoopService struct {
+	N uint64
+	P time.Duration
+}
+
+func (s NoopService) GetLimits() (uint64, time.Duration) {
+	return s.N, s.P
+}
+
+func (s NoopService) Process(ctx context.Context, batch Batch) error {
+	return nil
+}
+
+// Load describes the batches GenerateLoad submits.
+type Load struct {
+	// Batches is the number of batches to submit.
+	Batches int
+	// BatchSize is the number of items per batch.
+	BatchSize int
+	// Concurrency is the number of goroutines submitting batches.
+	// Values less than 1 mean 1.
+	Concurrency int
+}
+
+// GenerateLoad submits the batches of load to client from load.Concurrency
+// goroutines and waits until all of them are processed or ctx is done.
+// Submitting waits for room in the queue, so the queue capacity doesn't
+// have to fit the whole load. Run must be running for it to return.
+// It returns the errors of the batches joined together.
+func GenerateLoad(ctx context.Context, client *Client, load Load) error {
+	workers := load.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	batches := make(chan int)
+	errs := make([]error, load.Batches)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range batches {
+				errs[i] = submitAndWait(ctx, client, make(Batch, load.BatchSize))
+			}
+		}()
+	}
+	for i := 0; i < load.Batches; i++ {
+		batches <- i
+	}
+	close(batches)
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// submitAndWait enqueues batch waiting for room in the queue and waits
+// for its outcome.
+func submitAndWait(ctx context.Context, client *Client, batch Batch) error {
+	j := &job{batch: batch, ctx: ctx, result: make(chan error, 1)}
+	if err := client.enqueue(j, true); err != nil {
+		return err
+	}
+	select {
+	case err := <-j.result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestGenerateLoad(t *testing.T) {
+	service := &recordingService{n: 10, p: time.Microsecond}
+	client := NewClient(service, WithQueueCapacity(2))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	if err := GenerateLoad(ctx, client, Load{Batches: 20, BatchSize: 5, Concurrency: 4}); err != nil {
+		t.Fatal(err)
+	}
+	items := 0
+	for _, c := range service.recorded() {
+		items += len(c.batch)
+	}
+	if items != 100 {
+		t.Errorf("expected 100 items processed, got %d", items)
+	}
+}
+
+func BenchmarkClientThroughput(b *testing.B) {
+	client := NewClient(NoopService{N: 100, P: time.Nanosecond},
+		WithLogger(NewStdLogger(log.New(io.Discard, "", 0))))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	if err := GenerateLoad(ctx, client, Load{Batches: b.N, BatchSize: 100, Concurrency: 8}); err != nil {
+		b.Fatal(err)
+	}
+}
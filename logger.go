@@ -0,0 +1,31 @@
+package main
+
+import "log"
+
+// Logger is the logger the client and the HTTP handlers report to.
+// It is easy to adapt to structured loggers like slog or zap.
+// Implementations must be safe for concurrent use.
+type Logger interface {
+	// Infof logs a notable event the client recovers from on its own.
+	Infof(format string, args ...any)
+	// Errorf logs a failure, e.g. a dropped sub-batch.
+	Errorf(format string, args ...any)
+}
+
+// NewStdLogger adapts logger from the standard log package to Logger.
+func NewStdLogger(logger *log.Logger) Logger {
+	return stdLogger{logger: logger}
+}
+
+// stdLogger is a Logger writing to a standard logger.
+type stdLogger struct {
+	logger *log.Logger
+}
+
+func (l stdLogger) Infof(format string, args ...any) {
+	l.logger.Printf("INFO: "+format, args...)
+}
+
+func (l stdLogger) Errorf(format string, args ...any) {
+	l.logger.Printf("ERROR: "+format, args...)
+}
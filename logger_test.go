@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// entry is a single message logged to fakeLogger.
+type entry struct {
+	level  string
+	format string
+	args   []any
+}
+
+func (e entry) String() string {
+	return e.level + ": " + fmt.Sprintf(e.format, e.args...)
+}
+
+// fakeLogger records every logged message.
+type fakeLogger struct {
+	mu      sync.Mutex
+	entries []entry
+}
+
+func (l *fakeLogger) Infof(format string, args ...any) {
+	l.log("INFO", format, args)
+}
+
+func (l *fakeLogger) Errorf(format string, args ...any) {
+	l.log("ERROR", format, args)
+}
+
+func (l *fakeLogger) log(level, format string, args []any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, entry{level: level, format: format, args: args})
+}
+
+// logged returns the recorded entries of the given level.
+func (l *fakeLogger) logged(level string) []entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var entries []entry
+	for _, e := range l.entries {
+		if e.level == level {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewStdLogger(log.New(&buf, "", 0))
+
+	logger.Infof("info %d", 1)
+	logger.Errorf("error %d", 2)
+
+	if got, want := buf.String(), "INFO: info 1\nERROR: error 2\n"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestClientLogsSubBatchError(t *testing.T) {
+	logger := &fakeLogger{}
+	service := &failingService{
+		recordingService: recordingService{n: 2, p: time.Millisecond},
+		fail:             map[string]bool{"4": true},
+	}
+	client := NewClient(service, WithLogger(logger))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	// The failing sub-batch has index 2 and starts at item 4.
+	receiveResult(t, client.ProcessWithResult(numberedBatch(0, 6)))
+
+	errs := logger.logged("ERROR")
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 logged error, got %v", errs)
+	}
+	if !strings.Contains(errs[0].String(), "sub-batch 2 [4:6)") {
+		t.Errorf("expected the error to be logged with the sub-batch index 2 and its items, got %v", errs[0])
+	}
+	if !strings.Contains(errs[0].String(), "failed sub-batch starting with 4") {
+		t.Errorf("expected the service error to be logged, got %v", errs[0])
+	}
+}
+
+func TestHandleRequestLogsErrors(t *testing.T) {
+	logger := &fakeLogger{}
+	client := NewClient(&recordingService{n: 2, p: time.Millisecond}, WithLogger(logger), WithQueueCapacity(0))
+
+	handleRequest(client, httptest.NewRecorder(), httptest.NewRequest("POST", "/process", strings.NewReader("[1")))
+	handleRequest(client, httptest.NewRecorder(), httptest.NewRequest("POST", "/process", strings.NewReader("[1]")))
+
+	if infos := logger.logged("INFO"); len(infos) != 1 {
+		t.Errorf("expected the bad request to be logged, got %v", infos)
+	}
+	errs := logger.logged("ERROR")
+	if len(errs) != 1 || !errors.Is(errs[0].args[1].(error), ErrQueueFull) {
+		t.Errorf("expected the enqueue error to be logged, got %v", errs)
+	}
+}
+
+func TestHandleRequestID(t *testing.T) {
+	logger := &fakeLogger{}
+	service := &failingService{
+		recordingService: recordingService{n: 2, p: time.Millisecond},
+		fail:             map[string]bool{"1": true},
+	}
+	client := NewClient(service, WithLogger(logger))
+
+	rr := httptest.NewRecorder()
+	handleRequest(client, rr, httptest.NewRequest("POST", "/process", strings.NewReader("[1, 2]")))
+	if rr.Header().Get("X-Request-ID") == "" {
+		t.Error("expected a generated request ID in the response")
+	}
+
+	r := httptest.NewRequest("POST", "/process", strings.NewReader("[1, 2]"))
+	r.Header.Set("X-Request-ID", "req-42")
+	rr = httptest.NewRecorder()
+	handleRequest(client, rr, r)
+	if got := rr.Header().Get("X-Request-ID"); got != "req-42" {
+		t.Errorf("expected the request ID of the request, got %q", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+	waitCalls(t, &service.recordingService, 2, time.Second)
+	client.Shutdown(context.Background())
+
+	// The batches are processed concurrently, so their errors come in
+	// any order.
+	errs := logger.logged("ERROR")
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 logged errors, got %v", errs)
+	}
+	found := false
+	for _, e := range errs {
+		found = found || strings.HasPrefix(e.String(), "ERROR: request req-42: Error processing sub-batch 0 [0:2)")
+	}
+	if !found {
+		t.Errorf("expected the error to be logged with the request ID, got %v", errs)
+	}
+}
+
+func TestClientLogsRetriedSubBatch(t *testing.T) {
+	logger := &fakeLogger{}
+	service := &failingService{
+		recordingService: recordingService{n: 2, p: time.Millisecond},
+		fail:             map[string]bool{"4": true},
+	}
+	client := NewClient(service, WithLogger(logger), WithRetryPolicy(RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   time.Millisecond,
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	receiveResult(t, client.ProcessWithResult(numberedBatch(0, 6)))
+
+	var retries []entry
+	for _, e := range logger.logged("INFO") {
+		if strings.HasPrefix(e.format, "Retrying") {
+			retries = append(retries, e)
+		}
+	}
+	if len(retries) != 2 {
+		t.Fatalf("expected 2 logged retries, got %v", retries)
+	}
+	for _, e := range retries {
+		if !strings.Contains(e.String(), "Retrying sub-batch 2 [4:6) (attempt") {
+			t.Errorf("expected the retry to be logged with the sub-batch index 2 and its items, got %v", e)
+		}
+	}
+}
@@ -1,18 +1,61 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"mime"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 // ErrBlocked reports if service is blocked.
 var ErrBlocked = errors.New("blocked")
 
+// ErrQueueFull reports if the client queue can't accept a batch.
+var ErrQueueFull = errors.New("queue is full")
+
+// ErrClosed reports if the client no longer accepts batches.
+var ErrClosed = errors.New("client is closed")
+
+// ErrUnprocessed reports if a batch stopped before all its items were
+// passed to the service.
+var ErrUnprocessed = errors.New("items not processed")
+
+// ErrTooManyItems reports if a request has more items than allowed.
+var ErrTooManyItems = errors.New("too many items")
+
+// ErrEmptyBatch reports if a batch to process has no items.
+var ErrEmptyBatch = errors.New("empty batch")
+
+// ErrEmptyPayload reports if an item has no payload.
+var ErrEmptyPayload = errors.New("empty payload")
+
+// ErrUnsupportedMediaType reports if a request body has an unknown content type.
+var ErrUnsupportedMediaType = errors.New("unsupported media type")
+
+// defaultMaxRequestItems is the maximum number of items in a single request.
+const defaultMaxRequestItems = 100000
+
+// defaultEnqueueTimeout is how long a request waits for room in the queue.
+const defaultEnqueueTimeout = time.Second * 2
+
+// defaultQueueCapacity is the number of batches the client queue can hold
+// before Process starts rejecting them.
+const defaultQueueCapacity = 100
+
 // Service defines external service that can process batches of items.
 type Service interface {
 	GetLimits() (n uint64, p time.Duration)
@@ -23,68 +66,1326 @@ type Service interface {
 type Batch []Item
 
 // Item is some abstract item.
-type Item struct{}
+type Item struct {
+	// ID identifies the item.
+	ID string
+	// Payload is the item data as it was received.
+	Payload []byte
+	// Weight is the cost of the item for the service, see WithMaxWeight.
+	// Zero counts as one.
+	Weight uint64
+}
+
+// Validate checks that the item can be processed: it must have a payload
+// other than JSON null.
+func (item Item) Validate() error {
+	if p := bytes.TrimSpace(item.Payload); len(p) == 0 || bytes.Equal(p, []byte("null")) {
+		return ErrEmptyPayload
+	}
+	return nil
+}
+
+// validateBatch splits batch into the valid items and the number of
+// invalid ones. The valid items share batch's array if all items are valid.
+func validateBatch(batch Batch) (Batch, int) {
+	invalid := 0
+	for _, item := range batch {
+		if item.Validate() != nil {
+			invalid++
+		}
+	}
+	if invalid == 0 {
+		return batch, 0
+	}
+
+	valid := make(Batch, 0, len(batch)-invalid)
+	for _, item := range batch {
+		if item.Validate() == nil {
+			valid = append(valid, item)
+		}
+	}
+	return valid, invalid
+}
 
 // Client is a client to the external service.
 type Client struct {
-	service Service
-	n       uint64
-	p       time.Duration
-	queue   chan Batch
+	service  Service
+	capacity int
+	queue    *jobQueue
+	backend  Queue
+	retry    RetryPolicy
+	limiter  *limiter
+	items    *itemLimiter
+	clock    Clock
+	metrics  Metrics
+	tracer   Tracer
+	logger   Logger
+	timeout  time.Duration
+	dedup    bool
+	chunk    uint64
+	adaptive *adaptiveChunk
+	ttl      time.Duration
+	coalesce time.Duration
+
+	maxWeight uint64
+
+	backpressure BackpressurePolicy
+
+	// limitsMu guards the service limits refreshed while processing.
+	limitsMu sync.RWMutex
+	n        uint64
+	p        time.Duration
+	refresh  time.Duration
+
+	// workers is the number of goroutines processing batches from work,
+	// zero means a goroutine per batch.
+	workers int
+	work    chan *job
+	ordered bool
+	// subBatches is the number of sub-batches of a batch processed
+	// concurrently, see WithInFlightLimit.
+	subBatches int
+	failFast   bool
+	// batchSlots holds a token per batch in flight if their number is
+	// capped, see WithMaxConcurrentBatches.
+	batchSlots chan struct{}
+	audit      AuditSink
+	throughput *throughput
+	// retryable tells whether a service error is worth retrying.
+	retryable func(err error) bool
+	// batchIDs are the IDs taken by batches submitted with
+	// ProcessWithBatchID.
+	batchIDs batchIDs
+	// errorRate measures the recent Process calls for Stats and the valve,
+	// which holds processing back if too many of them fail, see
+	// WithErrorRateValve.
+	errorRate      *errorRate
+	valve          *blockGate
+	valveThreshold float64
+	valveCooldown  time.Duration
+	// fatal tells whether a service error stops Run, see WithFatal,
+	// by cancelling its context with stop.
+	fatal func(err error) bool
+	stop  atomic.Pointer[context.CancelCauseFunc]
+	// tiers are the limiters splitting the service capacity by tier,
+	// see WithTiers.
+	tiers       map[string]*tierLimiter
+	defaultTier string
+	callLog     *callLog
+	// statuses are the statuses of the batches for Status.
+	statuses batchStatuses
+	// maxQueuedItems caps the items in the queue, see WithMaxQueuedItems.
+	maxQueuedItems int
+	// onComplete receives the report of every finished batch,
+	// see WithOnComplete.
+	onComplete func(BatchReport)
+	// decoders decode request bodies by media type, see WithDecoder.
+	decoders map[string]Decoder
+	// maxBatchDuration limits the processing of a batch,
+	// see WithMaxBatchDuration.
+	maxBatchDuration time.Duration
+	// order reorders batches before they are split, see
+	// WithSubBatchOrder.
+	order SubBatchOrder
+	// onEnqueue and onDequeue observe the batches going through the
+	// queue, see WithOnEnqueue and WithOnDequeue.
+	onEnqueue func(batch Batch)
+	onDequeue func(batch Batch)
+	// smooth is the sub-batch size when spreading the items across the
+	// interval, see WithSmoothing.
+	smooth uint64
+
+	// gate pauses processing for cooldown when the service is blocked.
+	gate     *blockGate
+	cooldown time.Duration
+	// healthInterval is how often Run checks an unhealthy service again.
+	healthInterval time.Duration
+	// paused holds processing back between Pause and Resume.
+	paused *blockGate
+	// cancels tracks the batches that can be cancelled by ID.
+	cancels cancels
+
+	stats   clientStats
+	pending pendingJobs
+
+	// breaker stops calling the service after repeated failures, if set.
+	breaker *breaker
+
+	deadLetterMu sync.Mutex
+	deadLetter   func(batch Batch, err error)
+	deadLetters  *DeadLetterStore
+
+	// mu guards sends to queue against Shutdown: Process holds it for
+	// reading, Run takes it for writing once closing is closed to make sure
+	// no batch is being enqueued while it drains the queue.
+	mu        sync.RWMutex
+	running   atomic.Bool
+	started   atomic.Bool
+	closed    atomic.Bool
+	closing   chan struct{}
+	closeOnce sync.Once
+	inFlight  sync.WaitGroup
+	done      chan struct{}
 }
 
-// NewClient creates a new client to the external service.
-func NewClient(service Service) *Client {
+// NewClient creates a new client to the external service configured by opts.
+// Without options the client has a queue of defaultQueueCapacity batches,
+// doesn't retry failed sub-batches, doesn't limit Process calls duration
+// and logs to the standard logger.
+func NewClient(service Service, opts ...Option) *Client {
 	n, p := service.GetLimits()
-	return &Client{
-		service: service,
-		n:       n,
-		p:       p,
-		queue:   make(chan Batch),
+	c := &Client{
+		service:        service,
+		n:              n,
+		p:              p,
+		capacity:       defaultQueueCapacity,
+		limiter:        newLimiter(p),
+		clock:          realClock{},
+		retryable:      defaultRetryable,
+		throughput:     newThroughput(defaultThroughputWindow),
+		errorRate:      newErrorRate(defaultErrorRateWindow),
+		valve:          newBlockGate(),
+		metrics:        noopMetrics{},
+		tracer:         noopTracer{},
+		logger:         NewStdLogger(log.Default()),
+		gate:           newBlockGate(),
+		paused:         newBlockGate(),
+		cooldown:       defaultBlockedCooldown,
+		healthInterval: defaultHealthInterval,
+		statuses:       batchStatuses{ttl: defaultStatusTTL},
+		closing:        make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.ordered {
+		c.workers = 1
+		c.subBatches = 1
+	}
+	if c.workers > 0 {
+		// The workers cap the batches in flight already.
+		c.batchSlots = nil
+	}
+	c.limiter.clock = c.clock
+	c.limiter.setInterval(c.callInterval(c.n, c.p))
+	c.initTiers()
+	if c.items != nil {
+		c.items.setLimits(c.n, c.p)
+		c.items.clock = c.clock
+	}
+	if c.breaker != nil {
+		c.breaker.clock = c.clock
+	}
+	c.queue = newJobQueue(c.capacity, c.backend)
+	c.queue.maxItems = c.maxQueuedItems
+	return c
+}
+
+// NewClientWithCapacity creates a new client to the external service
+// whose queue holds up to capacity batches.
+//
+// Deprecated: use NewClient with WithQueueCapacity.
+func NewClientWithCapacity(service Service, capacity int, opts ...Option) *Client {
+	return NewClient(service, append(opts, WithQueueCapacity(capacity))...)
+}
+
+// job is a batch waiting in the queue together with the channel
+// its processing outcome is delivered to.
+type job struct {
+	batch  Batch
+	result chan error
+
+	// n and p override the client limits for this batch if set.
+	n uint64
+	p time.Duration
+
+	// id identifies the job in the queue.
+	id string
+	// named is set if id was supplied by the caller, see
+	// ProcessWithBatchID.
+	named bool
+	// tier is the tier the batch was submitted to, empty for the default
+	// one, see WithTiers.
+	tier string
+	// priority orders the job in the queue, seq breaks the ties.
+	priority int
+	seq      uint64
+	// enqueued is when the job was queued, zero if it never was.
+	enqueued time.Time
+	// done, if set, is called with the outcome once the job is finished.
+	done func(err error)
+	// announced, if set, is closed once the job is queued and announced
+	// to the OnEnqueue hook, or rejected by the queue.
+	announced chan struct{}
+
+	// ctx is the context the batch was submitted with, if any.
+	// Its spans are the parents of the batch spans.
+	ctx context.Context
+
+	// merged are the jobs coalesced into this one, see WithCoalesce.
+	// They are finished along with it.
+	merged []*job
+}
+
+// queued returns the job as it is stored in a Queue.
+func (j *job) queued() QueuedBatch {
+	return QueuedBatch{
+		ID:       j.id,
+		Batch:    j.batch,
+		Priority: j.priority,
+		Enqueued: j.enqueued,
+		N:        j.n,
+		P:        j.p,
+		Named:    j.named,
+		Tier:     j.tier,
+	}
+}
+
+// jobFromQueued returns a job for a batch found in a Queue.
+func jobFromQueued(b QueuedBatch) *job {
+	return &job{
+		batch:    b.Batch,
+		n:        b.N,
+		p:        b.P,
+		id:       b.ID,
+		priority: b.Priority,
+		enqueued: b.Enqueued,
+		named:    b.Named,
+		tier:     b.Tier,
+	}
+}
+
+// submitContext returns the context the batch of j was submitted with,
+// carrying its ID if the caller supplied it.
+func (j *job) submitContext() context.Context {
+	ctx := j.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if j.named {
+		ctx = withBatchID(ctx, j.id)
+	}
+	return ctx
+}
+
+// finish delivers the processing outcome of the job, if anybody waits for it.
+// It must be called exactly once per job.
+func (j *job) finish(err error) {
+	if j.result != nil {
+		j.result <- err
+		close(j.result)
+	}
+	if j.done != nil {
+		j.done(err)
+	}
+	for _, m := range j.merged {
+		m.finish(err)
 	}
 }
 
-// ProcessItems processes items by the external service.
-func (c *Client) Process(batch Batch) {
-	c.queue <- batch
+// Process enqueues batch for processing by the external service.
+// By default it never blocks and returns ErrQueueFull if the queue can't
+// accept the batch, see WithBackpressure for the alternatives.
+// It returns ErrEmptyBatch if batch has no items, as do all the other
+// ways to submit a batch.
+// It returns ErrClosed once Shutdown has been called or Run has stopped.
+func (c *Client) Process(batch Batch) error {
+	return c.enqueue(&job{batch: batch}, false)
+}
+
+// ProcessContext enqueues batch like Process unless ctx is done already,
+// in which case it returns the context error without enqueuing. Under
+// BackpressureBlock it gives up waiting for a free slot once ctx is done.
+// The values of ctx, such as authentication tokens or tenant IDs, travel
+// with the batch: the ctx passed to Service.Process for its sub-batches
+// carries them, though not the cancellation of ctx. The batch spans are
+// started as children of the span in ctx, so an incoming trace is
+// continued. A Queue backend keeping batches outside the process can't
+// keep ctx, so the batches it restores are processed without its values.
+func (c *Client) ProcessContext(ctx context.Context, batch Batch) error {
+	return c.enqueue(&job{batch: batch, ctx: ctx}, false)
+}
+
+// ProcessBlocking enqueues batch for processing by the external service.
+// It blocks until the queue accepts the batch and returns ErrClosed
+// once Shutdown has been called or Run has stopped.
+func (c *Client) ProcessBlocking(batch Batch) error {
+	return c.enqueue(&job{batch: batch}, true)
+}
+
+// ProcessWithResult enqueues batch like Process and returns a channel that
+// delivers the final outcome of the batch: nil if every sub-batch succeeded,
+// otherwise the errors of the failed sub-batches joined together.
+// Enqueue errors are delivered the same way. The channel receives exactly
+// one value and is closed afterwards.
+func (c *Client) ProcessWithResult(batch Batch) <-chan error {
+	j := &job{batch: batch, result: make(chan error, 1)}
+	if err := c.enqueue(j, false); err != nil {
+		j.finish(err)
+	}
+	return j.result
+}
+
+// ProcessAndWait enqueues batch like ProcessContext and waits for its
+// outcome like ProcessWithResult. If ctx is done first, it cancels the
+// batch as Cancel does, so that its remaining sub-batches are skipped, and
+// returns the context error.
+func (c *Client) ProcessAndWait(ctx context.Context, batch Batch) error {
+	return c.processAndWait(&job{batch: batch, ctx: ctx})
+}
+
+// processAndWait is ProcessAndWait for j submitted with j.ctx.
+func (c *Client) processAndWait(j *job) error {
+	ctx := j.ctx
+	j.id, j.result = newID(), make(chan error, 1)
+	if err := c.enqueue(j, false); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-j.result:
+		return err
+	case <-ctx.Done():
+		// The result is buffered, so finishing the job never blocks.
+		c.Cancel(j.id)
+		return ctx.Err()
+	}
+}
+
+// ProcessWithPriority enqueues batch like Process. Batches with a higher
+// priority are dequeued first, batches with equal priorities in the order
+// they were enqueued. Process uses priority zero.
+func (c *Client) ProcessWithPriority(batch Batch, priority int) error {
+	return c.enqueue(&job{batch: batch, priority: priority}, false)
+}
+
+// ProcessWithLimits enqueues batch like Process but processes it in
+// sub-batches of at most n items sent no more often than once per p.
+// The overrides are capped by the client limits: n larger than the client
+// sub-batch size, the service n unless WithChunkSize lowers it, and p shorter
+// than the service one are ignored, as are zero values.
+// The caps are applied during processing, so they follow refreshed limits.
+func (c *Client) ProcessWithLimits(batch Batch, n uint64, p time.Duration) error {
+	return c.enqueue(&job{batch: batch, n: n, p: p}, false)
+}
+
+// ProcessAll processes batch inline, bypassing the queue and Run, and
+// blocks until every sub-batch is done or ctx is done. It shares the rate
+// limiter with the batches processed by Run.
+// It returns the errors of the failed sub-batches joined together,
+// or nil if all of them succeeded.
+func (c *Client) ProcessAll(ctx context.Context, batch Batch) error {
+	if len(batch) == 0 {
+		return ErrEmptyBatch
+	}
+	if c.dedup {
+		batch = dedupBatch(batch)
+	}
+	return c.processBatch(ctx, &job{batch: batch, ctx: ctx})
+}
+
+// enqueue sends j to the queue. If the queue is full it waits for a free
+// slot if block is set, otherwise it follows the backpressure policy.
+// Waiting stops once the submit context of j is done.
+// Empty batches are rejected with ErrEmptyBatch.
+func (c *Client) enqueue(j *job, block bool) error {
+	if len(j.batch) == 0 {
+		return ErrEmptyBatch
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	select {
+	case <-c.closing:
+		return ErrClosed
+	default:
+	}
+
+	var cancelled <-chan struct{}
+	if j.ctx != nil {
+		if err := j.ctx.Err(); err != nil {
+			return err
+		}
+		cancelled = j.ctx.Done()
+	}
+
+	if j.named && !c.batchIDs.claim(j.id, c.clock.Now()) {
+		return fmt.Errorf("%w: %s", ErrDuplicate, j.id)
+	}
+	if c.dedup {
+		j.batch = dedupBatch(j.batch)
+	}
+	if c.maxQueuedItems > 0 && len(j.batch) > c.maxQueuedItems {
+		if j.named {
+			c.batchIDs.forget(j.id)
+		}
+		return fmt.Errorf("%w: %d items, the queue holds up to %d", ErrTooManyItems, len(j.batch), c.maxQueuedItems)
+	}
+	j.enqueued = c.clock.Now()
+
+	// The job is pending before it is pushed as Run may finish it right away.
+	c.pending.add()
+	// The caller knows a preset ID, so it may cancel the job.
+	cancellable := j.id != ""
+	if cancellable {
+		c.cancels.add(j.id)
+	}
+	if j.id == "" && (c.audit != nil || c.statuses.enabled()) {
+		// The audit trail and the statuses name the batch before the
+		// queue would.
+		j.id = newID()
+	}
+	c.statuses.queued(j.id, len(j.batch), j.enqueued)
+	// Run may pop the job as soon as it is pushed, so it waits for the
+	// job to be announced before it goes on with it, and the job isn't
+	// completed in the audit trail before it is enqueued.
+	j.announced = make(chan struct{})
+	rejected := false
+	j.done = func(err error) {
+		<-j.announced
+		if cancellable {
+			c.cancels.remove(j.id)
+		}
+		if j.named {
+			c.batchIDs.release(j.id, c.clock.Now())
+		}
+		if !rejected {
+			c.recordAudit(AuditCompleted, j.id, -1, 0, len(j.batch), err)
+		}
+		c.statuses.finish(j.id, err, c.clock.Now())
+		c.pending.done()
+	}
+	if err := c.push(j, block, cancelled); err != nil {
+		rejected = true
+		c.recordAudit(AuditRejected, j.id, -1, 0, len(j.batch), err)
+		close(j.announced)
+		j.done(err)
+		j.done = nil
+		if j.named {
+			c.batchIDs.forget(j.id)
+		}
+		c.statuses.forget(j.id)
+		return err
+	}
+
+	c.recordAudit(AuditEnqueued, j.id, -1, 0, len(j.batch), nil)
+	c.queueHook("OnEnqueue", c.onEnqueue, j.batch)
+	close(j.announced)
+	c.metrics.BatchEnqueued(len(j.batch))
+	c.stats.recordBatch(len(j.batch))
+	return nil
+}
+
+// push pushes j to the queue for enqueue until it succeeds or fails.
+func (c *Client) push(j *job, block bool, cancelled <-chan struct{}) error {
+	for {
+		ok, popped, err := c.queue.push(j)
+		if err != nil {
+			return fmt.Errorf("enqueue: %w", err)
+		}
+		if ok {
+			return nil
+		}
+		if !block && c.backpressure != BackpressureBlock {
+			if c.backpressure == BackpressureDropOldest && c.dropOldest(j.priority) {
+				continue
+			}
+			return ErrQueueFull
+		}
+
+		select {
+		case <-popped:
+		case <-c.closing:
+			return ErrClosed
+		case <-cancelled:
+			return j.ctx.Err()
+		}
+	}
 }
 
 // an infinite loop of data processing from the queue queue with the given restrictions.
-func (c *Client) Run(ctx context.Context) {
+// Batches are processed concurrently, by a goroutine each or by a fixed
+// number of workers set with WithWorkers, but all of them share the client
+// limiter, so the service never gets more than n items per p.
+//
+// Run returns when ctx is done or, after Shutdown, once every queued batch
+// has been processed. Either way it waits for in-flight batches to stop and
+// the client stops accepting new batches. Batches still queued when ctx is
+// done are passed to the dead-letter hook and finished with an error
+// wrapping ErrUnprocessed and the context error.
+//
+// Run returns nil after Shutdown and the context error if ctx stopped it.
+// A fatal service error stops it like ctx, see WithFatal.
+// It fails right away with an error wrapping ErrInvalidLimits if the
+// service limits don't allow processing anything, treating the queued
+// batches as if ctx was done.
+// Run must be called only once.
+func (c *Client) Run(ctx context.Context) error {
+	c.started.Store(true)
+	defer close(c.done)
+
+	if err := c.checkLimits(); err != nil {
+		c.close()
+		c.drain(func(j *job) {
+			j.finish(c.unprocessed(j, j.batch, err))
+		})
+		return fmt.Errorf("run: %w", err)
+	}
+
+	ctx, cancel := c.stoppable(ctx)
+	defer cancel()
+	defer c.inFlight.Wait()
+
+	c.running.Store(true)
+	defer c.running.Store(false)
+
+	if c.refresh > 0 {
+		refreshCtx, cancel := context.WithCancel(ctx)
+		c.inFlight.Add(1)
+		go func() {
+			defer c.inFlight.Done()
+			c.refreshLimits(refreshCtx)
+		}()
+		// Deferred calls run in reverse order, so the loop is cancelled
+		// before Run waits for inFlight.
+		defer cancel()
+	}
+
+	if c.workers > 0 {
+		c.startWorkers(ctx)
+		defer close(c.work)
+	}
+
 	for {
+		// Stop dequeuing while the service is blocked, the client is
+		// paused or the error rate valve is closed. While waiting for
+		// a batch Run makes room for it even in a queue without capacity.
+		var ready <-chan struct{}
+		opened := c.gate.opened()
+		if opened == nil {
+			opened = c.paused.opened()
+		}
+		if opened == nil {
+			opened = c.valve.opened()
+		}
+		if opened == nil {
+			ready = c.queue.ready
+			c.queue.receiving(true)
+		}
+
+		var drain func(j *job)
 		select {
+		case <-opened:
+		case <-ready:
 		case <-ctx.Done():
+		case <-c.closing:
+			drain = func(j *job) {
+				c.dispatch(ctx, j)
+			}
+		}
+		// Whatever woke Run up, nothing is dispatched once ctx is done.
+		var err error
+		if ctx.Err() != nil {
+			err = context.Cause(ctx)
+			c.close()
+			drain = func(j *job) {
+				j.finish(c.unprocessed(j, j.batch, err))
+			}
+		}
+
+		if opened == nil {
+			c.queue.receiving(false)
+		}
+		if drain != nil {
+			c.drain(drain)
+			return err
+		}
+		if opened == nil && !c.waitHealthy(ctx) {
+			// Whatever stopped the wait is handled by the next
+			// select, which needs to see the queue ready again.
+			c.queue.wake()
+			continue
+		}
+		if !c.waitBatchSlot(ctx) {
+			c.queue.wake()
+			continue
+		}
+		if j := c.queue.pop(); j != nil {
+			if c.coalesce > 0 {
+				for _, j := range c.coalesceJobs(ctx, j) {
+					c.dispatch(ctx, j)
+				}
+				continue
+			}
+			c.dispatch(ctx, j)
+		}
+	}
+}
+
+// Ready reports whether Run is processing the queue, the client accepts
+// new batches and the service is healthy, see HealthChecker.
+func (c *Client) Ready() bool {
+	return c.ready(context.Background())
+}
+
+// ready is Ready checking the service health with ctx.
+func (c *Client) ready(ctx context.Context) bool {
+	select {
+	case <-c.closing:
+		return false
+	default:
+	}
+	return c.running.Load() && c.checkHealth(ctx) == nil
+}
+
+// close stops the client from accepting new batches.
+func (c *Client) close() {
+	c.closeOnce.Do(func() {
+		close(c.closing)
+	})
+}
+
+// drain passes every job left in the queue to fn once the client is closed.
+func (c *Client) drain(fn func(j *job)) {
+	// Wait for Process calls that are still sending to the queue.
+	// Any later call sees the client closed.
+	c.mu.Lock()
+	c.mu.Unlock()
+
+	for j := c.queue.pop(); j != nil; j = c.queue.pop() {
+		fn(j)
+	}
+}
+
+// dispatch hands j over to a worker or, if the client has none,
+// processes it in a separate goroutine.
+func (c *Client) dispatch(ctx context.Context, j *job) {
+	if j.merged == nil {
+		c.dequeued(j)
+	}
+	for _, merged := range j.merged {
+		c.dequeued(merged)
+	}
+
+	if c.workers == 0 {
+		c.spawn(ctx, j)
+		return
+	}
+
+	select {
+	case c.work <- j:
+	case <-ctx.Done():
+		j.finish(c.unprocessed(j, j.batch, context.Cause(ctx)))
+	}
+}
+
+// spawn processes j in a separate goroutine tracked by inFlight once
+// there is a free batch slot, see WithMaxConcurrentBatches.
+func (c *Client) spawn(ctx context.Context, j *job) {
+	if c.batchSlots != nil {
+		select {
+		case c.batchSlots <- struct{}{}:
+		case <-ctx.Done():
+			j.finish(c.unprocessed(j, j.batch, context.Cause(ctx)))
 			return
-		case batch := <-c.queue:
-			go func() {
-				ticker := time.NewTicker(c.p)
-				defer ticker.Stop()
-
-				for i := uint64(0); i < uint64(len(batch)); i += c.n {
-					end := i + c.n
-					if end > uint64(len(batch)) {
-						end = uint64(len(batch))
-					}
+		}
+	}
 
-					subBatch := batch[i:end]
-					err := c.service.Process(ctx, subBatch)
-					if err != nil {
-						log.Printf("Error processing subBatch (retry %d): %v", i+1, err)
-					}
+	c.inFlight.Add(1)
+	go func() {
+		defer c.inFlight.Done()
+		if c.batchSlots != nil {
+			defer func() { <-c.batchSlots }()
+		}
+		j.finish(c.runBatch(ctx, j))
+	}()
+}
+
+// runBatch is processBatch for the goroutines of Run. A panic in processing
+// fails the batch with an error wrapping ErrPanic instead of crashing the
+// process.
+func (c *Client) runBatch(ctx context.Context, j *job) (err error) {
+	defer recoverPanic(c.loggerFor(j.submitContext()), "batch", &err)
+	return c.processBatch(ctx, j)
+}
+
+// processBatch splits the batch of j into sub-batches of at most n items,
+// and of at most the client weight limit, and processes them. It stops
+// early if ctx is done and passes the items left unprocessed to the
+// dead-letter hook as one batch with an error wrapping both ErrUnprocessed
+// and the context error, so that no item is dropped silently. It does the
+// same with an error wrapping ErrInvalidLimits instead of looping forever
+// if the service n drops to zero. A batch that outlived the client TTL in
+// the queue is passed to the dead-letter hook as a whole without being
+// processed.
+// It returns the errors of the failed sub-batches joined together in the
+// order of the sub-batches.
+//
+// By default sub-batches are processed strictly in order: a sub-batch is
+// passed to the service only after the previous one is done, including
+// all its retries. WithInFlightLimit lets several of them be in flight at
+// once. Either way every sub-batch starts right where the previous one
+// ended. Different batches may interleave unless the client is ordered.
+func (c *Client) processBatch(ctx context.Context, j *job) (err error) {
+	timing := c.startTiming()
+	if timing != nil {
+		defer func() { c.reportComplete(j, timing, err) }()
+	}
+	if c.expired(j) {
+		return c.unprocessed(j, j.batch, ErrExpired)
+	}
+	ctx, release, err := c.cancels.start(ctx, j.id)
+	if err != nil {
+		return c.unprocessed(j, j.batch, err)
+	}
+	defer release()
+	ctx, stop := c.batchDeadline(ctx)
+	defer stop()
+	// failed stops the rest of the batch after a failed sub-batch
+	// with WithFailFast.
+	failed := func() {}
+	if c.failFast {
+		var cancel context.CancelCauseFunc
+		ctx, cancel = context.WithCancelCause(ctx)
+		defer cancel(nil)
+		failed = func() { cancel(ErrFailFast) }
+	}
+
+	c.stats.inFlight.Add(1)
+	defer c.stats.inFlight.Add(-1)
+	c.trackStatus(j, func(st *BatchStatus) { st.State = BatchProcessing })
+
+	spanCtx, span := c.tracer.Start(j.submitContext(), "batch")
+	defer span.End()
+	span.SetAttribute("batch.items", len(j.batch))
+	if j.named {
+		span.SetAttribute("batch.id", j.id)
+	}
+
+	// A batch with its own interval is paced on top of the client limiter,
+	// so it doesn't slow down other batches. An interval shorter than the
+	// service one has no effect as the client limiter is stricter.
+	var pace *limiter
+	if j.p > 0 {
+		pace = newLimiter(j.p)
+		pace.clock = c.clock
+	}
+
+	limit := c.subBatches
+	if limit < 1 {
+		limit = 1
+	}
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+
+	batch := c.reorder(j.batch)
+	// subErrs are the errors of the sub-batches in order,
+	// filled in as they are done.
+	var subErrs []*error
+	// rest is what is left unprocessed because of cause.
+	var rest Batch
+	var cause error
+	index := 0
+	for i, end := uint64(0), uint64(0); i < uint64(len(batch)); i, index = end, index+1 {
+		// The limits are read once the sub-batch may go, so that it
+		// follows the limits refreshed meanwhile.
+		if ctx.Err() != nil {
+			rest, cause = batch[i:], context.Cause(ctx)
+			break
+		}
+		select {
+		case sem <- struct{}{}:
+			// The sub-batch done meanwhile may have stopped the batch.
+			if ctx.Err() != nil {
+				<-sem
+				rest, cause = batch[i:], context.Cause(ctx)
+			}
+		case <-ctx.Done():
+			rest, cause = batch[i:], context.Cause(ctx)
+		}
+		if rest != nil {
+			break
+		}
 
-					<-ticker.C
+		serviceN, _ := c.limits()
+		n := c.smoothSize(c.chunkSize(serviceN), serviceN)
+		if c.adaptive != nil {
+			n = c.adaptive.limit(n)
+		}
+		if j.n > 0 && j.n < n {
+			n = j.n
+		}
+		if n == 0 {
+			// Nothing would ever make progress.
+			<-sem
+			rest, cause = batch[i:], fmt.Errorf("%w: n=0", ErrInvalidLimits)
+			break
+		}
+
+		end = i + n
+		if end > uint64(len(batch)) {
+			end = uint64(len(batch))
+		}
+		end = c.cutByWeight(batch, i, end)
+		end = c.cutByPayload(batch, i, end)
+
+		if pace != nil {
+			if pace.Wait(ctx) != nil {
+				<-sem
+				rest, cause = batch[i:], context.Cause(ctx)
+				break
+			}
+		}
+
+		subErr := new(error)
+		subErrs = append(subErrs, subErr)
+		wg.Add(1)
+		go func(subBatch Batch, sub subBatchRange) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			defer func() {
+				if *subErr != nil {
+					failed()
 				}
 			}()
+			defer func() {
+				c.trackStatus(j, func(st *BatchStatus) {
+					st.SubBatchesDone++
+					if *subErr != nil {
+						st.SubBatchesFailed++
+					}
+				})
+			}()
+			defer recoverPanic(c.loggerFor(spanCtx), sub.String(), subErr)
+			*subErr = c.processSubBatch(ctx, spanCtx, subBatch, sub)
+		}(batch[i:end], subBatchRange{batch: j.id, named: j.named, tier: j.tier, index: index, start: i, end: end, timing: timing})
+	}
+	wg.Wait()
+
+	var errs []error
+	for _, err := range subErrs {
+		if *err != nil {
+			errs = append(errs, *err)
 		}
 	}
+	if len(rest) > 0 {
+		errs = append(errs, c.unprocessed(j, rest, cause))
+	}
+
+	span.SetAttribute("batch.sub_batches", index)
+	if timing != nil {
+		timing.subBatches = index
+		timing.errors = len(errs)
+	}
+	err = errors.Join(errs...)
+	if err != nil {
+		span.RecordError(err)
+	}
+	return err
+}
+
+// subBatchRange locates a sub-batch in its batch.
+type subBatchRange struct {
+	// batch is the ID of the batch, named is set if the caller supplied it.
+	batch string
+	named bool
+	// tier is the tier of the batch, see WithTiers.
+	tier string
+	// index is the zero-based number of the sub-batch in the batch.
+	index int
+	// start and end are the offsets of its first item and past its last.
+	start, end uint64
+	// timing adds up the waits of the batch for its report, if any.
+	timing *batchTiming
 }
 
+func (r subBatchRange) String() string {
+	return fmt.Sprintf("sub-batch %d [%d:%d)", r.index, r.start, r.end)
+}
+
+// processSubBatch processes subBatch, located by sub in the batch whose
+// span is in spanCtx, passing it to the dead-letter hook if it fails
+// terminally.
+func (c *Client) processSubBatch(ctx, spanCtx context.Context, subBatch Batch, sub subBatchRange) error {
+	subCtx, subSpan := c.tracer.Start(spanCtx, "sub-batch")
+	defer subSpan.End()
+	subSpan.SetAttribute("sub_batch.index", sub.index)
+	subSpan.SetAttribute("sub_batch.items", len(subBatch))
+
+	reportProgress(spanCtx, ProgressSubBatchStarted, sub, nil)
+	callCtx := spanContext{Context: ctx, spans: subCtx}
+	failed, err := c.processWithRetry(withIdempotencyKey(callCtx, sub), subBatch, sub)
+	err = timedOut(ctx, err)
+	if err != nil {
+		c.loggerFor(spanCtx).Errorf("Error processing %v: %v", sub, err)
+		c.sendToDeadLetter(sub.batch, sub.named, sub.index, failed, err)
+		subSpan.RecordError(err)
+		reportProgress(spanCtx, ProgressSubBatchFailed, sub, err)
+	} else {
+		reportProgress(spanCtx, ProgressSubBatchSucceeded, sub, nil)
+	}
+	return err
+}
+
+// unprocessed passes batch, the part of j which was never passed to the
+// service because of cause, to the dead-letter hook and returns the error
+// it was given, wrapping both ErrUnprocessed and cause.
+func (c *Client) unprocessed(j *job, batch Batch, cause error) error {
+	err := fmt.Errorf("%w: %w", ErrUnprocessed, cause)
+	c.loggerFor(j.submitContext()).Errorf("Stopped with %d items not processed: %v", len(batch), err)
+	c.sendToDeadLetter(j.id, j.named, -1, batch, err)
+	return err
+}
+
+// sendToDeadLetter passes a terminally failed batch to the dead-letter hook
+// and store. batchID and subBatch name it in the audit trail, an ID named
+// by the caller is passed along with err as a BatchError.
+func (c *Client) sendToDeadLetter(batchID string, named bool, subBatch int, batch Batch, err error) {
+	c.recordAudit(AuditDeadLettered, batchID, subBatch, 0, len(batch), err)
+	if named {
+		err = &BatchError{ID: batchID, Err: err}
+	}
+	if c.deadLetters != nil {
+		c.deadLetters.Add(batch, err)
+	}
+	if c.deadLetter == nil {
+		return
+	}
+
+	c.deadLetterMu.Lock()
+	defer c.deadLetterMu.Unlock()
+	c.deadLetter(batch, err)
+}
+
+// Shutdown stops accepting new batches and waits until every queued and
+// in-flight batch has been processed by Run or ctx is done.
+func (c *Client) Shutdown(ctx context.Context) error {
+	c.close()
+
+	select {
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close shuts the client down like Shutdown without a deadline: it stops
+// accepting new batches and waits until Run has processed the queued and
+// in-flight ones and returned. If Run was never started, the queued batches
+// are passed to the dead-letter hook and finished with an error wrapping
+// ErrUnprocessed and ErrClosed instead. Either way it flushes the audit
+// sink last and returns its error. Calls after the first return nil right
+// away.
+func (c *Client) Close() error {
+	if !c.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+
+	c.close()
+	if c.started.Load() {
+		<-c.done
+	} else {
+		c.drain(func(j *job) {
+			j.finish(c.unprocessed(j, j.batch, ErrClosed))
+		})
+	}
+	if c.audit != nil {
+		return c.audit.Flush()
+	}
+	return nil
+}
+
+// handleRequest enqueues the batch in the request body for processing by
+// client. Requests with more than defaultMaxRequestItems items or with
+// invalid items are rejected. The response carries the request ID in the
+// X-Request-ID header: the one of the request if set, a new one otherwise.
+// The client logs the batch with it, see RequestID.
+// Under BackpressureBlock a request waits for room in the queue for up to
+// defaultEnqueueTimeout and is answered with 503 after that.
+// The X-Process-Interval header, e.g. "500ms", spaces the sub-batches of
+// the batch at least that far apart, like the p of ProcessWithLimits.
+// The response body is a JSON object, either with the number of accepted
+// items or with an error message and a code identifying the error.
 func handleRequest(client *Client, w http.ResponseWriter, r *http.Request) {
-	batch, err := convertRequestToBatch(r)
+	newRequestHandler(client).ServeHTTP(w, r)
+}
+
+// handleRequestWithMaxItems is handleRequest rejecting requests with more
+// than maxItems items with 413 before enqueuing anything.
+// Zero or less maxItems means no limit.
+func handleRequestWithMaxItems(client *Client, maxItems int, w http.ResponseWriter, r *http.Request) {
+	h := newRequestHandler(client)
+	h.maxItems = maxItems
+	h.ServeHTTP(w, r)
+}
+
+// handleRequestSync is handleRequest waiting for the batch to be processed
+// and answering with its outcome. The timeout query parameter or the
+// X-Timeout header, e.g. "5s", limits the wait.
+func handleRequestSync(client *Client, w http.ResponseWriter, r *http.Request) {
+	h := newRequestHandler(client)
+	h.sync = true
+	h.ServeHTTP(w, r)
+}
+
+// requestHandler enqueues the batches posted to it for processing by client.
+type requestHandler struct {
+	client *Client
+	// maxItems is the maximum number of items in a request,
+	// zero or less means no limit.
+	maxItems int
+	// lenient makes the handler drop invalid items instead of rejecting
+	// the whole request.
+	lenient bool
+	// sync makes the handler wait for the batch to be processed and
+	// answer with its outcome.
+	sync bool
+	// enqueueTimeout is how long the handler waits for room in the queue
+	// under BackpressureBlock before answering 503, zero or less for as
+	// long as the request lasts.
+	enqueueTimeout time.Duration
+}
+
+// newRequestHandler creates a handler for client with the default settings.
+func newRequestHandler(client *Client) *requestHandler {
+	return &requestHandler{client: client, maxItems: defaultMaxRequestItems, enqueueTimeout: defaultEnqueueTimeout}
+}
+
+func (h *requestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	client := h.client
+
+	id := r.Header.Get(requestIDHeader)
+	if id == "" {
+		id = newID()
+	}
+	w.Header().Set(requestIDHeader, id)
+	ctx := withRequestID(r.Context(), id)
+	logger := client.loggerFor(ctx)
+
+	batch, err := decodeRequest(r, h.maxItems, client.decoders)
+	if errors.Is(err, ErrTooManyItems) {
+		logger.Infof("Bad request: %v", err)
+		writeError(w, http.StatusRequestEntityTooLarge, "too_many_items", "too many items")
+		return
+	}
+	if errors.Is(err, ErrUnsupportedMediaType) {
+		logger.Infof("Bad request: %v", err)
+		writeError(w, http.StatusUnsupportedMediaType, "unsupported_media_type", "unsupported media type")
+		return
+	}
+	if err != nil {
+		logger.Infof("Bad request: %v", err)
+		writeError(w, http.StatusBadRequest, "invalid_request", "convert request to batch error")
+		return
+	}
+
+	batch, invalid, duplicates, err := client.admit(logger, batch, h.lenient)
+	if errors.Is(err, errInvalidItems) {
+		writeError(w, http.StatusBadRequest, "invalid_items", fmt.Sprintf("%d invalid items", invalid))
+		return
+	}
+	if errors.Is(err, ErrEmptyBatch) {
+		writeError(w, http.StatusBadRequest, "empty_batch", "empty batch")
+		return
+	}
+	interval, err := requestInterval(r)
+	if err != nil {
+		logger.Infof("Bad request: %v", err)
+		writeError(w, http.StatusBadRequest, "invalid_interval", "invalid process interval")
+		return
+	}
+	// The interval only ever slows the batch down, see ProcessWithLimits.
+	j := &job{batch: batch, ctx: ctx, p: interval}
+
+	if h.sync {
+		h.processSync(w, r, j)
+		return
+	}
+	if err := client.enqueueRequest(j, h.enqueueTimeout); err != nil {
+		logger.Errorf("Error enqueuing batch of %d items: %v", len(batch), err)
+		if errors.Is(err, errEnqueueTimeout) {
+			writeError(w, http.StatusServiceUnavailable, "enqueue_timeout", "queue is full")
+			return
+		}
+		writeEnqueueError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, processResponse{ID: j.id, Accepted: len(batch), Invalid: invalid, Duplicates: duplicates})
+}
+
+// errInvalidItems reports if a request has invalid items, see admit.
+var errInvalidItems = errors.New("invalid items")
+
+// errEnqueueTimeout reports if a request timed out waiting for room in the
+// queue, see enqueueRequest.
+var errEnqueueTimeout = errors.New("enqueue timeout")
+
+// admit checks the batch of a request the same way for every front-end,
+// logging with logger. It rejects a batch with invalid items with
+// errInvalidItems, or drops them if lenient, and an empty batch with
+// ErrEmptyBatch. The duplicates the client would drop anyway are dropped
+// here to tell the caller about them. It returns the batch left along
+// with the numbers of invalid and of duplicate items.
+func (c *Client) admit(logger Logger, batch Batch, lenient bool) (admitted Batch, invalid, duplicates int, err error) {
+	valid, invalid := validateBatch(batch)
+	if invalid > 0 {
+		if !lenient {
+			logger.Infof("Bad request: %d invalid items", invalid)
+			return nil, invalid, 0, fmt.Errorf("%w: %d", errInvalidItems, invalid)
+		}
+		logger.Infof("Dropping %d invalid items", invalid)
+		batch = valid
+	}
+	if c.dedup {
+		unique := dedupBatch(batch)
+		duplicates = len(batch) - len(unique)
+		batch = unique
+	}
+	if len(batch) == 0 {
+		return nil, invalid, duplicates, ErrEmptyBatch
+	}
+	return batch, invalid, duplicates, nil
+}
+
+// enqueueRequest enqueues j for a request waiting for room in the queue
+// under BackpressureBlock for up to timeout, zero or less for as long as
+// the request lasts. It fails with an error wrapping errEnqueueTimeout
+// once timeout passes.
+func (c *Client) enqueueRequest(j *job, timeout time.Duration) error {
+	ctx := j.ctx
+	if timeout > 0 {
+		// Only waiting for the queue is limited, the values of ctx
+		// travel with the batch regardless.
+		var cancel context.CancelFunc
+		j.ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	err := c.enqueue(j, false)
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		return fmt.Errorf("%w: %w", errEnqueueTimeout, err)
+	}
+	return err
+}
+
+// processSync processes batch for a synchronous request and writes its
+// outcome: 200 with a syncResponse once every item is processed, 504 if
+// the request timeout expires first and 502 with the error if the service
+// fails.
+func (h *requestHandler) processSync(w http.ResponseWriter, r *http.Request, j *job) {
+	ctx, batch := j.ctx, j.batch
+	logger := h.client.loggerFor(ctx)
+
+	timeout, err := requestTimeout(r)
+	if err != nil {
+		logger.Infof("Bad request: %v", err)
+		writeError(w, http.StatusBadRequest, "invalid_timeout", "invalid timeout")
+		return
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+		j.ctx = ctx
+	}
+
+	// ProcessAndWait returns the errors of ctx as they are, the batch
+	// errors wrap them.
+	switch err := h.client.processAndWait(j); {
+	case err == nil:
+		writeJSON(w, http.StatusOK, syncResponse{Processed: len(batch)})
+	case err == context.DeadlineExceeded && r.Context().Err() == nil:
+		logger.Errorf("Timed out processing batch of %d items", len(batch))
+		writeError(w, http.StatusGatewayTimeout, "timeout", "batch not processed in time")
+	case err == ctx.Err():
+		writeError(w, http.StatusRequestTimeout, "request_cancelled", "request cancelled")
+	case errors.Is(err, ErrQueueFull), errors.Is(err, ErrClosed):
+		logger.Errorf("Error enqueuing batch of %d items: %v", len(batch), err)
+		writeEnqueueError(w, err)
+	default:
+		logger.Errorf("Error processing batch of %d items: %v", len(batch), err)
+		writeError(w, http.StatusBadGateway, "processing_failed", err.Error())
+	}
+}
+
+// timeoutHeader is the header carrying the timeout of a /process-sync
+// request, also accepted as the timeout query parameter.
+const timeoutHeader = "X-Timeout"
+
+// requestTimeout returns the timeout r asks for as a Go duration in the
+// timeout query parameter or the X-Timeout header, zero if none.
+func requestTimeout(r *http.Request) (time.Duration, error) {
+	value := r.URL.Query().Get("timeout")
+	if value == "" {
+		value = r.Header.Get(timeoutHeader)
+	}
+	if value == "" {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(value)
 	if err != nil {
-		http.Error(w, "convert request to batch error", http.StatusBadRequest)
+		return 0, fmt.Errorf("timeout: %w", err)
+	}
+	if timeout <= 0 {
+		return 0, fmt.Errorf("timeout: %v is not positive", timeout)
+	}
+	return timeout, nil
+}
+
+// intervalHeader is the header carrying the interval to process the
+// sub-batches of a request at, a Go duration such as "500ms".
+const intervalHeader = "X-Process-Interval"
+
+// requestInterval returns the interval r asks for in the
+// X-Process-Interval header, zero if none.
+func requestInterval(r *http.Request) (time.Duration, error) {
+	value := r.Header.Get(intervalHeader)
+	if value == "" {
+		return 0, nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("process interval: %w", err)
+	}
+	if interval <= 0 {
+		return 0, fmt.Errorf("process interval: %v is not positive", interval)
+	}
+	return interval, nil
+}
+
+// writeEnqueueError writes the response to a request whose batch the
+// client didn't accept because of err.
+func writeEnqueueError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrQueueFull):
+		writeError(w, http.StatusServiceUnavailable, "queue_full", "queue is full")
+	case errors.Is(err, ErrClosed):
+		writeError(w, http.StatusServiceUnavailable, "client_closed", "client is closed")
+	case errors.Is(err, ErrTooManyItems):
+		writeError(w, http.StatusRequestEntityTooLarge, "too_many_items", "too many items")
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		// The client is most likely gone and won't read it anyway.
+		writeError(w, http.StatusRequestTimeout, "request_cancelled", "request cancelled")
+	default:
+		writeError(w, http.StatusInternalServerError, "internal", "enqueue batch error")
+	}
+}
+
+// handleHealthz reports that the server is up.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleReadyz reports whether client is ready to process batches.
+func handleReadyz(client *Client, w http.ResponseWriter, r *http.Request) {
+	if !client.ready(r.Context()) {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
 	}
-	client.Process(batch)
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -92,26 +1393,112 @@ func main() {
 	// Create an external service (e.g. dummyService)
 	// This assumes that dummyService implements the Service interface
 	externalService := &dummyService{
-		n: 10, // the number of items the service can handle
+		n: 10,              // the number of items the service can handle
 		p: time.Second * 2, // element processing time interval
 	}
 
 	client := NewClient(externalService)
 
-	// Run the client's Run method in a separate goroutine
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	go client.Run(ctx)
+	// Stop gracefully on SIGINT and SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	http.HandleFunc("/process", func(w http.ResponseWriter, r *http.Request) {
-		handleRequest(client, w, r)
-	})
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	cfg := DefaultServerConfig()
+	server := newServer(":8080", newMux(client, cfg), cfg)
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	grpcListener, err := net.Listen("tcp", ":9090")
+	if err != nil {
+		log.Fatal(err)
+	}
+	frontends := []listening{
+		{server, listener},
+		{newGRPCServer(client, cfg), grpcListener},
+	}
+	if err := serveAll(ctx, client, shutdownTimeout, frontends...); err != nil {
+		log.Fatal(err)
+	}
 
 	// curl -X POST -H "Content-Type: application/json" -d '[1, 2, 3, 4, 5]' http://localhost:8080/process
 	// Processed batch of 5 items
 }
 
+// shutdownTimeout is how long main waits for in-flight requests
+// and batches to complete on shutdown.
+const shutdownTimeout = time.Second * 30
+
+// serve runs client and serves HTTP requests on listener until ctx is done.
+// Then it shuts down gracefully within timeout: server stops accepting
+// requests and completes the in-flight ones, after that client processes
+// every batch accepted so far. Batches still in flight when timeout
+// passes are cancelled.
+func serve(ctx context.Context, server *http.Server, listener net.Listener, client *Client, timeout time.Duration) error {
+	return serveAll(ctx, client, timeout, listening{server, listener})
+}
+
+// frontend is a server submitting batches to the client,
+// such as http.Server.
+type frontend interface {
+	Serve(listener net.Listener) error
+	Shutdown(ctx context.Context) error
+	Close() error
+}
+
+// listening is a frontend with the listener it serves on.
+type listening struct {
+	server   frontend
+	listener net.Listener
+}
+
+// serveAll is serve for any number of frontends sharing client. They are
+// all shut down before client, and if any of them fails, the others are
+// closed.
+func serveAll(ctx context.Context, client *Client, timeout time.Duration, frontends ...listening) error {
+	runCtx, cancelRun := context.WithCancel(context.Background())
+	defer cancelRun()
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- client.Run(runCtx)
+	}()
+
+	serveErr := make(chan error, len(frontends))
+	for _, f := range frontends {
+		go func(f listening) {
+			serveErr <- f.server.Serve(f.listener)
+		}(f)
+	}
+	closeAll := func() {
+		for _, f := range frontends {
+			f.server.Close()
+		}
+	}
+
+	select {
+	case err := <-runErr:
+		closeAll()
+		return fmt.Errorf("run client: %w", err)
+	case err := <-serveErr:
+		closeAll()
+		return fmt.Errorf("serve: %w", err)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for _, f := range frontends {
+		if err := f.server.Shutdown(shutdownCtx); err != nil {
+			return fmt.Errorf("shutdown server: %w", err)
+		}
+	}
+	if err := client.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("shutdown client: %w", err)
+	}
+	return nil
+}
+
 type dummyService struct {
 	n uint64
 	p time.Duration
@@ -122,8 +1509,20 @@ func (s *dummyService) GetLimits() (uint64, time.Duration) {
 }
 
 func (s *dummyService) Process(ctx context.Context, batch Batch) error {
-	time.Sleep(s.p)
-	fmt.Printf("Processed batch of %d items\n", len(batch))
+	// Like a real service, a call takes p unless it is cancelled first.
+	timer := time.NewTimer(s.p)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	ids := make([]string, len(batch))
+	for i, item := range batch {
+		ids[i] = item.ID
+	}
+	fmt.Printf("Processed batch of %d items: %s\n", len(batch), strings.Join(ids, ", "))
 	return nil
 }
 
@@ -134,21 +1533,167 @@ func NewDummyService(n uint64, p time.Duration) *dummyService {
 	}
 }
 
+// convertRequestToBatch decodes the request body into a batch with an item
+// per element, choosing the decoder by the request Content-Type:
+// application/json, the default, for a JSON array and application/x-ndjson
+// for newline-delimited JSON values. Other content types fail with
+// ErrUnsupportedMediaType. The body is decoded element by element, so
+// a request with more than maxItems elements fails with ErrTooManyItems
+// without being read to the end.
+// Zero or less maxItems means no limit.
+// A body with Content-Encoding gzip is decompressed first, other encodings
+// fail with ErrUnsupportedMediaType as well.
+func convertRequestToBatch(r *http.Request, maxItems int) (Batch, error) {
+	return decodeRequest(r, maxItems, nil)
+}
 
-func convertRequestToBatch(r *http.Request) (Batch, error) {
+// decodeRequest is convertRequestToBatch decoding the media types of
+// decoders with them, see WithDecoder.
+func decodeRequest(r *http.Request, maxItems int, decoders map[string]Decoder) (Batch, error) {
 	defer r.Body.Close()
-	decoder := json.NewDecoder(r.Body)
 
-	var items []int
-	err := decoder.Decode(&items)
-	if err != nil {
+	mediaType := "application/json"
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		var err error
+		mediaType, _, err = mime.ParseMediaType(ct)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUnsupportedMediaType, err)
+		}
+	}
+
+	var body io.Reader = r.Body
+	switch encoding := strings.ToLower(r.Header.Get("Content-Encoding")); encoding {
+	case "", "identity":
+	case "gzip":
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("gzip: %w", err)
+		}
+		defer zr.Close()
+		body = zr
+	default:
+		return nil, fmt.Errorf("%w: content encoding %s", ErrUnsupportedMediaType, encoding)
+	}
+
+	if d, ok := decoders[mediaType]; ok {
+		batch, err := d.Decode(body)
+		if err != nil {
+			return nil, err
+		}
+		if maxItems > 0 && len(batch) > maxItems {
+			return nil, ErrTooManyItems
+		}
+		return batch, nil
+	}
+	switch mediaType {
+	case "application/json":
+		return decodeJSONArray(body, maxItems)
+	case "application/x-ndjson":
+		return decodeNDJSON(body, maxItems)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedMediaType, mediaType)
+	}
+}
+
+// decodeJSONArray decodes a JSON array from body into a batch
+// with an item per array element.
+func decodeJSONArray(body io.Reader, maxItems int) (Batch, error) {
+	decoder := json.NewDecoder(body)
+
+	if err := expectDelim(decoder, '['); err != nil {
 		return nil, err
 	}
 
-	batch := make(Batch, len(items))
-	for i := range items {
-		batch[i] = Item{}
+	var batch Batch
+	for decoder.More() {
+		if maxItems > 0 && len(batch) >= maxItems {
+			return nil, ErrTooManyItems
+		}
+
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			return nil, err
+		}
+		batch = append(batch, newItem(raw))
 	}
 
+	if err := expectDelim(decoder, ']'); err != nil {
+		return nil, err
+	}
 	return batch, nil
 }
+
+// decodeNDJSON decodes newline-delimited JSON values from body into
+// a batch with an item per value.
+func decodeNDJSON(body io.Reader, maxItems int) (Batch, error) {
+	decoder := json.NewDecoder(body)
+
+	var batch Batch
+	for {
+		var raw json.RawMessage
+		err := decoder.Decode(&raw)
+		if err == io.EOF {
+			return batch, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if maxItems > 0 && len(batch) >= maxItems {
+			return nil, ErrTooManyItems
+		}
+		batch = append(batch, newItem(raw))
+	}
+}
+
+// newItem returns the item decoded from raw.
+func newItem(raw json.RawMessage) Item {
+	id, weight := itemFields(raw)
+	return Item{
+		ID:      id,
+		Payload: raw,
+		Weight:  weight,
+	}
+}
+
+// expectDelim reads the next token from decoder and checks it is delim.
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	tok, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if tok != delim {
+		return fmt.Errorf("expected %v, got %v", delim, tok)
+	}
+	return nil
+}
+
+// itemFields returns the ID and the weight of an item decoded from raw.
+// The ID is the "id" field for objects and the value itself for anything
+// else. The weight is the "weight" field of objects if it is a non-negative
+// integer, zero otherwise.
+func itemFields(raw json.RawMessage) (id string, weight uint64) {
+	if len(raw) > 0 && raw[0] == '{' {
+		var obj struct {
+			ID     json.RawMessage `json:"id"`
+			Weight json.RawMessage `json:"weight"`
+		}
+		if err := json.Unmarshal(raw, &obj); err != nil {
+			return "", 0
+		}
+		if obj.Weight != nil {
+			// A weight of another type is ignored.
+			json.Unmarshal(obj.Weight, &weight)
+		}
+		if obj.ID == nil {
+			return "", weight
+		}
+		raw = obj.ID
+	}
+
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, weight
+	}
+	return string(raw), weight
+}
@@ -2,12 +2,15 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 )
@@ -48,32 +51,952 @@ func TestClientRun(t *testing.T) {
 	for i := range batch {
 		batch[i] = Item{}
 	}
-	client.Process(batch)
+	if err := client.Process(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	<-ctx.Done()
+}
+
+func TestClientProcessQueueFull(t *testing.T) {
+	client := NewClient(&testService{n: 2, p: time.Millisecond})
+
+	for i := 0; i < defaultQueueCapacity; i++ {
+		if err := client.Process(Batch{Item{}}); err != nil {
+			t.Fatalf("batch %d: unexpected error: %v", i, err)
+		}
+	}
+
+	if err := client.Process(Batch{Item{}}); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expected %v, got %v", ErrQueueFull, err)
+	}
+}
+
+func TestClientCapacityBounding(t *testing.T) {
+	const capacity = 3
+	client := NewClient(&testService{n: 2, p: time.Millisecond}, WithQueueCapacity(capacity))
+
+	for i := 0; i < capacity; i++ {
+		if err := client.Process(Batch{Item{}}); err != nil {
+			t.Fatalf("batch %d: unexpected error: %v", i, err)
+		}
+	}
+	for i := 0; i < capacity; i++ {
+		if err := client.Process(Batch{Item{}}); !errors.Is(err, ErrQueueFull) {
+			t.Fatalf("batch %d: expected %v, got %v", capacity+i, ErrQueueFull, err)
+		}
+	}
+
+	blocked := make(chan struct{})
+	go func() {
+		client.ProcessBlocking(Batch{Item{}})
+		close(blocked)
+	}()
+
+	select {
+	case <-blocked:
+		t.Fatal("ProcessBlocking returned while the queue is full")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	select {
+	case <-blocked:
+	case <-time.After(time.Second):
+		t.Fatal("ProcessBlocking did not return after the queue was drained")
+	}
+}
+
+func TestClientUnbufferedQueue(t *testing.T) {
+	client := NewClient(&testService{n: 2, p: time.Millisecond}, WithQueueCapacity(0))
+
+	if err := client.Process(Batch{Item{}}); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expected %v, got %v", ErrQueueFull, err)
+	}
+}
+
+func TestClientRunInvalidLimits(t *testing.T) {
+	tests := []struct {
+		n uint64
+		p time.Duration
+	}{
+		{0, time.Millisecond},
+		{2, 0},
+	}
+
+	for _, tt := range tests {
+		client := NewClient(&testService{n: tt.n, p: tt.p})
+		result := client.ProcessWithResult(Batch{Item{}})
+
+		done := make(chan error, 1)
+		go func() {
+			done <- client.Run(context.Background())
+		}()
+
+		select {
+		case err := <-done:
+			if !errors.Is(err, ErrInvalidLimits) {
+				t.Errorf("n=%d p=%v: expected %v, got %v", tt.n, tt.p, ErrInvalidLimits, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("n=%d p=%v: Run did not return", tt.n, tt.p)
+		}
+		if err := receiveResult(t, result); !errors.Is(err, ErrInvalidLimits) {
+			t.Errorf("n=%d p=%v: expected the queued batch to fail with %v, got %v", tt.n, tt.p, ErrInvalidLimits, err)
+		}
+		if err := client.Process(Batch{Item{}}); !errors.Is(err, ErrClosed) {
+			t.Errorf("n=%d p=%v: expected %v after Run failed, got %v", tt.n, tt.p, ErrClosed, err)
+		}
+	}
+}
+
+func TestClientRunResult(t *testing.T) {
+	client := NewClient(&testService{n: 2, p: time.Millisecond})
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Run(context.Background())
+	}()
+	if err := client.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("expected nil after Shutdown, got %v", err)
+	}
+
+	client = NewClient(&testService{n: 2, p: time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := client.Run(ctx); err != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestClientShutdown(t *testing.T) {
+	service := &recordingService{
+		n: 2,
+		p: time.Millisecond * 5,
+	}
+
+	client := NewClient(service)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for i := 0; i < 3; i++ {
+		if err := client.Process(make(Batch, 3)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	go client.Run(ctx)
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), time.Second)
+	defer shutdownCancel()
+
+	if err := client.Shutdown(shutdownCtx); err != nil {
+		t.Fatal(err)
+	}
+
+	items := 0
+	for _, c := range service.recorded() {
+		items += len(c.batch)
+	}
+	if items != 9 {
+		t.Errorf("expected 9 processed items, got %d", items)
+	}
+
+	if err := client.Process(make(Batch, 1)); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected %v, got %v", ErrClosed, err)
+	}
+	if err := client.ProcessBlocking(make(Batch, 1)); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected %v, got %v", ErrClosed, err)
+	}
+}
+
+func TestClientClose(t *testing.T) {
+	service := &recordingService{n: 2, p: time.Millisecond}
+	client := NewClient(service)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- client.Run(ctx)
+	}()
+
+	if err := client.Process(make(Batch, 3)); err != nil {
+		t.Fatal(err)
+	}
+	// Close waits for the batch once Run has started.
+	waitCalls(t, service, 1, time.Second)
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Run is done by the time Close returns.
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Errorf("expected Run to return nil, got %v", err)
+		}
+	default:
+		t.Error("expected Run to be done")
+	}
+	if calls := len(service.recorded()); calls != 2 {
+		t.Errorf("expected the queued batch to be processed in 2 calls, got %d", calls)
+	}
+
+	if err := client.Close(); err != nil {
+		t.Errorf("expected the second Close to return nil, got %v", err)
+	}
+	if err := client.Process(make(Batch, 1)); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected %v, got %v", ErrClosed, err)
+	}
+}
+
+func TestClientCloseNotRunning(t *testing.T) {
+	letters := &deadLetters{}
+	client := NewClient(&recordingService{n: 2, p: time.Millisecond}, WithDeadLetter(letters.add))
+
+	result := client.ProcessWithResult(make(Batch, 3))
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := receiveResult(t, result); !errors.Is(err, ErrUnprocessed) || !errors.Is(err, ErrClosed) {
+		t.Errorf("expected %v and %v, got %v", ErrUnprocessed, ErrClosed, err)
+	}
+	if batches, _ := letters.recorded(); len(batches) != 1 || len(batches[0]) != 3 {
+		t.Errorf("expected the queued batch to be dead-lettered, got %v", batches)
+	}
+}
+
+func TestClientShutdownTimeout(t *testing.T) {
+	client := NewClient(NewDummyService(1, time.Millisecond*100))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	if err := client.Process(make(Batch, 5)); err != nil {
+		t.Fatal(err)
+	}
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer shutdownCancel()
+
+	if err := client.Shutdown(shutdownCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+// receiveResult waits for the outcome delivered by ProcessWithResult
+// and checks that the channel is closed afterwards.
+func receiveResult(t *testing.T, result <-chan error) error {
+	t.Helper()
+
+	var err error
+	select {
+	case err = <-result:
+	case <-time.After(time.Second):
+		t.Fatal("result was not delivered")
+	}
+
+	select {
+	case _, ok := <-result:
+		if ok {
+			t.Fatal("result channel delivered more than one value")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("result channel was not closed")
+	}
+	return err
+}
+
+func TestClientProcessContext(t *testing.T) {
+	client := NewClient(&testService{n: 2, p: time.Millisecond}, WithQueueCapacity(1))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := client.ProcessContext(ctx, Batch{Item{}}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
+	if n := client.queue.len(); n != 0 {
+		t.Fatalf("expected nothing enqueued, got %d batches", n)
+	}
+
+	if err := client.ProcessContext(context.Background(), Batch{Item{}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.ProcessContext(context.Background(), Batch{Item{}}); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expected %v on a full queue, got %v", ErrQueueFull, err)
+	}
+}
+
+func TestClientProcessContextBlock(t *testing.T) {
+	client := NewClient(&testService{n: 2, p: time.Millisecond},
+		WithQueueCapacity(1),
+		WithBackpressure(BackpressureBlock),
+	)
+	if err := client.Process(Batch{Item{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	if err := client.ProcessContext(ctx, Batch{Item{}}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected %v on a full queue, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestClientProcessWithResult(t *testing.T) {
+	client := NewClient(&recordingService{n: 2, p: time.Millisecond})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	if err := receiveResult(t, client.ProcessWithResult(make(Batch, 3))); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+func TestClientProcessWithResultError(t *testing.T) {
+	service := &flakyService{n: 2, p: time.Millisecond, failures: 1}
+	client := NewClient(service)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	err := receiveResult(t, client.ProcessWithResult(make(Batch, 4)))
+	if err == nil || err.Error() != "temporary failure" {
+		t.Fatalf("expected the failure of the first sub-batch, got %v", err)
+	}
+}
+
+func TestClientProcessWithResultCancel(t *testing.T) {
+	client := NewClient(&recordingService{n: 1, p: time.Hour})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go client.Run(ctx)
+
+	result := client.ProcessWithResult(make(Batch, 3))
+	time.Sleep(time.Millisecond * 20)
+	cancel()
+
+	if err := receiveResult(t, result); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestClientRunCancelDuringWait(t *testing.T) {
+	service := &recordingService{n: 1, p: time.Hour}
+	client := NewClient(service)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- client.Run(ctx)
+	}()
+
+	// The second sub-batch waits an hour for the limiter.
+	if err := client.Process(make(Batch, 2)); err != nil {
+		t.Fatal(err)
+	}
+	waitCalls(t, service, 1, time.Second)
+	start := time.Now()
+	cancel()
+
+	select {
+	case err := <-stopped:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected %v, got %v", context.Canceled, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected Run to stop while waiting for the limiter")
+	}
+	if elapsed := time.Since(start); elapsed > time.Millisecond*500 {
+		t.Errorf("expected Run to stop promptly, took %v", elapsed)
+	}
+}
+
+func TestClientProcessAndWait(t *testing.T) {
+	client := NewClient(&testService{n: 2, p: time.Millisecond})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	if err := client.ProcessAndWait(ctx, make(Batch, 3)); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
+
+func TestClientProcessAndWaitDeadline(t *testing.T) {
+	service := newSlowService()
+	service.n = 1
+	client := NewClient(service)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	waitCtx, cancelWait := context.WithTimeout(ctx, time.Millisecond*20)
+	defer cancelWait()
+	if err := client.ProcessAndWait(waitCtx, make(Batch, 3)); err != context.DeadlineExceeded {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	// The batch is cancelled after the sub-batch in flight.
+	close(service.release)
+	if err := client.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if calls := len(service.recorded()); calls != 1 {
+		t.Errorf("expected the rest of the batch to be skipped, got %d calls", calls)
+	}
+}
+
+func TestClientProcessWithResultNotDequeued(t *testing.T) {
+	client := NewClient(&recordingService{n: 1, p: time.Millisecond})
+	result := client.ProcessWithResult(make(Batch, 1))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	client.Run(ctx)
+
+	// The batch may or may not be dequeued before Run notices ctx is done,
+	// but the outcome must be delivered either way.
+	receiveResult(t, result)
+
+	if err := receiveResult(t, client.ProcessWithResult(make(Batch, 1))); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected %v, got %v", ErrClosed, err)
+	}
+}
+
+func TestClientProcessWithResultQueueFull(t *testing.T) {
+	client := NewClient(&recordingService{n: 1, p: time.Millisecond}, WithQueueCapacity(0))
+
+	if err := receiveResult(t, client.ProcessWithResult(make(Batch, 1))); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expected %v, got %v", ErrQueueFull, err)
+	}
+}
+
+func TestClientProcessWithLimits(t *testing.T) {
+	tests := []struct {
+		name string
+		n    uint64
+		want []int
+	}{
+		{name: "smaller", n: 2, want: []int{2, 2, 2, 1}},
+		{name: "clamped", n: 10, want: []int{4, 3}},
+		{name: "default", n: 0, want: []int{4, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &recordingService{n: 4, p: time.Millisecond}
+			client := NewClient(service)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go client.Run(ctx)
+
+			if err := client.ProcessWithLimits(make(Batch, 7), tt.n, 0); err != nil {
+				t.Fatal(err)
+			}
+
+			calls := waitCalls(t, service, len(tt.want), time.Second)
+			for i, want := range tt.want {
+				if got := len(calls[i].batch); got != want {
+					t.Errorf("sub-batch %d: expected %d items, got %d", i, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestClientProcessWithLimitsInterval(t *testing.T) {
+	const p = time.Millisecond * 30
+
+	service := &recordingService{n: 1, p: time.Millisecond}
+	client := NewClient(service)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	if err := client.ProcessWithLimits(make(Batch, 3), 0, p); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := waitCalls(t, service, 3, time.Second)
+	for i := 1; i < len(calls); i++ {
+		if gap := calls[i].at.Sub(calls[i-1].at); gap < p-time.Millisecond*2 {
+			t.Errorf("sub-batches %d and %d are %v apart, expected at least %v", i-1, i, gap, p)
+		}
+	}
+}
+
+func TestClientDeadLetter(t *testing.T) {
+	service := &flakyService{n: 2, p: time.Millisecond, failures: 2}
+
+	type letter struct {
+		batch Batch
+		err   error
+	}
+	var letters []letter
+	client := NewClient(service,
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}),
+		WithDeadLetter(func(batch Batch, err error) {
+			letters = append(letters, letter{batch: batch, err: err})
+		}),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	batch := Batch{{ID: "1"}, {ID: "2"}, {ID: "3"}}
+	receiveResult(t, client.ProcessWithResult(batch))
+
+	if len(letters) != 1 {
+		t.Fatalf("expected 1 dead letter, got %d", len(letters))
+	}
+	if got := letters[0].batch; len(got) != 2 || got[0].ID != "1" || got[1].ID != "2" {
+		t.Errorf("expected the first sub-batch with items 1 and 2, got %v", got)
+	}
+	if letters[0].err == nil {
+		t.Error("expected the dead letter to carry an error")
+	}
+	if calls, _ := service.counts(); calls != 3 {
+		t.Errorf("expected 3 calls, got %d", calls)
+	}
+}
+
+// cancellingService calls cancel once it has got its first sub-batch.
+type cancellingService struct {
+	recordingService
+	cancel context.CancelFunc
+}
+
+func (s *cancellingService) Process(ctx context.Context, batch Batch) error {
+	s.recordingService.Process(ctx, batch)
+	s.cancel()
+	return nil
+}
+
+func TestClientDeadLetterUnprocessed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	service := &cancellingService{
+		recordingService: recordingService{n: 2, p: time.Millisecond},
+		cancel:           cancel,
+	}
+
+	var letters []Batch
+	var letterErr error
+	client := NewClient(service, WithDeadLetter(func(batch Batch, err error) {
+		letters = append(letters, batch)
+		letterErr = err
+	}))
+
+	batch := Batch{{ID: "1"}, {ID: "2"}, {ID: "3"}, {ID: "4"}, {ID: "5"}}
+	err := client.ProcessAll(ctx, batch)
+	if !errors.Is(err, ErrUnprocessed) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected %v and %v, got %v", ErrUnprocessed, context.Canceled, err)
+	}
+
+	if calls := len(service.recorded()); calls != 1 {
+		t.Fatalf("expected 1 call, got %d", calls)
+	}
+	if len(letters) != 1 {
+		t.Fatalf("expected 1 dead letter, got %d", len(letters))
+	}
+	if got := letters[0]; len(got) != 3 || got[0].ID != "3" || got[2].ID != "5" {
+		t.Errorf("expected the unprocessed items 3 to 5, got %v", got)
+	}
+	if !errors.Is(letterErr, ErrUnprocessed) {
+		t.Errorf("expected the dead letter to carry %v, got %v", ErrUnprocessed, letterErr)
+	}
+}
+
+func TestClientDeadLetterQueued(t *testing.T) {
+	var letters []Batch
+	var letterErr error
+	client := NewClient(&testService{n: 2, p: time.Millisecond}, WithDeadLetter(func(batch Batch, err error) {
+		letters = append(letters, batch)
+		letterErr = err
+	}))
+
+	batch := Batch{{ID: "1"}, {ID: "2"}, {ID: "3"}}
+	if err := client.Process(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	// Run stops right away, so the batch is never dispatched.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	client.Run(ctx)
+
+	if len(letters) != 1 || len(letters[0]) != len(batch) {
+		t.Fatalf("expected the queued batch as a dead letter, got %v", letters)
+	}
+	if !errors.Is(letterErr, ErrUnprocessed) || !errors.Is(letterErr, context.Canceled) {
+		t.Errorf("expected %v and %v, got %v", ErrUnprocessed, context.Canceled, letterErr)
+	}
+}
+
+// failingService fails the sub-batches starting with the given item IDs.
+type failingService struct {
+	recordingService
+	fail map[string]bool
+}
+
+func (s *failingService) Process(ctx context.Context, batch Batch) error {
+	s.recordingService.Process(ctx, batch)
+	if s.fail[batch[0].ID] {
+		return fmt.Errorf("failed sub-batch starting with %s", batch[0].ID)
+	}
+	return nil
+}
+
+func TestClientProcessAll(t *testing.T) {
+	service := &failingService{
+		recordingService: recordingService{n: 2, p: time.Millisecond},
+		fail:             map[string]bool{"3": true},
+	}
+	client := NewClient(service)
+
+	batch := Batch{{ID: "1"}, {ID: "2"}, {ID: "3"}, {ID: "4"}, {ID: "5"}}
+	err := client.ProcessAll(context.Background(), batch)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		t.Fatalf("expected a joined error, got %T", err)
+	}
+	if errs := joined.Unwrap(); len(errs) != 1 || errs[0].Error() != "failed sub-batch starting with 3" {
+		t.Errorf("expected only the second sub-batch failure, got %v", errs)
+	}
+	if calls := len(service.recorded()); calls != 3 {
+		t.Errorf("expected 3 calls, got %d", calls)
+	}
+
+	if err := client.ProcessAll(context.Background(), batch[:2]); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
+
+func TestConvertRequestToBatch(t *testing.T) {
+	items := []int{1, 2, 3, 4, 5}
+	data, err := json.Marshal(items)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("POST", "/process", bytes.NewBuffer(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	batch, err := convertRequestToBatch(req, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(batch) != len(items) {
+		t.Fatalf("expected %d items, got %d", len(items), len(batch))
+	}
+}
+
+func TestConvertRequestToBatchPayload(t *testing.T) {
+	body := `[1, "two", {"id": 3, "name": "three"}, {"name": "four"}]`
+
+	req, err := http.NewRequest("POST", "/process", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	batch, err := convertRequestToBatch(req, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Batch{
+		{ID: "1", Payload: []byte(`1`)},
+		{ID: "two", Payload: []byte(`"two"`)},
+		{ID: "3", Payload: []byte(`{"id": 3, "name": "three"}`)},
+		{ID: "", Payload: []byte(`{"name": "four"}`)},
+	}
+	if len(batch) != len(expected) {
+		t.Fatalf("expected %d items, got %d", len(expected), len(batch))
+	}
+	for i, want := range expected {
+		if batch[i].ID != want.ID {
+			t.Errorf("item %d: expected ID %q, got %q", i, want.ID, batch[i].ID)
+		}
+		if !bytes.Equal(batch[i].Payload, want.Payload) {
+			t.Errorf("item %d: expected payload %s, got %s", i, want.Payload, batch[i].Payload)
+		}
+	}
+}
+
+func TestConvertRequestToBatchContentType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		body        string
+	}{
+		{"", `[1, {"id": "two"}, 3]`},
+		{"application/json", `[1, {"id": "two"}, 3]`},
+		{"application/json; charset=utf-8", `[1, {"id": "two"}, 3]`},
+		{"application/x-ndjson", "1\n{\"id\": \"two\"}\n\n3\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/process", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+
+			batch, err := convertRequestToBatch(req, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := []string{"1", "two", "3"}
+			if len(batch) != len(want) {
+				t.Fatalf("expected %d items, got %d", len(want), len(batch))
+			}
+			for i, id := range want {
+				if batch[i].ID != id {
+					t.Errorf("item %d: expected ID %q, got %q", i, id, batch[i].ID)
+				}
+			}
+		})
+	}
+}
+
+func TestConvertRequestToBatchNDJSONMaxItems(t *testing.T) {
+	req := httptest.NewRequest("POST", "/process", strings.NewReader("1\n2\n3\n"))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	if _, err := convertRequestToBatch(req, 2); !errors.Is(err, ErrTooManyItems) {
+		t.Fatalf("expected %v, got %v", ErrTooManyItems, err)
+	}
+}
 
-	<-ctx.Done()
+func TestConvertRequestToBatchGzip(t *testing.T) {
+	var body bytes.Buffer
+	zw := gzip.NewWriter(&body)
+	if _, err := zw.Write([]byte(`[1, {"id": "two"}, 3]`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("POST", "/process", &body)
+	req.Header.Set("Content-Encoding", "gzip")
+
+	batch, err := convertRequestToBatch(req, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"1", "two", "3"}
+	if len(batch) != len(want) {
+		t.Fatalf("expected %d items, got %d", len(want), len(batch))
+	}
+	for i, id := range want {
+		if batch[i].ID != id {
+			t.Errorf("item %d: expected ID %q, got %q", i, id, batch[i].ID)
+		}
+	}
 }
 
-func TestConvertRequestToBatch(t *testing.T) {
-	items := []int{1, 2, 3, 4, 5}
-	data, err := json.Marshal(items)
+func TestHandleRequestCorruptGzip(t *testing.T) {
+	client := NewClient(&testService{n: 2, p: time.Millisecond})
+
+	req := httptest.NewRequest("POST", "/process", strings.NewReader("[1, 2, 3]"))
+	req.Header.Set("Content-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	handleRequest(client, rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected %v, got %v", http.StatusBadRequest, rr.Code)
+	}
+	if n := client.Stats().QueueLength; n != 0 {
+		t.Errorf("expected nothing queued, got %d batches", n)
+	}
+}
+
+func TestHandleRequestUnsupportedEncoding(t *testing.T) {
+	client := NewClient(&testService{n: 2, p: time.Millisecond})
+
+	req := httptest.NewRequest("POST", "/process", strings.NewReader("[1, 2, 3]"))
+	req.Header.Set("Content-Encoding", "br")
+	rr := httptest.NewRecorder()
+	handleRequest(client, rr, req)
+
+	if rr.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected %v, got %v", http.StatusUnsupportedMediaType, rr.Code)
+	}
+}
+
+func TestHandleRequestUnsupportedMediaType(t *testing.T) {
+	client := NewClient(&testService{n: 2, p: time.Millisecond})
+
+	req := httptest.NewRequest("POST", "/process", strings.NewReader("1,2,3"))
+	req.Header.Set("Content-Type", "text/csv")
+	rr := httptest.NewRecorder()
+	handleRequest(client, rr, req)
+
+	if status := rr.Code; status != http.StatusUnsupportedMediaType {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnsupportedMediaType)
+	}
+	if n := client.queue.len(); n != 0 {
+		t.Errorf("expected nothing enqueued, got %d batches", n)
+	}
+}
+
+func TestHandleRequestPayloadRoundTrip(t *testing.T) {
+	service := &recordingService{n: 2, p: time.Millisecond}
+	client := NewClient(service)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	req, err := http.NewRequest("POST", "/process", bytes.NewBufferString(`[10, 20, 30]`))
 	if err != nil {
 		t.Fatal(err)
 	}
+	handleRequest(client, httptest.NewRecorder(), req)
 
-	req, err := http.NewRequest("POST", "/process", bytes.NewBuffer(data))
+	var items Batch
+	for _, c := range waitCalls(t, service, 2, time.Second) {
+		items = append(items, c.batch...)
+	}
+
+	for i, want := range []string{"10", "20", "30"} {
+		if items[i].ID != want || string(items[i].Payload) != want {
+			t.Errorf("item %d: expected ID and payload %q, got %q and %q", i, want, items[i].ID, items[i].Payload)
+		}
+	}
+}
+
+// endlessArray is an endless JSON array of numbers.
+type endlessArray struct {
+	started bool
+	read    int
+}
+
+func (a *endlessArray) Read(p []byte) (int, error) {
+	n := 0
+	if !a.started {
+		p[0] = '['
+		a.started = true
+		n++
+	}
+	for ; n+1 < len(p); n += 2 {
+		p[n], p[n+1] = '1', ','
+	}
+	a.read += n
+	return n, nil
+}
+
+func TestConvertRequestToBatchMaxItems(t *testing.T) {
+	body := &endlessArray{}
+	req, err := http.NewRequest("POST", "/process", body)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	req.Header.Set("Content-Type", "application/json")
+	if _, err := convertRequestToBatch(req, 1000); !errors.Is(err, ErrTooManyItems) {
+		t.Fatalf("expected %v, got %v", ErrTooManyItems, err)
+	}
+	// The decoder reads ahead, but nowhere near the whole body.
+	if body.read > 1<<20 {
+		t.Errorf("expected the body to be read partially, read %d bytes", body.read)
+	}
 
-	batch, err := convertRequestToBatch(req)
+	req, err = http.NewRequest("POST", "/process", bytes.NewBufferString("[1, 2, 3]"))
 	if err != nil {
 		t.Fatal(err)
 	}
+	if batch, err := convertRequestToBatch(req, 3); err != nil || len(batch) != 3 {
+		t.Fatalf("expected 3 items within the limit, got %d and %v", len(batch), err)
+	}
+}
 
-	if len(batch) != len(items) {
-		t.Fatalf("expected %d items, got %d", len(items), len(batch))
+func TestConvertRequestToBatchMalformed(t *testing.T) {
+	for _, body := range []string{"", "{}", "1", "[1, 2", "[1 2]", "[1, 2}"} {
+		req, err := http.NewRequest("POST", "/process", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := convertRequestToBatch(req, 0); err == nil {
+			t.Errorf("%q: expected an error", body)
+		}
+	}
+}
+
+func TestHandleRequestTooManyItems(t *testing.T) {
+	client := NewClient(NewDummyService(2, time.Millisecond))
+
+	body := "[" + strings.Repeat("1,", defaultMaxRequestItems) + "1]"
+	rr := httptest.NewRecorder()
+	handleRequest(client, rr, httptest.NewRequest("POST", "/process", strings.NewReader(body)))
+
+	if status := rr.Code; status != http.StatusRequestEntityTooLarge {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusRequestEntityTooLarge)
+	}
+	if n := client.queue.len(); n != 0 {
+		t.Errorf("expected nothing enqueued, got %d batches", n)
+	}
+}
+
+func TestHandleRequestWithMaxItems(t *testing.T) {
+	tests := []struct {
+		body string
+		want int
+	}{
+		{"[1, 2, 3]", http.StatusOK},
+		{"[1, 2, 3, 4]", http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		client := NewClient(NewDummyService(2, time.Millisecond))
+
+		rr := httptest.NewRecorder()
+		handleRequestWithMaxItems(client, 3, rr, httptest.NewRequest("POST", "/process", strings.NewReader(tt.body)))
+
+		if status := rr.Code; status != tt.want {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", tt.body, status, tt.want)
+		}
+		if n := client.queue.len(); tt.want != http.StatusOK && n != 0 {
+			t.Errorf("%s: expected nothing enqueued, got %d batches", tt.body, n)
+		}
+	}
+}
+
+func TestDummyServiceCancel(t *testing.T) {
+	service := NewDummyService(2, time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	start := time.Now()
+	err := service.Process(ctx, Batch{{ID: "1"}})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected Process to return once cancelled, took %v", elapsed)
 	}
 }
 
@@ -110,3 +1033,526 @@ func TestHandleRequest(t *testing.T) {
 		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
 	}
 }
+
+type tenantKey struct{}
+
+// contextService records the tenant in the context of every Process call.
+type contextService struct {
+	recordingService
+	tenants chan any
+}
+
+func (s *contextService) Process(ctx context.Context, batch Batch) error {
+	s.tenants <- ctx.Value(tenantKey{})
+	return s.recordingService.Process(ctx, batch)
+}
+
+func TestHandleRequestContextValues(t *testing.T) {
+	service := &contextService{
+		recordingService: recordingService{n: 2, p: time.Millisecond},
+		tenants:          make(chan any, 2),
+	}
+	client := NewClient(service)
+
+	// The request is done, and its context cancelled, before Run starts.
+	reqCtx, cancelReq := context.WithCancel(context.WithValue(context.Background(), tenantKey{}, "acme"))
+	r := httptest.NewRequest("POST", "/process", strings.NewReader("[1, 2, 3]")).WithContext(reqCtx)
+	rr := httptest.NewRecorder()
+	handleRequest(client, rr, r)
+	cancelReq()
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected %v, got %v", http.StatusOK, rr.Code)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+	if err := client.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	close(service.tenants)
+	calls := 0
+	for tenant := range service.tenants {
+		calls++
+		if tenant != "acme" {
+			t.Errorf("expected Process to see the tenant of the request, got %v", tenant)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 calls, got %d", calls)
+	}
+}
+
+func TestHandleRequestQueueFull(t *testing.T) {
+	client := NewClient(NewDummyService(2, time.Millisecond))
+	for i := 0; i < defaultQueueCapacity; i++ {
+		client.ProcessBlocking(Batch{Item{}})
+	}
+
+	req, err := http.NewRequest("POST", "/process", bytes.NewBufferString("[1, 2, 3]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handleRequest(client, rr, req)
+
+	if status := rr.Code; status != http.StatusServiceUnavailable {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusServiceUnavailable)
+	}
+	if body := rr.Body.String(); body != `{"error":"queue is full","code":"queue_full"}`+"\n" {
+		t.Errorf("handler returned unexpected body: got %q", body)
+	}
+}
+
+func TestHandleRequestCancelled(t *testing.T) {
+	client := NewClient(NewDummyService(2, time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rr := httptest.NewRecorder()
+	handleRequest(client, rr, httptest.NewRequest("POST", "/process", strings.NewReader("[1, 2, 3]")).WithContext(ctx))
+
+	if status := rr.Code; status != http.StatusRequestTimeout {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusRequestTimeout)
+	}
+	if n := client.queue.len(); n != 0 {
+		t.Errorf("expected nothing enqueued, got %d batches", n)
+	}
+}
+
+func TestItemValidate(t *testing.T) {
+	tests := []struct {
+		payload string
+		valid   bool
+	}{
+		{`1`, true},
+		{`{"id": 1}`, true},
+		{`""`, true},
+		{``, false},
+		{`null`, false},
+		{` null `, false},
+	}
+
+	for _, tt := range tests {
+		err := Item{Payload: []byte(tt.payload)}.Validate()
+		if tt.valid && err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.payload, err)
+		}
+		if !tt.valid && !errors.Is(err, ErrEmptyPayload) {
+			t.Errorf("%q: expected %v, got %v", tt.payload, ErrEmptyPayload, err)
+		}
+	}
+}
+
+func TestHandleRequestInvalidItems(t *testing.T) {
+	const body = `[1, null, 2, null]`
+
+	tests := []struct {
+		name    string
+		lenient bool
+		status  int
+		body    string
+		items   int
+	}{
+		{name: "strict", status: http.StatusBadRequest, body: `{"error":"2 invalid items","code":"invalid_items"}` + "\n"},
+		{name: "lenient", lenient: true, status: http.StatusOK, items: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewClient(NewDummyService(2, time.Millisecond))
+			h := newRequestHandler(client)
+			h.lenient = tt.lenient
+
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest("POST", "/process", strings.NewReader(body)))
+
+			if status := rr.Code; status != tt.status {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.status)
+			}
+			if tt.body != "" && rr.Body.String() != tt.body {
+				t.Errorf("handler returned unexpected body: got %q want %q", rr.Body.String(), tt.body)
+			}
+
+			if tt.items == 0 {
+				if n := client.queue.len(); n != 0 {
+					t.Errorf("expected nothing enqueued, got %d batches", n)
+				}
+				return
+			}
+			j := client.queue.pop()
+			if j == nil || len(j.batch) != tt.items {
+				t.Fatalf("expected a batch of %d valid items, got %v", tt.items, j)
+			}
+			for _, item := range j.batch {
+				if err := item.Validate(); err != nil {
+					t.Errorf("enqueued an invalid item %v", item)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleRequestClosed(t *testing.T) {
+	client := NewClient(NewDummyService(2, time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	client.Run(ctx)
+
+	rr := httptest.NewRecorder()
+	handleRequest(client, rr, httptest.NewRequest("POST", "/process", strings.NewReader("[1, 2, 3]")))
+
+	if status := rr.Code; status != http.StatusServiceUnavailable {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusServiceUnavailable)
+	}
+	if body := rr.Body.String(); body != `{"error":"client is closed","code":"client_closed"}`+"\n" {
+		t.Errorf("handler returned unexpected body: got %q", body)
+	}
+}
+
+func TestHandleRequestBadRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{name: "malformed", body: "[1, 2", want: `{"error":"convert request to batch error","code":"invalid_request"}` + "\n"},
+		{name: "empty", body: "[]", want: `{"error":"empty batch","code":"empty_batch"}` + "\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewClient(NewDummyService(2, time.Millisecond))
+
+			rr := httptest.NewRecorder()
+			handleRequest(client, rr, httptest.NewRequest("POST", "/process", bytes.NewBufferString(tt.body)))
+
+			if status := rr.Code; status != http.StatusBadRequest {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+			}
+			if body := rr.Body.String(); body != tt.want {
+				t.Errorf("handler returned unexpected body: got %q want %q", body, tt.want)
+			}
+			if n := client.queue.len(); n != 0 {
+				t.Errorf("expected nothing enqueued, got %d batches", n)
+			}
+		})
+	}
+}
+
+func TestHandleHealthz(t *testing.T) {
+	rr := httptest.NewRecorder()
+	handleHealthz(rr, httptest.NewRequest("GET", "/healthz", nil))
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+}
+
+func TestHandleReadyz(t *testing.T) {
+	client := NewClient(&recordingService{n: 2, p: time.Millisecond})
+
+	readyz := func() int {
+		rr := httptest.NewRecorder()
+		handleReadyz(client, rr, httptest.NewRequest("GET", "/readyz", nil))
+		return rr.Code
+	}
+
+	if status := readyz(); status != http.StatusServiceUnavailable {
+		t.Errorf("before Run: got status %v want %v", status, http.StatusServiceUnavailable)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	deadline := time.After(time.Second)
+	for readyz() != http.StatusOK {
+		select {
+		case <-deadline:
+			t.Fatal("client did not become ready")
+		case <-time.After(time.Millisecond):
+		}
+	}
+
+	if err := client.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := readyz(); status != http.StatusServiceUnavailable {
+		t.Errorf("after Shutdown: got status %v want %v", status, http.StatusServiceUnavailable)
+	}
+}
+
+func TestServe(t *testing.T) {
+	service := &recordingService{n: 2, p: time.Millisecond * 5}
+	client := NewClient(service)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/process", func(w http.ResponseWriter, r *http.Request) {
+		handleRequest(client, w, r)
+	})
+	server := &http.Server{Handler: mux}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(ctx, server, listener, client, time.Second)
+	}()
+
+	resp, err := http.Post("http://"+listener.Addr().String()+"/process", "application/json", strings.NewReader("[1, 2, 3, 4, 5]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %v, got %v", http.StatusOK, resp.StatusCode)
+	}
+
+	// Stop right away, as a signal would, while the batch is still in flight.
+	cancel()
+
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("serve did not return")
+	}
+
+	items := 0
+	for _, c := range service.recorded() {
+		items += len(c.batch)
+	}
+	if items != 5 {
+		t.Errorf("expected 5 processed items, got %d", items)
+	}
+	if err := client.Process(make(Batch, 1)); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected %v, got %v", ErrClosed, err)
+	}
+}
+
+func TestServeShutdownTimeout(t *testing.T) {
+	client := NewClient(&recordingService{n: 1, p: time.Hour})
+	if err := client.Process(make(Batch, 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+
+	err = serve(ctx, &http.Server{}, listener, client, time.Millisecond*20)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestServeRunError(t *testing.T) {
+	client := NewClient(&testService{n: 0, p: time.Millisecond})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(context.Background(), &http.Server{}, listener, client, time.Second)
+	}()
+
+	select {
+	case err := <-served:
+		if !errors.Is(err, ErrInvalidLimits) {
+			t.Errorf("expected %v, got %v", ErrInvalidLimits, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("serve did not return")
+	}
+}
+
+func TestHandleRequestSync(t *testing.T) {
+	tests := []struct {
+		name   string
+		fail   map[string]bool
+		target string
+		status int
+		code   string
+	}{
+		{name: "processed", target: "/process-sync", status: http.StatusOK},
+		{name: "failed", fail: map[string]bool{"3": true}, target: "/process-sync", status: http.StatusBadGateway, code: "processing_failed"},
+		{name: "invalid timeout", target: "/process-sync?timeout=soon", status: http.StatusBadRequest, code: "invalid_timeout"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &failingService{
+				recordingService: recordingService{n: 2, p: time.Millisecond},
+				fail:             tt.fail,
+			}
+			client := NewClient(service)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go client.Run(ctx)
+
+			rr := httptest.NewRecorder()
+			handleRequestSync(client, rr, httptest.NewRequest("POST", tt.target, strings.NewReader("[1, 2, 3, 4]")))
+
+			if rr.Code != tt.status {
+				t.Fatalf("expected %v, got %v: %s", tt.status, rr.Code, rr.Body)
+			}
+			if tt.code == "" {
+				var resp syncResponse
+				if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+					t.Fatal(err)
+				}
+				if resp.Processed != 4 {
+					t.Errorf("expected 4 processed items, got %+v", resp)
+				}
+				return
+			}
+			var resp errorResponse
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Code != tt.code {
+				t.Errorf("expected code %s, got %+v", tt.code, resp)
+			}
+			if tt.fail != nil && !strings.Contains(resp.Error, "failed sub-batch starting with 3") {
+				t.Errorf("expected the service error in the response, got %+v", resp)
+			}
+		})
+	}
+}
+
+func TestHandleRequestSyncTimeout(t *testing.T) {
+	client := NewClient(&hangingService{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	req := httptest.NewRequest("POST", "/process-sync", strings.NewReader("[1]"))
+	req.Header.Set(timeoutHeader, "20ms")
+	rr := httptest.NewRecorder()
+	handleRequestSync(client, rr, req)
+
+	if rr.Code != http.StatusGatewayTimeout {
+		t.Errorf("expected %v, got %v: %s", http.StatusGatewayTimeout, rr.Code, rr.Body)
+	}
+}
+
+func TestClientRejectsEmptyBatch(t *testing.T) {
+	client := NewClient(&recordingService{n: 2, p: time.Millisecond})
+	ctx := context.Background()
+
+	submits := map[string]func() error{
+		"Process":           func() error { return client.Process(Batch{}) },
+		"ProcessContext":    func() error { return client.ProcessContext(ctx, nil) },
+		"ProcessBlocking":   func() error { return client.ProcessBlocking(Batch{}) },
+		"ProcessWithResult": func() error { return <-client.ProcessWithResult(Batch{}) },
+		"ProcessAndWait":    func() error { return client.ProcessAndWait(ctx, Batch{}) },
+		"ProcessAll":        func() error { return client.ProcessAll(ctx, Batch{}) },
+		"ProcessWithBatchID": func() error {
+			return client.ProcessWithBatchID("batch", Batch{})
+		},
+		"ProcessWithID": func() error {
+			_, err := client.ProcessWithID(Batch{})
+			return err
+		},
+	}
+	for name, submit := range submits {
+		if err := submit(); !errors.Is(err, ErrEmptyBatch) {
+			t.Errorf("%s: expected %v, got %v", name, ErrEmptyBatch, err)
+		}
+	}
+	if n := client.Stats().QueueLength; n != 0 {
+		t.Errorf("expected nothing queued, got %d batches", n)
+	}
+}
+
+func TestHandleRequestInterval(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval string
+		status   int
+		want     time.Duration
+	}{
+		{name: "none", status: http.StatusOK},
+		{name: "valid", interval: "50ms", status: http.StatusOK, want: time.Millisecond * 50},
+		{name: "invalid", interval: "fast", status: http.StatusBadRequest},
+		{name: "negative", interval: "-1s", status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewClient(&recordingService{n: 2, p: time.Millisecond})
+
+			req := httptest.NewRequest("POST", "/process", strings.NewReader("[1, 2, 3]"))
+			if tt.interval != "" {
+				req.Header.Set(intervalHeader, tt.interval)
+			}
+			rr := httptest.NewRecorder()
+			handleRequest(client, rr, req)
+
+			if rr.Code != tt.status {
+				t.Fatalf("expected %v, got %v: %s", tt.status, rr.Code, rr.Body)
+			}
+			j := client.queue.pop()
+			if tt.status != http.StatusOK {
+				if j != nil || !strings.Contains(rr.Body.String(), "invalid_interval") {
+					t.Errorf("expected the request to be rejected, got %s and %v", rr.Body, j)
+				}
+				return
+			}
+			if j == nil || j.p != tt.want {
+				t.Errorf("expected a batch with interval %v, got %v", tt.want, j)
+			}
+		})
+	}
+}
+
+func TestHandleRequestEnqueueTimeout(t *testing.T) {
+	// Without Run nothing ever makes room in the full queue.
+	client := NewClient(&recordingService{n: 2, p: time.Millisecond},
+		WithQueueCapacity(1),
+		WithBackpressure(BackpressureBlock),
+	)
+	if err := client.Process(make(Batch, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	const timeout = time.Millisecond * 50
+	h := newRequestHandler(client)
+	h.enqueueTimeout = timeout
+
+	start := time.Now()
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST", "/process", strings.NewReader("[1, 2]")))
+	elapsed := time.Since(start)
+
+	if rr.Code != http.StatusServiceUnavailable || !strings.Contains(rr.Body.String(), "enqueue_timeout") {
+		t.Errorf("expected 503 with enqueue_timeout, got %v: %s", rr.Code, rr.Body)
+	}
+	if elapsed < timeout || elapsed > timeout*10 {
+		t.Errorf("expected the handler to give up after %v, took %v", timeout, elapsed)
+	}
+	if n := client.Stats().QueueLength; n != 1 {
+		t.Errorf("expected the batch not to be enqueued, got %d queued batches", n)
+	}
+}
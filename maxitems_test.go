@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientMaxQueuedItems(t *testing.T) {
+	client := NewClient(&recordingService{n: 2, p: time.Millisecond}, WithMaxQueuedItems(10))
+
+	for _, n := range []int{4, 4, 2} {
+		if err := client.Process(make(Batch, n)); err != nil {
+			t.Fatalf("expected a batch of %d items to fit, got %v", n, err)
+		}
+	}
+	if stats := client.Stats(); stats.QueueLength != 3 || stats.QueuedItems != 10 {
+		t.Fatalf("expected 3 batches of 10 items queued, got %+v", stats)
+	}
+	// A single item more goes over the cap.
+	if err := client.Process(make(Batch, 1)); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expected %v, got %v", ErrQueueFull, err)
+	}
+	if err := client.Process(make(Batch, 11)); !errors.Is(err, ErrTooManyItems) {
+		t.Errorf("expected %v for a batch over the cap, got %v", ErrTooManyItems, err)
+	}
+
+	// Dequeuing a batch makes room for its items.
+	j := client.queue.pop()
+	if j == nil {
+		t.Fatal("expected a queued batch")
+	}
+	if err := client.Process(make(Batch, 4)); err != nil {
+		t.Errorf("expected the batch to fit after a dequeue, got %v", err)
+	}
+	if n := client.Stats().QueuedItems; n != 10 {
+		t.Errorf("expected 10 queued items, got %d", n)
+	}
+}
+
+func TestClientMaxQueuedItemsBackpressure(t *testing.T) {
+	t.Run("block", func(t *testing.T) {
+		client := NewClient(&recordingService{n: 2, p: time.Millisecond}, WithMaxQueuedItems(5), WithBackpressure(BackpressureBlock))
+		if err := client.Process(make(Batch, 4)); err != nil {
+			t.Fatal(err)
+		}
+
+		done := make(chan error, 1)
+		go func() {
+			done <- client.Process(make(Batch, 2))
+		}()
+		select {
+		case err := <-done:
+			t.Fatalf("expected the batch to wait for room, got %v", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go client.Run(ctx)
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected the batch to be queued once there is room")
+		}
+	})
+
+	t.Run("drop-oldest", func(t *testing.T) {
+		letters := &deadLetters{}
+		client := NewClient(&recordingService{n: 2, p: time.Millisecond},
+			WithMaxQueuedItems(5),
+			WithBackpressure(BackpressureDropOldest),
+			WithDeadLetter(letters.add),
+		)
+		for _, batch := range []Batch{numberedBatch(0, 2), numberedBatch(2, 2), numberedBatch(4, 3)} {
+			if err := client.Process(batch); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// The oldest batch is dropped to make room for 3 items.
+		batches, _ := letters.recorded()
+		if len(batches) != 1 || batches[0][0].ID != "0" {
+			t.Errorf("expected the oldest batch to be dropped, got %v", batches)
+		}
+		if n := client.Stats().QueuedItems; n != 5 {
+			t.Errorf("expected 5 queued items, got %d", n)
+		}
+	})
+}
+
+func TestHandleRequestMaxQueuedItems(t *testing.T) {
+	client := NewClient(&recordingService{n: 2, p: time.Millisecond}, WithMaxQueuedItems(2))
+
+	rr := httptest.NewRecorder()
+	handleRequest(client, rr, httptest.NewRequest("POST", "/process", strings.NewReader("[1, 2, 3]")))
+	if rr.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rr.Body.String(), "too_many_items") {
+		t.Errorf("expected %d too_many_items, got %d %s", http.StatusRequestEntityTooLarge, rr.Code, rr.Body)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import "time"
+
+// Metrics receives measurements of the client, e.g. to export them
+// to Prometheus. Implementations must be safe for concurrent use.
+type Metrics interface {
+	// BatchEnqueued is called for every batch accepted by the queue with
+	// its number of items, e.g. to feed a histogram of batch sizes.
+	BatchEnqueued(items int)
+	// SubBatchProcessed is called after every Process call to the service
+	// with the number of items, the call latency and its error.
+	SubBatchProcessed(items int, latency time.Duration, err error)
+	// RateLimitWaited is called after every wait for the rate limiter.
+	RateLimitWaited(d time.Duration)
+}
+
+// WithMetrics sets the metrics the client reports to.
+func WithMetrics(metrics Metrics) Option {
+	return func(c *Client) {
+		c.metrics = metrics
+	}
+}
+
+// noopMetrics discards all measurements.
+type noopMetrics struct{}
+
+func (noopMetrics) BatchEnqueued(int)                           {}
+func (noopMetrics) SubBatchProcessed(int, time.Duration, error) {}
+func (noopMetrics) RateLimitWaited(time.Duration)               {}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMetrics accumulates reported measurements.
+type fakeMetrics struct {
+	mu              sync.Mutex
+	batches         int
+	enqueuedItems   int
+	subBatches      int
+	processedItems  int
+	errors          int
+	latencies       []time.Duration
+	rateLimitWaits  int
+	rateLimitWaited time.Duration
+}
+
+func (m *fakeMetrics) BatchEnqueued(items int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.batches++
+	m.enqueuedItems += items
+}
+
+func (m *fakeMetrics) SubBatchProcessed(items int, latency time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.subBatches++
+	m.processedItems += items
+	m.latencies = append(m.latencies, latency)
+	if err != nil {
+		m.errors++
+	}
+}
+
+func (m *fakeMetrics) RateLimitWaited(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rateLimitWaits++
+	m.rateLimitWaited += d
+}
+
+func TestClientMetrics(t *testing.T) {
+	const p = time.Millisecond * 10
+
+	metrics := &fakeMetrics{}
+	service := &flakyService{n: 2, p: p, failures: 1}
+	client := NewClient(service, WithMetrics(metrics))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	receiveResult(t, client.ProcessWithResult(make(Batch, 5)))
+
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	if metrics.batches != 1 || metrics.enqueuedItems != 5 {
+		t.Errorf("expected 1 batch of 5 items enqueued, got %d batches of %d items", metrics.batches, metrics.enqueuedItems)
+	}
+	if metrics.subBatches != 3 || metrics.processedItems != 5 {
+		t.Errorf("expected 3 sub-batches of 5 items processed, got %d sub-batches of %d items", metrics.subBatches, metrics.processedItems)
+	}
+	if metrics.errors != 1 {
+		t.Errorf("expected 1 error, got %d", metrics.errors)
+	}
+	if len(metrics.latencies) != 3 {
+		t.Errorf("expected 3 latencies, got %d", len(metrics.latencies))
+	}
+	if metrics.rateLimitWaits != 3 {
+		t.Errorf("expected 3 rate limiter waits, got %d", metrics.rateLimitWaits)
+	}
+	// The first sub-batch goes out immediately and the other two wait for p.
+	if metrics.rateLimitWaited < p {
+		t.Errorf("expected rate limiter wait of at least %v, got %v", p, metrics.rateLimitWaited)
+	}
+}
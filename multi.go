@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// NewMultiClient creates a client spreading sub-batches across several
+// instances of the same external service in turn. Every instance keeps its
+// own limits: a sub-batch has at most the smallest n of all instances,
+// unless WithChunkSize says otherwise, and no instance is called more often
+// than its own p allows.
+// It panics if services is empty.
+func NewMultiClient(services []Service, opts ...Option) *Client {
+	if len(services) == 0 {
+		panic("NewMultiClient: no services")
+	}
+
+	m := &multiService{
+		services: services,
+		limiters: make([]*limiter, len(services)),
+	}
+	for i := range services {
+		// GetLimits sets the intervals, NewClient calls it right away.
+		m.limiters[i] = newLimiter(0)
+	}
+	return NewClient(m, opts...)
+}
+
+// WithChunkSize makes the client split batches into sub-batches of at most
+// n items. For a single service it only lowers the service n. For a
+// multi-client it replaces the smallest n of all instances, so that larger
+// instances can be used fully, and it is up to the caller to pick a size
+// every instance accepts. Zero keeps the service n.
+func WithChunkSize(n uint64) Option {
+	return func(c *Client) {
+		c.chunk = n
+	}
+}
+
+// chunkSize returns the sub-batch size given the service n.
+func (c *Client) chunkSize(n uint64) uint64 {
+	if c.chunk == 0 {
+		return n
+	}
+	if _, multi := c.service.(*multiService); multi || c.chunk < n {
+		return c.chunk
+	}
+	return n
+}
+
+// multiService is a Service calling its services in round-robin order.
+type multiService struct {
+	services []Service
+	limiters []*limiter
+	next     atomic.Uint64
+}
+
+// GetLimits returns the smallest n of all services and the interval that
+// keeps every service within its limits when they are called in turn, i.e.
+// the longest p divided by the number of services. It also updates the
+// per-service limiters, so refreshed limits apply to them too.
+func (m *multiService) GetLimits() (uint64, time.Duration) {
+	var n uint64
+	var p time.Duration
+	for i, s := range m.services {
+		sn, sp := s.GetLimits()
+		if sn > 0 && sp > 0 {
+			m.limiters[i].setInterval(sp)
+		}
+		if i == 0 || sn < n {
+			n = sn
+		}
+		if sp > p {
+			p = sp
+		}
+	}
+	return n, p / time.Duration(len(m.services))
+}
+
+// Process passes batch to the next service once its own limiter allows.
+func (m *multiService) Process(ctx context.Context, batch Batch) error {
+	s, err := m.nextService(ctx)
+	if err != nil {
+		return err
+	}
+	return s.Process(ctx, batch)
+}
+
+// ProcessItems is Process for services that are PartialService. Other
+// services either process all items or fail the whole batch.
+func (m *multiService) ProcessItems(ctx context.Context, batch Batch) ([]ItemResult, error) {
+	s, err := m.nextService(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if ps, ok := s.(PartialService); ok {
+		return ps.ProcessItems(ctx, batch)
+	}
+	if err := s.Process(ctx, batch); err != nil {
+		return nil, err
+	}
+	return make([]ItemResult, len(batch)), nil
+}
+
+// nextService returns the next service in turn once its own limiter allows.
+func (m *multiService) nextService(ctx context.Context) (Service, error) {
+	i := (m.next.Add(1) - 1) % uint64(len(m.services))
+	if err := m.limiters[i].Wait(ctx); err != nil {
+		return nil, err
+	}
+	return m.services[i], nil
+}
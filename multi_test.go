@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMultiClient(t *testing.T) {
+	first := &recordingService{n: 2, p: time.Millisecond * 10}
+	second := &recordingService{n: 3, p: time.Millisecond * 10}
+	client := NewMultiClient([]Service{first, second})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	receiveResult(t, client.ProcessWithResult(numberedBatch(0, 8)))
+
+	firstCalls, secondCalls := first.recorded(), second.recorded()
+	if len(firstCalls) != 2 || len(secondCalls) != 2 {
+		t.Fatalf("expected 2 calls per service, got %d and %d", len(firstCalls), len(secondCalls))
+	}
+
+	// Sub-batches of the smallest n alternate between the services.
+	want := []struct {
+		calls []call
+		first string
+	}{
+		{firstCalls, "0"}, {secondCalls, "2"}, {firstCalls, "4"}, {secondCalls, "6"},
+	}
+	for i, w := range want {
+		c := w.calls[i/2]
+		if len(c.batch) != 2 || c.batch[0].ID != w.first {
+			t.Errorf("sub-batch %d: expected 2 items from %s, got %v", i, w.first, c.batch)
+		}
+	}
+
+	for _, calls := range [][]call{firstCalls, secondCalls} {
+		if gap := calls[1].at.Sub(calls[0].at); gap < time.Millisecond*10 {
+			t.Errorf("expected calls to a service at least 10ms apart, got %v", gap)
+		}
+	}
+}
+
+func TestMultiClientChunkSize(t *testing.T) {
+	first := &recordingService{n: 2, p: time.Millisecond}
+	second := &recordingService{n: 3, p: time.Millisecond}
+	client := NewMultiClient([]Service{first, second}, WithChunkSize(3))
+
+	if err := client.ProcessAll(context.Background(), numberedBatch(0, 6)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, calls := range [][]call{first.recorded(), second.recorded()} {
+		if len(calls) != 1 || len(calls[0].batch) != 3 {
+			t.Errorf("expected a single sub-batch of 3 items, got %v", calls)
+		}
+	}
+}
+
+func TestClientChunkSize(t *testing.T) {
+	tests := []struct {
+		chunk uint64
+		want  int
+	}{
+		{chunk: 0, want: 3},
+		{chunk: 2, want: 2},
+		// Single-service clients never exceed the service n.
+		{chunk: 5, want: 3},
+	}
+
+	for _, tt := range tests {
+		service := &recordingService{n: 3, p: time.Millisecond}
+		client := NewClient(service, WithChunkSize(tt.chunk))
+
+		if err := client.ProcessAll(context.Background(), numberedBatch(0, 6)); err != nil {
+			t.Fatal(err)
+		}
+		if calls := service.recorded(); len(calls[0].batch) != tt.want {
+			t.Errorf("chunk %d: expected sub-batches of %d items, got %d", tt.chunk, tt.want, len(calls[0].batch))
+		}
+	}
+}
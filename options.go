@@ -0,0 +1,102 @@
+package main
+
+import "time"
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithQueueCapacity sets the number of batches the client queue can hold.
+//
+// Queued batches are kept in memory together with all their items, so the
+// capacity bounds the number of batches rather than the memory used: the
+// worst case is capacity times the size of the largest accepted batch.
+// A capacity of zero or less makes the queue unbuffered, so Process only
+// succeeds while Run is ready to receive.
+func WithQueueCapacity(capacity int) Option {
+	return func(c *Client) {
+		if capacity < 0 {
+			capacity = 0
+		}
+		c.capacity = capacity
+	}
+}
+
+// WithMaxQueuedItems caps the number of items of all the batches in the
+// queue at n on top of the queue capacity, so that a few huge batches can't
+// exhaust memory. A batch that would take the queue over it doesn't fit,
+// as if the queue was full, and is subject to the backpressure policy.
+// A batch of more than n items never fits and fails with ErrTooManyItems.
+// Zero or less means no cap, which is the default.
+func WithMaxQueuedItems(n int) Option {
+	return func(c *Client) {
+		if n < 0 {
+			n = 0
+		}
+		c.maxQueuedItems = n
+	}
+}
+
+// WithRetryPolicy sets the policy used to retry failed sub-batches.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *Client) {
+		c.retry = policy
+	}
+}
+
+// WithProcessTimeout limits the duration of every Process call to the service.
+// A call that takes longer is cancelled and treated as failed, so it is
+// retried according to the retry policy. Zero means no limit.
+func WithProcessTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// WithLogger sets the logger the client reports to.
+func WithLogger(logger Logger) Option {
+	return func(c *Client) {
+		c.logger = logger
+	}
+}
+
+// WithDeadLetter sets a hook called with every sub-batch that failed
+// terminally, i.e. once all retries are exhausted, and its last error.
+// It also gets the items of a batch left unprocessed when the batch
+// stopped early, with an error wrapping ErrUnprocessed.
+// Calls of the hook are serialized, so it needs no synchronization
+// of its own, but it should return quickly as it holds up processing.
+func WithDeadLetter(fn func(batch Batch, err error)) Option {
+	return func(c *Client) {
+		c.deadLetter = fn
+	}
+}
+
+// WithBurst lets up to burst sub-batches go to the service at once after
+// an idle period, then throttles them to the steady rate of one per p.
+// Use it with services whose limits are enforced over a window. Values less
+// than 1 mean no bursts, which is the default.
+func WithBurst(burst int) Option {
+	return func(c *Client) {
+		c.limiter.setBurst(burst)
+	}
+}
+
+// WithJitter spreads out the calls of clients sharing a service by making
+// every wait between sub-batches longer by a random fraction of p, up to
+// jitter, e.g. 0.1 for up to 10%. It only ever delays calls, so the rate
+// stays within the service limits. Zero or less means no jitter.
+func WithJitter(jitter float64) Option {
+	return func(c *Client) {
+		c.limiter.setJitter(jitter)
+	}
+}
+
+// WithWaitFirst makes the client wait for p before its first call to the
+// service too, for services enforcing their limits from the moment the
+// client starts. By default the first sub-batch goes out immediately and
+// only the calls after it wait.
+func WithWaitFirst(waitFirst bool) Option {
+	return func(c *Client) {
+		c.limiter.setWaitFirst(waitFirst)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewClientDefaults(t *testing.T) {
+	client := NewClient(&recordingService{n: 2, p: time.Millisecond})
+
+	if got := client.queue.capacity; got != defaultQueueCapacity {
+		t.Errorf("expected queue capacity %d, got %d", defaultQueueCapacity, got)
+	}
+	if client.retry != (RetryPolicy{}) {
+		t.Errorf("expected no retry policy, got %+v", client.retry)
+	}
+	if client.timeout != 0 {
+		t.Errorf("expected no process timeout, got %v", client.timeout)
+	}
+	if client.logger != NewStdLogger(log.Default()) {
+		t.Error("expected the standard logger")
+	}
+}
+
+func TestWithQueueCapacity(t *testing.T) {
+	for _, client := range []*Client{
+		NewClient(&recordingService{n: 2, p: time.Millisecond}, WithQueueCapacity(2)),
+		NewClientWithCapacity(&recordingService{n: 2, p: time.Millisecond}, 2),
+	} {
+		for i := 0; i < 2; i++ {
+			if err := client.Process(make(Batch, 1)); err != nil {
+				t.Fatalf("batch %d: unexpected error: %v", i, err)
+			}
+		}
+		if err := client.Process(make(Batch, 1)); !errors.Is(err, ErrQueueFull) {
+			t.Fatalf("expected %v, got %v", ErrQueueFull, err)
+		}
+	}
+}
+
+func TestWithRetryPolicy(t *testing.T) {
+	service := &flakyService{n: 1, p: time.Millisecond, failures: 1}
+	client := NewClient(service, WithRetryPolicy(RetryPolicy{MaxAttempts: 2}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	if err := receiveResult(t, client.ProcessWithResult(make(Batch, 1))); err != nil {
+		t.Fatalf("expected the retry to succeed, got %v", err)
+	}
+}
+
+func TestWithProcessTimeout(t *testing.T) {
+	client := NewClient(&hangingService{}, WithProcessTimeout(time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	err := receiveResult(t, client.ProcessWithResult(make(Batch, 1)))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestWithLogger(t *testing.T) {
+	var buf bytes.Buffer
+	service := &flakyService{n: 1, p: time.Millisecond, failures: 1}
+	client := NewClient(service, WithLogger(NewStdLogger(log.New(&buf, "", 0))))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	receiveResult(t, client.ProcessWithResult(make(Batch, 1)))
+
+	if !strings.Contains(buf.String(), "temporary failure") {
+		t.Errorf("expected the error to be logged, got %q", buf.String())
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import "sort"
+
+// SubBatchOrder reorders the items of a batch in place before the batch is
+// split into sub-batches, deciding which items reach the service first.
+type SubBatchOrder func(batch Batch)
+
+// OrderFIFO keeps the items in the order they were submitted, the default.
+func OrderFIFO(batch Batch) {}
+
+// OrderLIFO sends the last items of a batch first.
+func OrderLIFO(batch Batch) {
+	for i, j := 0, len(batch)-1; i < j; i, j = i+1, j-1 {
+		batch[i], batch[j] = batch[j], batch[i]
+	}
+}
+
+// OrderBy sorts the items of a batch by less, keeping the items that are
+// equal in the order they were submitted.
+func OrderBy(less func(a, b Item) bool) SubBatchOrder {
+	return func(batch Batch) {
+		sort.SliceStable(batch, func(i, j int) bool {
+			return less(batch[i], batch[j])
+		})
+	}
+}
+
+// WithSubBatchOrder makes the client reorder the items of every batch by
+// order before splitting it. The batch is reordered on a copy, so the slice
+// of the caller is left as it is. The offsets of the sub-batches, e.g. in
+// ProgressEvent, are those of the reordered batch.
+func WithSubBatchOrder(order SubBatchOrder) Option {
+	return func(c *Client) {
+		c.order = order
+	}
+}
+
+// reorder returns batch in the client order.
+func (c *Client) reorder(batch Batch) Batch {
+	if c.order == nil {
+		return batch
+	}
+	batch = append(Batch(nil), batch...)
+	c.order(batch)
+	return batch
+}
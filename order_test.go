@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestWithSubBatchOrder(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		order    SubBatchOrder
+		expected string
+	}{
+		{"fifo", OrderFIFO, "[[0 1] [2 3] [4]]"},
+		{"lifo", OrderLIFO, "[[4 3] [2 1] [0]]"},
+		// Odd items first, by their ID.
+		{"custom", OrderBy(func(a, b Item) bool { return a.ID[0]%2 > b.ID[0]%2 }), "[[1 3] [0 2] [4]]"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			service := &recordingService{n: 2, p: time.Millisecond}
+			client := NewClient(service, WithSubBatchOrder(tc.order))
+
+			batch := numberedBatch(0, 5)
+			if err := client.ProcessAll(context.Background(), batch); err != nil {
+				t.Fatal(err)
+			}
+
+			var got [][]string
+			for _, call := range service.recorded() {
+				got = append(got, ids(call.batch))
+			}
+			if fmt.Sprint(got) != tc.expected {
+				t.Errorf("expected sub-batches %s, got %v", tc.expected, got)
+			}
+			if fmt.Sprint(ids(batch)) != "[0 1 2 3 4]" {
+				t.Errorf("expected the batch of the caller to be left as it is, got %v", ids(batch))
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+)
+
+// ErrPanic reports if the service or a hook of the client panicked.
+// The client survives it, failing the sub-batch or the batch involved.
+var ErrPanic = errors.New("panic")
+
+// recoverPanic, when deferred, recovers from a panic in what and stores an
+// error wrapping ErrPanic with the panic value in *err, logging the stack
+// trace with logger.
+func recoverPanic(logger Logger, what string, err *error) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	logger.Errorf("Recovered from panic in %s: %v\n%s", what, v, debug.Stack())
+	*err = fmt.Errorf("%w in %s: %v", ErrPanic, what, v)
+}
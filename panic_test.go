@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// panickingService panics on the sub-batches starting with the given item.
+type panickingService struct {
+	recordingService
+	panicOn string
+}
+
+func (s *panickingService) Process(ctx context.Context, batch Batch) error {
+	if batch[0].ID == s.panicOn {
+		panic("bad item " + s.panicOn)
+	}
+	return s.recordingService.Process(ctx, batch)
+}
+
+func TestClientServicePanic(t *testing.T) {
+	logger := &fakeLogger{}
+	letters := &deadLetters{}
+	service := &panickingService{recordingService: recordingService{n: 2, p: time.Millisecond}, panicOn: "2"}
+	client := NewClient(service,
+		WithLogger(logger),
+		WithDeadLetter(letters.add),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	err := receiveResult(t, client.ProcessWithResult(numberedBatch(0, 6)))
+	if !errors.Is(err, ErrPanic) || !strings.Contains(err.Error(), "bad item 2") {
+		t.Fatalf("expected the panic as an error, got %v", err)
+	}
+	// The other sub-batches are processed and the client keeps working.
+	if calls := len(service.recorded()); calls != 2 {
+		t.Errorf("expected the other 2 sub-batches to be processed, got %d calls", calls)
+	}
+	if err := receiveResult(t, client.ProcessWithResult(numberedBatch(10, 2))); err != nil {
+		t.Errorf("expected the client to survive the panic, got %v", err)
+	}
+
+	if batches, _ := letters.recorded(); len(batches) != 1 || batches[0][0].ID != "2" {
+		t.Errorf("expected the panicking sub-batch to be dead-lettered, got %v", batches)
+	}
+	if stats := client.Stats(); stats.TotalRetries != 1 {
+		t.Errorf("expected the panicking sub-batch to be retried once, got %d retries", stats.TotalRetries)
+	}
+	var stacks int
+	for _, e := range logger.logged("ERROR") {
+		if strings.HasPrefix(e.format, "Recovered from panic") && strings.Contains(e.String(), "panic_test.go") {
+			stacks++
+		}
+	}
+	if stacks != 2 {
+		t.Errorf("expected a stack trace per attempt, got %d", stacks)
+	}
+}
+
+func TestClientHookPanic(t *testing.T) {
+	logger := &fakeLogger{}
+	service := &failingService{
+		recordingService: recordingService{n: 2, p: time.Millisecond},
+		fail:             map[string]bool{"0": true},
+	}
+	client := NewClient(service,
+		WithLogger(logger),
+		WithDeadLetter(func(Batch, error) { panic("dead letter hook") }),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	err := receiveResult(t, client.ProcessWithResult(numberedBatch(0, 4)))
+	if !errors.Is(err, ErrPanic) {
+		t.Fatalf("expected %v, got %v", ErrPanic, err)
+	}
+	if calls := len(service.recorded()); calls != 2 {
+		t.Errorf("expected the batch to go on after the panic, got %d calls", calls)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// errMissingResult reports an item PartialService returned no result for.
+var errMissingResult = errors.New("no result for item")
+
+// ItemResult is the outcome of processing a single item.
+type ItemResult struct {
+	// Err is nil if the item was processed.
+	Err error
+}
+
+// PartialService is a Service that can succeed for some items of a batch
+// and fail for others. The client calls ProcessItems instead of Process,
+// so that only the failed items are retried and sent to the dead-letter hook.
+type PartialService interface {
+	Service
+	// ProcessItems processes batch and returns a result for every item in
+	// the same order. A non-nil error means the whole batch failed.
+	ProcessItems(ctx context.Context, batch Batch) ([]ItemResult, error)
+}
+
+// PartialError reports the items of a sub-batch the service failed to
+// process while it processed the rest.
+type PartialError struct {
+	// Items are the failed items.
+	Items Batch
+	// Errs are the errors of Items, one per item.
+	Errs []error
+	// offsets are the positions of Items in the batch of the call,
+	// if known.
+	offsets []int
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("%d items failed, first: %v", len(e.Items), e.Errs[0])
+}
+
+// Unwrap returns the item errors.
+func (e *PartialError) Unwrap() []error {
+	return e.Errs
+}
+
+// failedItems returns the items of batch that failed according to results
+// as a PartialError, or nil if all of them succeeded. Items without
+// a result count as failed.
+func failedItems(batch Batch, results []ItemResult) error {
+	var perr PartialError
+	for i, item := range batch {
+		err := errMissingResult
+		if i < len(results) {
+			err = results[i].Err
+		}
+		if err != nil {
+			perr.Items = append(perr.Items, item)
+			perr.Errs = append(perr.Errs, err)
+			perr.offsets = append(perr.offsets, i)
+		}
+	}
+	if len(perr.Items) == 0 {
+		return nil
+	}
+	return &perr
+}
+
+// processedItems returns the number of items of batch the service
+// processed in a call that failed with err: all of them if err is nil,
+// those not in the PartialError if it is one, none otherwise.
+func processedItems(batch Batch, err error) int {
+	if err == nil {
+		return len(batch)
+	}
+	var perr *PartialError
+	if errors.As(err, &perr) {
+		return len(batch) - len(perr.Items)
+	}
+	return 0
+}
+
+// narrow returns the positions of the failed items in the sub-batch, given
+// batch, the items of the call, at offsets in the sub-batch, or in order
+// from 0 if offsets is nil. Without the positions of a PartialError made by
+// the service itself, the failed items are looked up in batch by ID.
+func (e *PartialError) narrow(batch Batch, offsets []int) []int {
+	positions := e.offsets
+	if len(positions) != len(e.Items) {
+		positions = make([]int, 0, len(e.Items))
+		for i, item := range batch {
+			if len(positions) < len(e.Items) && item.ID == e.Items[len(positions)].ID {
+				positions = append(positions, i)
+			}
+		}
+	}
+	narrowed := make([]int, len(positions))
+	for i, pos := range positions {
+		narrowed[i] = pos
+		if offsets != nil {
+			narrowed[i] = offsets[pos]
+		}
+	}
+	return narrowed
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// alternatingService fails every other item by ID until the item has
+// failed failures times.
+type alternatingService struct {
+	recordingService
+	failures int
+
+	failed map[string]int
+}
+
+func (s *alternatingService) ProcessItems(ctx context.Context, batch Batch) ([]ItemResult, error) {
+	s.recordingService.Process(ctx, batch)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	results := make([]ItemResult, len(batch))
+	for i, item := range batch {
+		id, _ := strconv.Atoi(item.ID)
+		if id%2 == 1 && s.failed[item.ID] < s.failures {
+			s.failed[item.ID]++
+			results[i].Err = fmt.Errorf("item %s failed", item.ID)
+		}
+	}
+	return results, nil
+}
+
+func ids(batch Batch) []string {
+	ids := make([]string, len(batch))
+	for i, item := range batch {
+		ids[i] = item.ID
+	}
+	return ids
+}
+
+func TestClientPartialRetry(t *testing.T) {
+	service := &alternatingService{
+		recordingService: recordingService{n: 4, p: time.Millisecond},
+		failures:         1,
+		failed:           make(map[string]int),
+	}
+	client := NewClient(service, WithRetryPolicy(RetryPolicy{MaxAttempts: 2}))
+
+	if err := client.ProcessAll(context.Background(), numberedBatch(0, 4)); err != nil {
+		t.Fatalf("expected the retry to succeed, got %v", err)
+	}
+
+	calls := service.recorded()
+	if len(calls) != 2 {
+		t.Fatalf("expected 2 calls, got %d", len(calls))
+	}
+	if got := fmt.Sprint(ids(calls[1].batch)); got != "[1 3]" {
+		t.Errorf("expected only the failed items to be retried, got %v", got)
+	}
+}
+
+func TestClientPartialDeadLetter(t *testing.T) {
+	service := &alternatingService{
+		recordingService: recordingService{n: 4, p: time.Millisecond},
+		failures:         2,
+		failed:           make(map[string]int),
+	}
+
+	var mu sync.Mutex
+	var letters []Batch
+	client := NewClient(service,
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 2}),
+		WithDeadLetter(func(batch Batch, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			letters = append(letters, batch)
+		}),
+	)
+
+	err := client.ProcessAll(context.Background(), numberedBatch(0, 4))
+	var perr *PartialError
+	if !errors.As(err, &perr) {
+		t.Fatalf("expected a PartialError, got %v", err)
+	}
+	if got := fmt.Sprint(ids(perr.Items)); got != "[1 3]" || len(perr.Errs) != 2 {
+		t.Errorf("expected items 1 and 3 to fail, got %v", got)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(letters) != 1 || fmt.Sprint(ids(letters[0])) != "[1 3]" {
+		t.Errorf("expected only the failed items in the dead letter, got %v", letters)
+	}
+}
+
+func TestFailedItemsMissingResult(t *testing.T) {
+	err := failedItems(numberedBatch(0, 3), []ItemResult{{}})
+	var perr *PartialError
+	if !errors.As(err, &perr) || fmt.Sprint(ids(perr.Items)) != "[1 2]" {
+		t.Errorf("expected items without a result to fail, got %v", err)
+	}
+	if !errors.Is(err, errMissingResult) {
+		t.Errorf("expected %v, got %v", errMissingResult, err)
+	}
+}
+
+// blockedItemService fails item 1 with ErrBlocked on the first call.
+type blockedItemService struct {
+	recordingService
+}
+
+func (s *blockedItemService) ProcessItems(ctx context.Context, batch Batch) ([]ItemResult, error) {
+	first := len(s.recorded()) == 0
+	s.recordingService.Process(ctx, batch)
+
+	results := make([]ItemResult, len(batch))
+	for i, item := range batch {
+		if first && item.ID == "1" {
+			results[i].Err = ErrBlocked
+		}
+	}
+	return results, nil
+}
+
+func TestClientPartialBlocked(t *testing.T) {
+	service := &blockedItemService{recordingService{n: 4, p: time.Millisecond}}
+	client := NewClient(service, WithBlockedCooldown(time.Millisecond))
+
+	if err := client.ProcessAll(context.Background(), numberedBatch(0, 4)); err != nil {
+		t.Fatalf("expected the probe to succeed, got %v", err)
+	}
+
+	seen := make(map[string]int)
+	for _, call := range service.recorded() {
+		for _, item := range call.batch {
+			seen[item.ID]++
+		}
+	}
+	if fmt.Sprint(seen) != "map[0:1 1:2 2:1 3:1]" {
+		t.Errorf("expected only the blocked item to be sent again, got %v", seen)
+	}
+}
+
+func TestClientPartialStats(t *testing.T) {
+	service := &alternatingService{
+		recordingService: recordingService{n: 4, p: time.Millisecond},
+		failures:         1,
+		failed:           make(map[string]int),
+	}
+	client := NewClient(service)
+
+	if err := client.ProcessAll(context.Background(), numberedBatch(0, 4)); err == nil {
+		t.Fatal("expected items 1 and 3 to fail")
+	}
+
+	// The items the service processed count even though the call failed.
+	stats := client.Stats()
+	if stats.TotalProcessed != 2 || stats.TotalErrors != 1 {
+		t.Errorf("expected 2 processed items and 1 error, got %d and %d", stats.TotalProcessed, stats.TotalErrors)
+	}
+	if stats.LastProcessTime.IsZero() {
+		t.Error("expected the last process time to be set")
+	}
+	if stats.Throughput.ItemsPerSecond == 0 {
+		t.Errorf("expected the processed items in the throughput, got %+v", stats.Throughput)
+	}
+}
+
+func TestPartialErrorNarrow(t *testing.T) {
+	batch := numberedBatch(0, 4)
+	made := failedItems(batch, []ItemResult{{}, {Err: ErrBlocked}, {}, {Err: ErrBlocked}}).(*PartialError)
+	// A PartialError of the service itself has no positions.
+	own := &PartialError{Items: Batch{batch[1], batch[3]}, Errs: []error{ErrBlocked, ErrBlocked}}
+	for _, perr := range []*PartialError{made, own} {
+		if got := fmt.Sprint(perr.narrow(batch, nil)); got != "[1 3]" {
+			t.Errorf("expected positions [1 3], got %v", got)
+		}
+		// The positions are those in the sub-batch the batch was
+		// narrowed down from.
+		if got := fmt.Sprint(perr.narrow(batch, []int{2, 4, 5, 7})); got != "[4 7]" {
+			t.Errorf("expected positions [4 7], got %v", got)
+		}
+	}
+}
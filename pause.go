@@ -0,0 +1,47 @@
+package main
+
+import "net/http"
+
+// Pause stops the client from calling the service until Resume. Run stops
+// dequeuing, so new batches pile up in the queue, and batches in flight
+// hold before their next Process call.
+func (c *Client) Pause() {
+	if c.paused.block() {
+		c.logger.Infof("Processing paused")
+	}
+}
+
+// Resume lets the client call the service again after Pause.
+// Processing continues where it stopped.
+func (c *Client) Resume() {
+	if c.paused.unblock() {
+		c.logger.Infof("Processing resumed")
+	}
+}
+
+// Paused reports whether the client is paused.
+func (c *Client) Paused() bool {
+	return c.paused.opened() != nil
+}
+
+// handlePause pauses client on POST requests.
+func handlePause(client *Client, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	client.Pause()
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleResume resumes client on POST requests.
+func handleResume(client *Client, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	client.Resume()
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientPause(t *testing.T) {
+	service := &recordingService{n: 2, p: time.Millisecond}
+	client := NewClient(service)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	client.Pause()
+	if !client.Stats().Paused {
+		t.Error("expected the stats to report the client paused")
+	}
+	for i := 0; i < 2; i++ {
+		if err := client.Process(numberedBatch(i*2, 2)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	time.Sleep(time.Millisecond * 50)
+	if calls := len(service.recorded()); calls != 0 {
+		t.Fatalf("expected no calls while paused, got %d", calls)
+	}
+	if got := client.Stats().QueueLength; got != 2 {
+		t.Errorf("expected 2 batches to wait in the queue, got %d", got)
+	}
+
+	client.Resume()
+	if client.Stats().Paused {
+		t.Error("expected the stats to report the client resumed")
+	}
+	waitCalls(t, service, 2, time.Second)
+}
+
+func TestClientPauseInFlight(t *testing.T) {
+	service := newSlowService()
+	client := NewClient(service)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	// The first sub-batch is being processed when the client is paused.
+	if err := client.Process(make(Batch, 20)); err != nil {
+		t.Fatal(err)
+	}
+	<-service.started
+	client.Pause()
+	close(service.release)
+
+	time.Sleep(time.Millisecond * 50)
+	if calls := len(service.recorded()); calls != 1 {
+		t.Fatalf("expected the batch to hold after its first sub-batch, got %d calls", calls)
+	}
+
+	client.Resume()
+	waitCalls(t, &service.recordingService, 2, time.Second)
+}
+
+func TestHandlePauseResume(t *testing.T) {
+	client := NewClient(&testService{n: 2, p: time.Millisecond})
+
+	rr := httptest.NewRecorder()
+	handlePause(client, rr, httptest.NewRequest("GET", "/pause", nil))
+	if rr.Code != http.StatusMethodNotAllowed || client.Paused() {
+		t.Errorf("expected GET to be rejected, got %v", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handlePause(client, rr, httptest.NewRequest("POST", "/pause", nil))
+	if rr.Code != http.StatusOK || !client.Paused() {
+		t.Errorf("expected the client to be paused, got %v", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handleResume(client, rr, httptest.NewRequest("POST", "/resume", nil))
+	if rr.Code != http.StatusOK || client.Paused() {
+		t.Errorf("expected the client to be resumed, got %v", rr.Code)
+	}
+}
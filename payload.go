@@ -0,0 +1,41 @@
+package main
+
+// PayloadLimiter is implemented by services rejecting Process calls whose
+// payloads add up to more than a number of bytes, however few items they
+// have. The client cuts sub-batches to keep within it on top of the n
+// items limit; an item larger than the limit goes to the service alone.
+type PayloadLimiter interface {
+	// MaxPayloadBytes returns the largest total size of the item payloads
+	// of a call in bytes. Zero or less means no limit. It is read for
+	// every sub-batch, so it may change over time.
+	MaxPayloadBytes() int
+}
+
+// Size returns the size of the item payload in bytes.
+func (item Item) Size() int {
+	return len(item.Payload)
+}
+
+// cutByPayload returns where the sub-batch of batch starting at start and
+// ending at end at the latest has to end to keep within the payload limit
+// of the service, if it is a PayloadLimiter. The sub-batch has at least
+// one item.
+func (c *Client) cutByPayload(batch Batch, start, end uint64) uint64 {
+	limiter, ok := c.service.(PayloadLimiter)
+	if !ok {
+		return end
+	}
+	max := limiter.MaxPayloadBytes()
+	if max <= 0 {
+		return end
+	}
+
+	total := batch[start].Size()
+	for i := start + 1; i < end; i++ {
+		total += batch[i].Size()
+		if total > max {
+			return i
+		}
+	}
+	return end
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// payloadService is a recordingService limiting the payload of its calls.
+type payloadService struct {
+	recordingService
+	max int
+}
+
+func (s *payloadService) MaxPayloadBytes() int {
+	return s.max
+}
+
+func TestClientMaxPayloadBytes(t *testing.T) {
+	service := &payloadService{recordingService: recordingService{n: 4, p: time.Millisecond}, max: 100}
+	client := NewClient(service)
+
+	sizes := []int{40, 40, 40, 10, 10, 150, 5, 5, 5, 5, 5}
+	batch := make(Batch, len(sizes))
+	for i, size := range sizes {
+		batch[i].Payload = []byte(strings.Repeat("x", size))
+	}
+	if err := client.ProcessAll(context.Background(), batch); err != nil {
+		t.Fatal(err)
+	}
+
+	var got [][]int
+	for _, c := range service.recorded() {
+		var sizes []int
+		for _, item := range c.batch {
+			sizes = append(sizes, item.Size())
+		}
+		got = append(got, sizes)
+	}
+	// The byte limit cuts the sub-batches before n does, but for the last
+	// ones, and a payload over the limit goes alone.
+	want := [][]int{{40, 40}, {40, 10, 10}, {150}, {5, 5, 5, 5}, {5}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected sub-batches of payloads %v, got %v", want, got)
+	}
+}
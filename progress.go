@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"golang.org/x/net/websocket"
+)
+
+// ProgressType is the kind of a ProgressEvent.
+type ProgressType string
+
+const (
+	// ProgressSubBatchStarted is sent before a sub-batch is first passed
+	// to the service.
+	ProgressSubBatchStarted ProgressType = "sub_batch_started"
+	// ProgressSubBatchSucceeded is sent once a sub-batch is processed.
+	ProgressSubBatchSucceeded ProgressType = "sub_batch_succeeded"
+	// ProgressSubBatchFailed is sent once a sub-batch fails terminally.
+	ProgressSubBatchFailed ProgressType = "sub_batch_failed"
+	// ProgressBatchComplete is the last event of a batch.
+	ProgressBatchComplete ProgressType = "batch_complete"
+)
+
+// ProgressEvent reports the progress of a batch sent to /ws.
+type ProgressEvent struct {
+	Type ProgressType `json:"type"`
+	// SubBatch is the zero-based index of the sub-batch of the event,
+	// Start and End are the offsets of its first item and past its last.
+	// They are zero for ProgressBatchComplete.
+	SubBatch int    `json:"sub_batch"`
+	Start    uint64 `json:"start"`
+	End      uint64 `json:"end"`
+	// Items is the number of items of the sub-batch, or of the batch
+	// for ProgressBatchComplete.
+	Items int `json:"items"`
+	// Error is the failure of the sub-batch or of the batch, if any.
+	Error string `json:"error,omitempty"`
+}
+
+type progressContextKey struct{}
+
+// withProgress returns a copy of ctx reporting the progress of the batch
+// submitted with it to observe. Sub-batches may be processed concurrently,
+// so observe must be safe for concurrent use.
+func withProgress(ctx context.Context, observe func(ProgressEvent)) context.Context {
+	return context.WithValue(ctx, progressContextKey{}, observe)
+}
+
+// reportProgress passes the event of the sub-batch sub to the observer in
+// ctx, if any.
+func reportProgress(ctx context.Context, typ ProgressType, sub subBatchRange, err error) {
+	observe, ok := ctx.Value(progressContextKey{}).(func(ProgressEvent))
+	if !ok {
+		return
+	}
+	e := ProgressEvent{
+		Type:     typ,
+		SubBatch: sub.index,
+		Start:    sub.start,
+		End:      sub.end,
+		Items:    int(sub.end - sub.start),
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	observe(e)
+}
+
+// newProgressHandler returns a WebSocket handler processing by client the
+// batch sent as a JSON array in the first message and streaming its
+// ProgressEvents as JSON messages, ending with ProgressBatchComplete.
+// An invalid batch is answered with an errorResponse instead. The batch is
+// cancelled if the peer goes away before it is complete.
+func newProgressHandler(client *Client) websocket.Handler {
+	return func(ws *websocket.Conn) {
+		ctx, cancel := context.WithCancel(ws.Request().Context())
+		defer cancel()
+
+		id := ws.Request().Header.Get(requestIDHeader)
+		if id == "" {
+			id = newID()
+		}
+		ctx = withRequestID(ctx, id)
+		logger := client.loggerFor(ctx)
+
+		var msg []byte
+		if err := websocket.Message.Receive(ws, &msg); err != nil {
+			logger.Infof("Bad WebSocket request: %v", err)
+			return
+		}
+		batch, err := decodeJSONArray(bytes.NewReader(msg), defaultMaxRequestItems)
+		if err != nil {
+			logger.Infof("Bad WebSocket request: %v", err)
+			code, message := "invalid_request", "convert request to batch error"
+			if errors.Is(err, ErrTooManyItems) {
+				code, message = "too_many_items", "too many items"
+			}
+			websocket.JSON.Send(ws, errorResponse{Error: message, Code: code})
+			return
+		}
+		if _, invalid := validateBatch(batch); invalid > 0 {
+			logger.Infof("Bad WebSocket request: %d invalid items", invalid)
+			websocket.JSON.Send(ws, errorResponse{Error: fmt.Sprintf("%d invalid items", invalid), Code: "invalid_items"})
+			return
+		}
+		if len(batch) == 0 {
+			websocket.JSON.Send(ws, errorResponse{Error: "empty batch", Code: "empty_batch"})
+			return
+		}
+
+		// Nothing more is expected from the peer, so a failed read means
+		// it is gone.
+		go func() {
+			var discard []byte
+			for websocket.Message.Receive(ws, &discard) == nil {
+			}
+			cancel()
+		}()
+
+		// The events are sent from here, so a slow peer doesn't hold up
+		// the sub-batches for longer than it takes to fill the buffer.
+		events := make(chan ProgressEvent, 16)
+		observe := func(e ProgressEvent) {
+			select {
+			case events <- e:
+			case <-ctx.Done():
+			}
+		}
+		result := make(chan error, 1)
+		go func() {
+			result <- client.ProcessAndWait(withProgress(ctx, observe), batch)
+		}()
+
+		send := func(e ProgressEvent) {
+			if ctx.Err() != nil {
+				return
+			}
+			if err := websocket.JSON.Send(ws, e); err != nil {
+				logger.Infof("Error sending progress: %v", err)
+				cancel()
+			}
+		}
+		for {
+			select {
+			case e := <-events:
+				send(e)
+			case err := <-result:
+				// The sub-batches are done before the batch result,
+				// so all their events are buffered by now.
+				for len(events) > 0 {
+					send(<-events)
+				}
+				complete := ProgressEvent{Type: ProgressBatchComplete, Items: len(batch)}
+				if err != nil {
+					complete.Error = err.Error()
+				}
+				send(complete)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// dialProgress connects to the /ws endpoint of server.
+func dialProgress(t *testing.T, server *httptest.Server) *websocket.Conn {
+	t.Helper()
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", "", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	ws.SetDeadline(time.Now().Add(5 * time.Second))
+	return ws
+}
+
+func TestProgressHandler(t *testing.T) {
+	service := &failingService{
+		recordingService: recordingService{n: 2, p: time.Millisecond},
+		fail:             map[string]bool{"3": true},
+	}
+	client := NewClient(service)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	server := httptest.NewServer(newMux(client, DefaultServerConfig()))
+	defer server.Close()
+	ws := dialProgress(t, server)
+
+	if err := websocket.Message.Send(ws, `[1, 2, 3, 4, 5]`); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []ProgressEvent
+	for {
+		var e ProgressEvent
+		if err := websocket.JSON.Receive(ws, &e); err != nil {
+			t.Fatalf("expected more events after %v: %v", events, err)
+		}
+		events = append(events, e)
+		if e.Type == ProgressBatchComplete {
+			break
+		}
+	}
+
+	failure := "failed sub-batch starting with 3"
+	expected := []ProgressEvent{
+		{Type: ProgressSubBatchStarted, SubBatch: 0, Start: 0, End: 2, Items: 2},
+		{Type: ProgressSubBatchSucceeded, SubBatch: 0, Start: 0, End: 2, Items: 2},
+		{Type: ProgressSubBatchStarted, SubBatch: 1, Start: 2, End: 4, Items: 2},
+		{Type: ProgressSubBatchFailed, SubBatch: 1, Start: 2, End: 4, Items: 2, Error: failure},
+		{Type: ProgressSubBatchStarted, SubBatch: 2, Start: 4, End: 5, Items: 1},
+		{Type: ProgressSubBatchSucceeded, SubBatch: 2, Start: 4, End: 5, Items: 1},
+		{Type: ProgressBatchComplete, Items: 5, Error: failure},
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("expected events %+v, got %+v", expected, events)
+	}
+
+	// The connection is closed after the batch is complete.
+	var e ProgressEvent
+	if err := websocket.JSON.Receive(ws, &e); err == nil {
+		t.Errorf("expected the connection to be closed, got %+v", e)
+	}
+}
+
+func TestProgressHandlerInvalidBatch(t *testing.T) {
+	client := NewClient(&testService{n: 2, p: time.Millisecond})
+
+	server := httptest.NewServer(newMux(client, DefaultServerConfig()))
+	defer server.Close()
+	ws := dialProgress(t, server)
+
+	if err := websocket.Message.Send(ws, `{"not": "an array"}`); err != nil {
+		t.Fatal(err)
+	}
+	var resp errorResponse
+	if err := websocket.JSON.Receive(ws, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Code != "invalid_request" {
+		t.Errorf("expected code invalid_request, got %+v", resp)
+	}
+}
+
+func TestProgressHandlerPeerGone(t *testing.T) {
+	service := &hangingService{}
+	client := NewClient(service)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	server := httptest.NewServer(newMux(client, DefaultServerConfig()))
+	defer server.Close()
+	ws := dialProgress(t, server)
+
+	if err := websocket.Message.Send(ws, `[1, 2]`); err != nil {
+		t.Fatal(err)
+	}
+	var e ProgressEvent
+	if err := websocket.JSON.Receive(ws, &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Type != ProgressSubBatchStarted {
+		t.Fatalf("expected the sub-batch to start, got %+v", e)
+	}
+	ws.Close()
+
+	// Closing the connection cancels the batch, so nothing is left pending.
+	flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer flushCancel()
+	if err := client.Flush(flushCtx); err != nil {
+		t.Errorf("expected the batch to be cancelled: %v", err)
+	}
+}
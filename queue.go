@@ -0,0 +1,345 @@
+package main
+
+import (
+	"container/heap"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Queue stores the batches waiting to be processed by Run. The client keeps
+// its queue in memory by default, WithQueue plugs in another implementation,
+// e.g. one persisting the batches so that they survive a restart.
+// A queue holding batches when the client is created gets them processed.
+// Implementations must be safe for concurrent use.
+type Queue interface {
+	// Enqueue adds b to the queue.
+	Enqueue(b QueuedBatch) error
+	// Dequeue removes the batch to process next from the queue and returns
+	// it: the one with the highest priority, the one enqueued first among
+	// equal priorities. It reports false if the queue is empty.
+	Dequeue() (QueuedBatch, bool)
+	// Remove removes the batch with the given ID, reporting whether it was
+	// queued.
+	Remove(id string) bool
+	// Len returns the number of queued batches.
+	Len() int
+}
+
+// QueuedBatch is a batch waiting in a Queue along with its processing options.
+type QueuedBatch struct {
+	// ID identifies the batch, it is unique among the queued batches.
+	ID       string        `json:"id"`
+	Batch    Batch         `json:"batch"`
+	Priority int           `json:"priority,omitempty"`
+	Enqueued time.Time     `json:"enqueued"`
+	N        uint64        `json:"n,omitempty"`
+	P        time.Duration `json:"p,omitempty"`
+	// Named is set if ID was supplied by the caller, see
+	// Client.ProcessWithBatchID.
+	Named bool `json:"named,omitempty"`
+	// Tier is the tier the batch was submitted to, see WithTiers.
+	Tier string `json:"tier,omitempty"`
+}
+
+// WithQueue makes the client keep its queue in q. The queue capacity
+// still applies.
+func WithQueue(q Queue) Option {
+	return func(c *Client) {
+		c.backend = q
+	}
+}
+
+// newID returns a random unique ID.
+func newID() string {
+	var b [16]byte
+	// crypto/rand.Read never fails on the supported platforms.
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// jobQueue is a bounded queue of jobs kept in a Queue.
+type jobQueue struct {
+	capacity int
+	// maxItems caps the items of the queued jobs, zero means no cap.
+	maxItems int
+	backend  Queue
+
+	mu sync.Mutex
+	// jobs are the queued jobs by ID. Batches that were already queued
+	// when the client was created are missing, they get new jobs when
+	// they are popped.
+	jobs map[string]*job
+	// items is the number of items of jobs.
+	items int
+	seq   uint64
+	// receivers is the number of consumers ready to pop a job right away.
+	// They make room for a job each on top of capacity, so that a queue
+	// without capacity hands jobs over to waiting consumers only.
+	receivers int
+	// popped is closed and replaced every time a job is popped.
+	popped chan struct{}
+
+	// ready holds a value while the queue is not empty.
+	ready chan struct{}
+}
+
+// newJobQueue creates a queue holding up to capacity jobs in backend,
+// or in memory if backend is nil.
+func newJobQueue(capacity int, backend Queue) *jobQueue {
+	if backend == nil {
+		backend = newMemoryQueue()
+	}
+
+	q := &jobQueue{
+		capacity: capacity,
+		backend:  backend,
+		jobs:     map[string]*job{},
+		popped:   make(chan struct{}),
+		ready:    make(chan struct{}, 1),
+	}
+	q.signal()
+	return q
+}
+
+// push adds j to the queue if there is room for it. Otherwise it returns
+// a channel closed once a job is popped, so the caller may try again.
+func (q *jobQueue) push(j *job) (ok bool, popped <-chan struct{}, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.backend.Len() >= q.capacity+q.receivers {
+		return false, q.popped, nil
+	}
+	// A job handed over to a waiting consumer doesn't stay queued.
+	if q.maxItems > 0 && q.items+len(j.batch) > q.maxItems && q.backend.Len() >= q.receivers {
+		return false, q.popped, nil
+	}
+
+	if j.id == "" {
+		j.id = newID()
+	}
+	if err := q.backend.Enqueue(j.queued()); err != nil {
+		return false, nil, err
+	}
+	j.seq = q.seq
+	q.seq++
+	q.jobs[j.id] = j
+	q.items += len(j.batch)
+	q.signal()
+	return true, nil, nil
+}
+
+// pop removes the job to process next from the queue.
+// It returns nil if the queue is empty.
+func (q *jobQueue) pop() *job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	b, ok := q.backend.Dequeue()
+	if !ok {
+		return nil
+	}
+
+	j, ok := q.jobs[b.ID]
+	if ok {
+		q.forget(j)
+	} else {
+		j = jobFromQueued(b)
+	}
+	q.notifyPopped()
+	q.signal()
+	return j
+}
+
+// popAll removes every queued job at once, in the order pop would.
+func (q *jobQueue) popAll() []*job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var jobs []*job
+	for {
+		b, ok := q.backend.Dequeue()
+		if !ok {
+			break
+		}
+		j, ok := q.jobs[b.ID]
+		if ok {
+			q.forget(j)
+		} else {
+			j = jobFromQueued(b)
+		}
+		jobs = append(jobs, j)
+	}
+	if len(jobs) > 0 {
+		q.notifyPopped()
+	}
+	return jobs
+}
+
+// removeOldest removes the job pushed first among the jobs with the lowest
+// priority, provided that priority is at most maxPriority.
+// It returns nil if there is no such job.
+func (q *jobQueue) removeOldest(maxPriority int) *job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var oldest *job
+	for _, j := range q.jobs {
+		if j.priority > maxPriority {
+			continue
+		}
+		if oldest == nil || j.priority < oldest.priority ||
+			j.priority == oldest.priority && j.seq < oldest.seq {
+			oldest = j
+		}
+	}
+	if oldest == nil || !q.backend.Remove(oldest.id) {
+		return nil
+	}
+
+	q.forget(oldest)
+	q.notifyPopped()
+	return oldest
+}
+
+// forget stops tracking j once it left the queue.
+// It must be called with mu held.
+func (q *jobQueue) forget(j *job) {
+	delete(q.jobs, j.id)
+	q.items -= len(j.batch)
+}
+
+// receiving marks a consumer as ready to pop a job right away or not.
+func (q *jobQueue) receiving(ready bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if ready {
+		q.receivers++
+	} else {
+		q.receivers--
+	}
+}
+
+// len returns the number of queued jobs.
+func (q *jobQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.backend.Len()
+}
+
+// itemCount returns the number of items of the queued jobs, not counting
+// those of the batches that were already queued when the client was
+// created.
+func (q *jobQueue) itemCount() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.items
+}
+
+// notifyPopped wakes up the pushers waiting for room.
+// It must be called with mu held.
+func (q *jobQueue) notifyPopped() {
+	close(q.popped)
+	q.popped = make(chan struct{})
+}
+
+// wake makes ready hold a value again if the queue is not empty,
+// for a consumer that took the value without popping a job.
+func (q *jobQueue) wake() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.signal()
+}
+
+// signal makes ready hold a value if the queue is not empty.
+// It must be called with mu held.
+func (q *jobQueue) signal() {
+	if q.backend.Len() == 0 {
+		return
+	}
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// memoryQueue is the default Queue keeping the batches in memory.
+type memoryQueue struct {
+	mu      sync.Mutex
+	batches batchHeap
+	seq     uint64
+}
+
+func newMemoryQueue() *memoryQueue {
+	return &memoryQueue{}
+}
+
+func (q *memoryQueue) Enqueue(b QueuedBatch) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	heap.Push(&q.batches, heapEntry{batch: b, seq: q.seq})
+	q.seq++
+	return nil
+}
+
+func (q *memoryQueue) Dequeue() (QueuedBatch, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.batches) == 0 {
+		return QueuedBatch{}, false
+	}
+	return heap.Pop(&q.batches).(heapEntry).batch, true
+}
+
+func (q *memoryQueue) Remove(id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, e := range q.batches {
+		if e.batch.ID == id {
+			heap.Remove(&q.batches, i)
+			return true
+		}
+	}
+	return false
+}
+
+func (q *memoryQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.batches)
+}
+
+// heapEntry is a batch in batchHeap, seq is the order it was pushed in.
+type heapEntry struct {
+	batch QueuedBatch
+	seq   uint64
+}
+
+// batchHeap implements heap.Interface ordering batches by priority and sequence.
+type batchHeap []heapEntry
+
+func (h batchHeap) Len() int { return len(h) }
+
+func (h batchHeap) Less(i, j int) bool {
+	if h[i].batch.Priority != h[j].batch.Priority {
+		return h[i].batch.Priority > h[j].batch.Priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h batchHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *batchHeap) Push(x any) { *h = append(*h, x.(heapEntry)) }
+
+func (h *batchHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
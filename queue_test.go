@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientPriority(t *testing.T) {
+	service := &recordingService{n: 10, p: time.Millisecond}
+	client := NewClient(service, WithOrdered(true))
+
+	batches := []struct {
+		id       string
+		priority int
+	}{
+		{"low", 0},
+		{"high", 10},
+		{"low2", 0},
+		{"high2", 10},
+	}
+	for _, b := range batches {
+		if err := client.ProcessWithPriority(Batch{{ID: b.id}}, b.priority); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	if err := client.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := service.recorded()
+	want := []string{"high", "high2", "low", "low2"}
+	if len(calls) != len(want) {
+		t.Fatalf("expected %d calls, got %d", len(want), len(calls))
+	}
+	for i, c := range calls {
+		if id := c.batch[0].ID; id != want[i] {
+			t.Errorf("call %d: expected batch %q, got %q", i, want[i], id)
+		}
+	}
+}
+
+func TestJobQueueCapacity(t *testing.T) {
+	q := newJobQueue(1, nil)
+
+	if ok, _, err := q.push(&job{}); !ok || err != nil {
+		t.Fatalf("expected push into an empty queue to succeed, got %v", err)
+	}
+	ok, popped, _ := q.push(&job{})
+	if ok {
+		t.Fatal("expected push into a full queue to fail")
+	}
+
+	q.pop()
+	select {
+	case <-popped:
+	default:
+		t.Error("expected popped to be closed after pop")
+	}
+	if q.pop() != nil {
+		t.Error("expected pop from an empty queue to return nil")
+	}
+}
+
+// fileQueue is a fake persistent Queue: it keeps its batches encoded as JSON
+// the way they would be written to a file, in FIFO order ignoring priorities.
+type fileQueue struct {
+	mu      sync.Mutex
+	records [][]byte
+}
+
+func (q *fileQueue) Enqueue(b QueuedBatch) error {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.records = append(q.records, data)
+	return nil
+}
+
+func (q *fileQueue) Dequeue() (QueuedBatch, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.records) == 0 {
+		return QueuedBatch{}, false
+	}
+	var b QueuedBatch
+	if err := json.Unmarshal(q.records[0], &b); err != nil {
+		panic(err)
+	}
+	q.records = q.records[1:]
+	return b, true
+}
+
+func (q *fileQueue) Remove(id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, data := range q.records {
+		var b QueuedBatch
+		if err := json.Unmarshal(data, &b); err != nil {
+			panic(err)
+		}
+		if b.ID == id {
+			q.records = append(q.records[:i], q.records[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (q *fileQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.records)
+}
+
+func TestClientQueue(t *testing.T) {
+	backend := &fileQueue{}
+
+	// The first client is stopped before it processes anything,
+	// like a crashed one.
+	client := NewClient(&testService{n: 2, p: time.Millisecond}, WithQueue(backend))
+	for i := 0; i < 3; i++ {
+		if err := client.ProcessWithLimits(numberedBatch(i*3, 3), 1, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := backend.Len(); n != 3 {
+		t.Fatalf("expected 3 batches in the queue, got %d", n)
+	}
+
+	service := &recordingService{n: 2, p: time.Millisecond}
+	client = NewClient(service, WithQueue(backend), WithOrdered(true))
+	if got := client.Stats().QueueLength; got != 3 {
+		t.Errorf("expected a queue length of 3 after the restart, got %d", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+	if err := client.Process(numberedBatch(9, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The limits of the recovered batches survive the restart too.
+	var got []string
+	for _, c := range service.recorded() {
+		got = append(got, c.batch[0].ID)
+	}
+	want := []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected sub-batches starting with %v, got %v", want, got)
+	}
+	if n := backend.Len(); n != 0 {
+		t.Errorf("expected an empty queue, got %d batches", n)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// DeadLetterStore keeps the batches that failed terminally in memory,
+// so that Client.Replay can submit them again.
+// It is safe for concurrent use.
+type DeadLetterStore struct {
+	mu      sync.Mutex
+	letters []deadLetter
+	seq     uint64
+}
+
+// deadLetter is a batch in a DeadLetterStore.
+type deadLetter struct {
+	seq   uint64
+	batch Batch
+	err   error
+}
+
+// NewDeadLetterStore creates an empty store.
+func NewDeadLetterStore() *DeadLetterStore {
+	return &DeadLetterStore{}
+}
+
+// WithDeadLetterStore makes the client keep every batch passed to the
+// dead-letter hook in store as well, see Replay.
+func WithDeadLetterStore(store *DeadLetterStore) Option {
+	return func(c *Client) {
+		c.deadLetters = store
+	}
+}
+
+// Add stores batch that failed with err.
+func (s *DeadLetterStore) Add(batch Batch, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	s.letters = append(s.letters, deadLetter{seq: s.seq, batch: batch, err: err})
+}
+
+// Batches returns the stored batches, oldest first.
+func (s *DeadLetterStore) Batches() []Batch {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	batches := make([]Batch, len(s.letters))
+	for i, l := range s.letters {
+		batches[i] = l.batch
+	}
+	return batches
+}
+
+// Len returns the number of stored batches.
+func (s *DeadLetterStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.letters)
+}
+
+// oldest returns the oldest stored batch.
+func (s *DeadLetterStore) oldest() (deadLetter, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.letters) == 0 {
+		return deadLetter{}, false
+	}
+	return s.letters[0], true
+}
+
+// remove removes the batch stored with seq.
+func (s *DeadLetterStore) remove(seq uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, l := range s.letters {
+		if l.seq == seq {
+			s.letters = append(s.letters[:i], s.letters[i+1:]...)
+			return
+		}
+	}
+}
+
+// Replay submits the batches in the client dead-letter store again, oldest
+// first, waiting for room in the queue like ProcessBlocking. A batch is
+// removed from the store only once the queue accepts it, so if Replay
+// fails, e.g. because ctx is done, the batches not replayed stay in the
+// store. Batches failing again while Replay runs are stored anew and left
+// for the next Replay. It does nothing without WithDeadLetterStore.
+func (c *Client) Replay(ctx context.Context) error {
+	if c.deadLetters == nil {
+		return nil
+	}
+
+	for n := c.deadLetters.Len(); n > 0; n-- {
+		letter, ok := c.deadLetters.oldest()
+		if !ok {
+			break
+		}
+		if err := c.enqueue(&job{batch: letter.batch, ctx: ctx}, true); err != nil {
+			return fmt.Errorf("replay: %w", err)
+		}
+		c.deadLetters.remove(letter.seq)
+	}
+	return nil
+}
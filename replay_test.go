@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestClientReplay(t *testing.T) {
+	service := &failingService{
+		recordingService: recordingService{n: 2, p: time.Millisecond},
+		fail:             map[string]bool{"2": true},
+	}
+	store := NewDeadLetterStore()
+	client := NewClient(service, WithDeadLetterStore(store))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	if err := client.Process(numberedBatch(0, 4)); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	batches := store.Batches()
+	if len(batches) != 1 || len(batches[0]) != 2 || batches[0][0].ID != "2" {
+		t.Fatalf("expected the failed sub-batch in the store, got %v", batches)
+	}
+
+	// The service is fixed.
+	service.fail = nil
+	if err := client.Replay(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := store.Len(); n != 0 {
+		t.Errorf("expected the replayed batch to leave the store, %d left", n)
+	}
+	if err := client.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := service.recorded()
+	if len(calls) != 3 || calls[2].batch[0].ID != "2" || len(calls[2].batch) != 2 {
+		t.Errorf("expected the failed sub-batch to be processed again, got %v", calls)
+	}
+	if n := store.Len(); n != 0 {
+		t.Errorf("expected no new dead letters, got %d", n)
+	}
+}
+
+func TestClientReplayFailure(t *testing.T) {
+	store := NewDeadLetterStore()
+	client := NewClient(&recordingService{n: 2, p: time.Millisecond}, WithDeadLetterStore(store))
+	store.Add(numberedBatch(0, 2), nil)
+	store.Add(numberedBatch(2, 2), nil)
+
+	client.close()
+	if err := client.Replay(context.Background()); err == nil {
+		t.Fatal("expected a closed client to fail the replay")
+	}
+	if n := store.Len(); n != 2 {
+		t.Errorf("expected the batches to stay in the store, got %d", n)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// BatchReport is the timing breakdown of a finished batch, see
+// WithOnComplete.
+type BatchReport struct {
+	// ID identifies the batch in the queue. It is empty for batches
+	// processed by ProcessAll.
+	ID    string
+	Items int
+	// Merged are the IDs of the batches merged into this one by
+	// WithCoalesce, if any.
+	Merged []string
+	// SubBatches is the number of sub-batches passed to the service.
+	SubBatches int
+	// Wall is the time from when processing of the batch started until it
+	// was done, by the client clock.
+	Wall time.Duration
+	// Service is the time spent in Process calls, and Throttled the time
+	// spent waiting for the rate limiter, summed over all the calls of the
+	// batch. Sub-batches in flight at once make them add up to more than
+	// Wall.
+	Service   time.Duration
+	Throttled time.Duration
+	// Errors is the number of errors the batch failed with: one for each
+	// failed sub-batch and one for the items left unprocessed.
+	Errors int
+	// Err is the outcome of the batch, as for ProcessAndWait.
+	Err error
+}
+
+// WithOnComplete makes the client pass the report of every batch it is done
+// with to fn. fn is called in a goroutine of its own, so that it doesn't
+// hold up processing, which also means the reports may arrive out of order
+// and fn must be safe for concurrent use. Batches merged by WithCoalesce are
+// reported as one, see BatchReport.Merged.
+func WithOnComplete(fn func(BatchReport)) Option {
+	return func(c *Client) {
+		c.onComplete = fn
+	}
+}
+
+// batchTiming adds up the waits of the sub-batches of a batch, which may
+// be in flight at once.
+type batchTiming struct {
+	start     time.Time
+	service   atomic.Int64
+	throttled atomic.Int64
+	// subBatches and errors are set by processBatch once every sub-batch
+	// is done.
+	subBatches int
+	errors     int
+}
+
+// startTiming returns the timing of a batch starting now, nil unless the
+// client reports completed batches.
+func (c *Client) startTiming() *batchTiming {
+	if c.onComplete == nil {
+		return nil
+	}
+	return &batchTiming{start: c.clock.Now()}
+}
+
+func (t *batchTiming) addService(d time.Duration) {
+	if t != nil {
+		t.service.Add(int64(d))
+	}
+}
+
+func (t *batchTiming) addThrottled(d time.Duration) {
+	if t != nil {
+		t.throttled.Add(int64(d))
+	}
+}
+
+// reportComplete passes the report of j, finished with err, to the
+// completion hook in a new goroutine.
+func (c *Client) reportComplete(j *job, t *batchTiming, err error) {
+	report := BatchReport{
+		ID:         j.id,
+		Items:      len(j.batch),
+		SubBatches: t.subBatches,
+		Wall:       c.clock.Now().Sub(t.start),
+		Service:    time.Duration(t.service.Load()),
+		Throttled:  time.Duration(t.throttled.Load()),
+		Errors:     t.errors,
+		Err:        err,
+	}
+	for _, m := range j.merged {
+		report.Merged = append(report.Merged, m.id)
+	}
+	// A batch that never got to its sub-batches failed as a whole.
+	if err != nil && report.Errors == 0 {
+		report.Errors = 1
+	}
+	logger := c.loggerFor(j.submitContext())
+	go func() {
+		var err error
+		defer recoverPanic(logger, "completion hook", &err)
+		c.onComplete(report)
+	}()
+}
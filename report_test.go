@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// clockedService is a failingService taking d of the fake clock per call.
+type clockedService struct {
+	*failingService
+	clock *fakeClock
+	d     time.Duration
+}
+
+func (s *clockedService) Process(ctx context.Context, batch Batch) error {
+	s.clock.Advance(s.d)
+	return s.failingService.Process(ctx, batch)
+}
+
+func TestClientOnComplete(t *testing.T) {
+	const p = time.Hour
+	clock := newFakeClock()
+	service := &clockedService{
+		failingService: &failingService{
+			recordingService: recordingService{n: 2, p: p},
+			fail:             map[string]bool{"2": true},
+		},
+		clock: clock,
+		d:     time.Minute,
+	}
+	reports := make(chan BatchReport, 1)
+	client := NewClient(service,
+		WithClock(clock),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 1}),
+		WithOnComplete(func(r BatchReport) { reports <- r }),
+	)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- client.ProcessAll(context.Background(), numberedBatch(0, 5))
+	}()
+	// Each call takes a minute, so the two later sub-batches wait out the
+	// rest of the interval.
+	for i := 0; i < 2; i++ {
+		clock.waitTimers(t, 1)
+		clock.Advance(p - time.Minute)
+	}
+	err := <-done
+	if err == nil {
+		t.Fatal("expected the second sub-batch to fail")
+	}
+
+	var report BatchReport
+	select {
+	case report = <-reports:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a report")
+	}
+	expected := BatchReport{
+		Items:      5,
+		SubBatches: 3,
+		Wall:       2*p + time.Minute,
+		Service:    3 * time.Minute,
+		Throttled:  2 * (p - time.Minute),
+		Errors:     1,
+		Err:        err,
+	}
+	if !reflect.DeepEqual(report, expected) {
+		t.Errorf("expected report %+v, got %+v", expected, report)
+	}
+}
+
+func TestClientOnCompleteUnprocessed(t *testing.T) {
+	reports := make(chan BatchReport, 1)
+	client := NewClient(&testService{n: 2, p: time.Millisecond},
+		WithOnComplete(func(r BatchReport) { reports <- r }),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := client.ProcessAll(ctx, numberedBatch(0, 3)); err == nil {
+		t.Fatal("expected the batch to be left unprocessed")
+	}
+	report := <-reports
+	if report.SubBatches != 0 || report.Errors != 1 || report.Items != 3 {
+		t.Errorf("expected 3 unprocessed items in one error, got %+v", report)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"strings"
+)
+
+// requestIDHeader is the header carrying the ID of a /process request.
+const requestIDHeader = "X-Request-ID"
+
+type requestIDContextKey struct{}
+
+// RequestID returns the ID of the request the batch being processed with
+// ctx was submitted by. handleRequest takes it from the X-Request-ID
+// header, or generates one if there is none, and returns it in the same
+// header of the response. The gRPC Submit method does the same with the
+// x-request-id metadata.
+func RequestID(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDContextKey{}).(string)
+	return id, ok
+}
+
+// withRequestID returns a copy of ctx carrying the request ID id.
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey{}, id)
+}
+
+// loggerFor returns the client logger prefixing messages with the request
+// ID and the batch ID in ctx, if any.
+func (c *Client) loggerFor(ctx context.Context) Logger {
+	var prefix string
+	if id, ok := RequestID(ctx); ok {
+		prefix += "request " + id + ": "
+	}
+	if id, ok := ctx.Value(batchIDContextKey{}).(string); ok {
+		prefix += "batch " + id + ": "
+	}
+	if prefix == "" {
+		return c.logger
+	}
+	// The IDs become part of the format, so they must not add verbs.
+	return prefixLogger{Logger: c.logger, prefix: strings.ReplaceAll(prefix, "%", "%%")}
+}
+
+// prefixLogger is a Logger adding prefix to every message.
+type prefixLogger struct {
+	Logger
+	prefix string
+}
+
+func (l prefixLogger) Infof(format string, args ...any) {
+	l.Logger.Infof(l.prefix+format, args...)
+}
+
+func (l prefixLogger) Errorf(format string, args ...any) {
+	l.Logger.Errorf(l.prefix+format, args...)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// errorResponse is the body of a failed /process response.
+type errorResponse struct {
+	// Error describes the failure for humans.
+	Error string `json:"error"`
+	// Code identifies the failure for programs.
+	Code string `json:"code"`
+}
+
+// processResponse is the body of a successful /process response.
+type processResponse struct {
+	// ID identifies the batch for /status, if its status is tracked.
+	ID string `json:"id,omitempty"`
+	// Accepted is the number of items enqueued.
+	Accepted int `json:"accepted"`
+	// Invalid is the number of items dropped for failing validation,
+	// which only happens under ServerConfig.LenientItems.
+	Invalid int `json:"invalid,omitempty"`
+	// Duplicates is the number of items dropped as duplicates of earlier
+	// items of the batch with WithDedup.
+	Duplicates int `json:"duplicates,omitempty"`
+}
+
+// syncResponse is the body of a successful /process-sync response.
+type syncResponse struct {
+	// Processed is the number of items processed.
+	Processed int `json:"processed"`
+}
+
+// writeJSON writes v as the JSON body of a response with status.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	// Nothing can be done about a client gone by now.
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an errorResponse with status, code and message.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, errorResponse{Error: message, Code: code})
+}
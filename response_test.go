@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleRequestJSONResponse(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		want   map[string]any
+	}{
+		{
+			name:   "accepted",
+			body:   "[1, 2, 3]",
+			status: http.StatusOK,
+			want:   map[string]any{"accepted": float64(3)},
+		},
+		{
+			name:   "malformed",
+			body:   "[1, 2",
+			status: http.StatusBadRequest,
+			want:   map[string]any{"error": "convert request to batch error", "code": "invalid_request"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewClient(&recordingService{n: 2, p: time.Millisecond})
+
+			rr := httptest.NewRecorder()
+			handleRequest(client, rr, httptest.NewRequest("POST", "/process", strings.NewReader(tt.body)))
+
+			if rr.Code != tt.status {
+				t.Errorf("expected %v, got %v", tt.status, rr.Code)
+			}
+			if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("expected JSON content type, got %q", ct)
+			}
+			var got map[string]any
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			// Accepted batches get a random ID for /status.
+			if tt.status == http.StatusOK {
+				if id, _ := got["id"].(string); id == "" {
+					t.Errorf("expected a batch ID, got %v", got)
+				}
+				delete(got, "id")
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("%s: expected %v, got %v", k, v, got[k])
+				}
+			}
+		})
+	}
+}
+
+func TestHandleRequestFilteredCounts(t *testing.T) {
+	client := NewClient(&recordingService{n: 2, p: time.Millisecond}, WithDedup(true))
+	h := newRequestHandler(client)
+	h.lenient = true
+
+	rr := httptest.NewRecorder()
+	body := `[1, null, 2, 1, {"id": 2}, null, 3]`
+	h.ServeHTTP(rr, httptest.NewRequest("POST", "/process", strings.NewReader(body)))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected %v, got %v", http.StatusOK, rr.Code)
+	}
+	var resp processResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	j := client.queue.pop()
+	if j == nil || len(j.batch) != resp.Accepted {
+		t.Fatalf("expected a batch of %d items, got %v", resp.Accepted, j)
+	}
+	if want := (processResponse{ID: j.id, Accepted: 3, Invalid: 2, Duplicates: 2}); resp != want {
+		t.Errorf("expected %+v, got %+v", want, resp)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// RetryPolicy defines how a failed sub-batch is retried.
+// The zero value disables retries.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of Process calls per sub-batch,
+	// including the first one. Values less than 1 mean a single attempt.
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, doubled on every next one.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between retries. Zero means no cap.
+	MaxDelay time.Duration
+}
+
+// WithRetryable sets the classifier telling the errors of the service worth
+// retrying from the terminal ones, which send the sub-batch to the
+// dead-letter hook right away, e.g. to retry network errors but not
+// validation errors. ErrBlocked is handled before and never reaches it.
+// By default every error but context.Canceled is retried.
+func WithRetryable(retryable func(err error) bool) Option {
+	return func(c *Client) {
+		c.retryable = retryable
+	}
+}
+
+// defaultRetryable is the default classifier of WithRetryable.
+func defaultRetryable(err error) bool {
+	return !errors.Is(err, context.Canceled)
+}
+
+// delay returns the backoff before the given retry, starting from 1.
+func (p RetryPolicy) delay(retry int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < retry; i++ {
+		if p.MaxDelay > 0 && d >= p.MaxDelay {
+			break
+		}
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d
+}
+
+// processWithRetry processes batch, located by sub, by the service retrying
+// it according to the client retry policy. Every attempt waits for the
+// client limiter and every retry additionally waits for the backoff delay,
+// so retries never exceed the service limits.
+//
+// ErrBlocked doesn't count as a failed attempt: the first caller to get it
+// blocks the client, waits out the cooldown and probes the service again,
+// while the others wait until the probe succeeds.
+// If the service is a PartialService, only the failed items are retried,
+// including those it failed with ErrBlocked.
+// It returns the items that failed and the last error if any.
+func (c *Client) processWithRetry(ctx context.Context, batch Batch, sub subBatchRange) (Batch, error) {
+	probing := false
+	defer func() {
+		// Let somebody else probe the service if we give up.
+		if probing {
+			c.gate.unblock()
+		}
+	}()
+
+	// offsets are the positions of batch in the sub-batch once it is
+	// narrowed down to the failed items, callCtx carries the key of them.
+	var offsets []int
+	callCtx := ctx
+	for attempt := 1; ; {
+		if !probing {
+			if err := c.gate.Wait(ctx); err != nil {
+				return batch, err
+			}
+		}
+		if err := c.paused.Wait(ctx); err != nil {
+			return batch, err
+		}
+		if err := c.valve.Wait(ctx); err != nil {
+			return batch, err
+		}
+
+		if c.breaker != nil {
+			if err := c.breaker.allow(); err != nil {
+				return batch, err
+			}
+		}
+
+		start := c.clock.Now()
+		if err := c.waitLimiter(ctx, len(batch), sub.tier); err != nil {
+			if c.breaker != nil {
+				c.breaker.release()
+			}
+			return batch, err
+		}
+		waited := c.clock.Now().Sub(start)
+		c.metrics.RateLimitWaited(waited)
+		c.stats.throttled.Add(int64(waited))
+		sub.timing.addThrottled(waited)
+
+		c.recordAudit(AuditAttempt, sub.batch, sub.index, attempt, len(batch), nil)
+		start = c.clock.Now()
+		err := c.callService(callCtx, batch)
+		latency := c.clock.Now().Sub(start)
+		sub.timing.addService(latency)
+		c.recordCall(start, batch, err)
+		c.metrics.SubBatchProcessed(len(batch), latency, err)
+		// A partial failure processed items, even if some of them were
+		// blocked.
+		processed := processedItems(batch, err)
+		if c.adaptive != nil && (processed > 0 || !errors.Is(err, ErrBlocked)) {
+			c.adaptive.observe(len(batch), latency)
+		}
+		now := c.clock.Now()
+		c.stats.recordCall(now, processed, err)
+		c.observeCall(ctx, err)
+		if processed > 0 {
+			c.throughput.add(now, processed)
+		}
+
+		if c.breaker != nil {
+			// Neither a blocked service nor a shutdown tells
+			// whether the service works.
+			if errors.Is(err, ErrBlocked) || ctx.Err() != nil {
+				c.breaker.release()
+			} else {
+				c.breaker.done(err != nil)
+			}
+		}
+
+		// Only the failed items of a partial failure are sent again,
+		// whether they are retried or wait out a blocked service.
+		var perr *PartialError
+		if errors.As(err, &perr) {
+			offsets = perr.narrow(batch, offsets)
+			batch = perr.Items
+			callCtx = withPartialKey(ctx, offsets)
+		}
+
+		if errors.Is(err, ErrBlocked) {
+			if c.gate.block() || probing {
+				probing = true
+				c.loggerFor(ctx).Infof("Service is blocked, probing again in %v", c.cooldown)
+				if err := sleep(ctx, c.clock, c.cooldown); err != nil {
+					return batch, err
+				}
+			}
+			continue
+		}
+		if probing {
+			probing = false
+			c.gate.unblock()
+		}
+
+		if err == nil {
+			c.recordAudit(AuditSucceeded, sub.batch, sub.index, attempt, len(batch), nil)
+			return nil, nil
+		}
+		if fatal := c.checkFatal(err); fatal != nil {
+			return batch, fatal
+		}
+		if attempt >= c.retry.MaxAttempts || !c.retryable(err) {
+			return batch, err
+		}
+
+		c.recordAudit(AuditRetry, sub.batch, sub.index, attempt, len(batch), err)
+		delay := c.retry.delay(attempt)
+		attempt++
+		c.stats.retries.Add(1)
+		c.loggerFor(ctx).Infof("Retrying %v (attempt %d/%d) in %v: %v", sub, attempt, c.retry.MaxAttempts, delay, err)
+
+		if err := sleep(ctx, c.clock, delay); err != nil {
+			return batch, err
+		}
+	}
+}
+
+// waitLimiter waits for the client limiter, or that of tier, to allow
+// a call of items.
+func (c *Client) waitLimiter(ctx context.Context, items int, tier string) error {
+	if l := c.tierLimiter(tier); l != nil {
+		return l.Wait(ctx)
+	}
+	if c.items != nil {
+		return c.items.Wait(ctx, items)
+	}
+	return c.limiter.Wait(ctx)
+}
+
+// sleep pauses for d by clock or until ctx is done.
+// It returns right away if d isn't positive.
+func sleep(ctx context.Context, clock Clock, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	timer := clock.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C():
+		return nil
+	}
+}
+
+// callService makes a single Process call to the service
+// limited by the client process timeout. A PartialService is called with
+// ProcessItems and its failed items are returned as a PartialError.
+// A panic of the service is returned as an error wrapping ErrPanic, so the
+// sub-batch is retried like after any other failure.
+func (c *Client) callService(ctx context.Context, batch Batch) (err error) {
+	defer recoverPanic(c.loggerFor(ctx), "Process", &err)
+
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	if s, ok := c.service.(PartialService); ok {
+		results, err := s.ProcessItems(ctx, batch)
+		if err != nil {
+			return err
+		}
+		return failedItems(batch, results)
+	}
+	return c.service.Process(ctx, batch)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyService fails the first failures calls and succeeds afterwards.
+type flakyService struct {
+	n        uint64
+	p        time.Duration
+	failures int
+
+	mu        sync.Mutex
+	calls     int
+	successes int
+}
+
+func (s *flakyService) GetLimits() (uint64, time.Duration) {
+	return s.n, s.p
+}
+
+func (s *flakyService) Process(ctx context.Context, batch Batch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls++
+	if s.calls <= s.failures {
+		return errors.New("temporary failure")
+	}
+	s.successes++
+	return nil
+}
+
+func (s *flakyService) counts() (calls, successes int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls, s.successes
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{
+		BaseDelay: time.Millisecond * 10,
+		MaxDelay:  time.Millisecond * 50,
+	}
+
+	expected := []time.Duration{
+		time.Millisecond * 10,
+		time.Millisecond * 20,
+		time.Millisecond * 40,
+		time.Millisecond * 50,
+		time.Millisecond * 50,
+	}
+	for i, want := range expected {
+		if got := policy.delay(i + 1); got != want {
+			t.Errorf("retry %d: expected delay %v, got %v", i+1, want, got)
+		}
+	}
+}
+
+func TestClientRetry(t *testing.T) {
+	service := &flakyService{
+		n:        2,
+		p:        time.Millisecond * 5,
+		failures: 2,
+	}
+
+	client := NewClient(service, WithRetryPolicy(RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   time.Millisecond,
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	if err := client.Process(Batch{Item{}, Item{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.After(time.Second)
+	for {
+		if _, successes := service.counts(); successes > 0 {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("batch was not processed")
+		case <-time.After(time.Millisecond):
+		}
+	}
+
+	// Give the client a chance to misbehave with extra calls.
+	time.Sleep(time.Millisecond * 20)
+
+	calls, successes := service.counts()
+	if calls != 3 {
+		t.Errorf("expected 3 calls, got %d", calls)
+	}
+	if successes != 1 {
+		t.Errorf("expected 1 successful call, got %d", successes)
+	}
+}
+
+func TestClientRetryStopsOnCancel(t *testing.T) {
+	service := &flakyService{
+		n:        2,
+		p:        time.Millisecond,
+		failures: 10,
+	}
+
+	client := NewClient(service, WithRetryPolicy(RetryPolicy{
+		MaxAttempts: 10,
+		BaseDelay:   time.Second,
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go client.Run(ctx)
+
+	if err := client.Process(Batch{Item{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(time.Millisecond * 20)
+	cancel()
+	time.Sleep(time.Millisecond * 20)
+
+	if calls, _ := service.counts(); calls != 1 {
+		t.Errorf("expected 1 call before cancellation, got %d", calls)
+	}
+}
+
+// hangingService never finishes a Process call until ctx is done.
+type hangingService struct {
+	calls atomic.Int32
+}
+
+func (s *hangingService) GetLimits() (uint64, time.Duration) {
+	return 1, time.Millisecond
+}
+
+func (s *hangingService) Process(ctx context.Context, batch Batch) error {
+	s.calls.Add(1)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestClientProcessTimeout(t *testing.T) {
+	service := &hangingService{}
+	client := NewClient(service,
+		WithProcessTimeout(time.Millisecond*10),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	start := time.Now()
+	err := receiveResult(t, client.ProcessWithResult(make(Batch, 1)))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Millisecond*500 {
+		t.Errorf("expected the calls to be cancelled, took %v", elapsed)
+	}
+	if calls := service.calls.Load(); calls != 2 {
+		t.Errorf("expected the timed out call to be retried once, got %d calls", calls)
+	}
+}
+
+func TestClientRetryable(t *testing.T) {
+	errInvalid := errors.New("invalid item")
+	service := &failingService{
+		recordingService: recordingService{n: 2, p: time.Millisecond},
+		fail:             map[string]bool{"0": true},
+	}
+	letters := &deadLetters{}
+	client := NewClient(service,
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 3}),
+		WithRetryable(func(err error) bool { return !errors.Is(err, errInvalid) }),
+		WithDeadLetter(letters.add),
+	)
+
+	// The service fails with an error the classifier doesn't know first.
+	if err := client.ProcessAll(context.Background(), numberedBatch(0, 2)); err == nil {
+		t.Fatal("expected an error")
+	}
+	if n := len(service.recorded()); n != 3 {
+		t.Errorf("expected a retryable error to be retried, got %d calls", n)
+	}
+
+	terminal := &terminalService{err: errInvalid}
+	client = NewClient(terminal,
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 3}),
+		WithRetryable(func(err error) bool { return !errors.Is(err, errInvalid) }),
+		WithDeadLetter(letters.add),
+	)
+	if err := client.ProcessAll(context.Background(), numberedBatch(0, 2)); !errors.Is(err, errInvalid) {
+		t.Fatalf("expected %v, got %v", errInvalid, err)
+	}
+	if n := terminal.calls.Load(); n != 1 {
+		t.Errorf("expected a terminal error not to be retried, got %d calls", n)
+	}
+	if batches, errs := letters.recorded(); len(batches) != 2 || !errors.Is(errs[1], errInvalid) {
+		t.Errorf("expected the sub-batch to be dead-lettered, got %v: %v", batches, errs)
+	}
+}
+
+// terminalService always fails with err.
+type terminalService struct {
+	err   error
+	calls atomic.Int32
+}
+
+func (s *terminalService) GetLimits() (uint64, time.Duration) {
+	return 2, time.Millisecond
+}
+
+func (s *terminalService) Process(ctx context.Context, batch Batch) error {
+	s.calls.Add(1)
+	return s.err
+}
+
+func TestDefaultRetryable(t *testing.T) {
+	if !defaultRetryable(errors.New("temporary failure")) {
+		t.Error("expected service errors to be retryable")
+	}
+	if defaultRetryable(fmt.Errorf("process: %w", context.Canceled)) {
+		t.Error("expected cancellation not to be retryable")
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// ServerConfig configures the HTTP server of main.
+type ServerConfig struct {
+	// ReadHeaderTimeout limits reading the request headers, which is what
+	// slowloris clients drag out.
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout limits reading the whole request including the body.
+	ReadTimeout time.Duration
+	// WriteTimeout limits writing the response.
+	WriteTimeout time.Duration
+	// IdleTimeout limits how long a keep-alive connection waits for
+	// the next request.
+	IdleTimeout time.Duration
+	// MaxConcurrentStreams is the number of concurrent HTTP/2 streams
+	// allowed per connection. Zero means the http2 package default.
+	MaxConcurrentStreams uint32
+	// MaxRequestItems is the maximum number of items in a batch submitted
+	// to /process, /process-sync or the gRPC Submit method. Zero or less
+	// means no limit.
+	MaxRequestItems int
+	// LenientItems makes the front-ends drop the invalid items of a batch
+	// instead of rejecting it as a whole.
+	LenientItems bool
+}
+
+// DefaultServerConfig returns the server settings main uses by default.
+// The read timeout leaves room for large batches.
+func DefaultServerConfig() ServerConfig {
+	return ServerConfig{
+		ReadHeaderTimeout:    time.Second * 5,
+		ReadTimeout:          time.Second * 30,
+		WriteTimeout:         time.Second * 30,
+		IdleTimeout:          time.Second * 120,
+		MaxConcurrentStreams: 250,
+		MaxRequestItems:      defaultMaxRequestItems,
+	}
+}
+
+// newServer creates a server on addr serving handler by HTTP/1.1 and by
+// HTTP/2, both over TLS and in cleartext (h2c), with the timeouts of cfg.
+func newServer(addr string, handler http.Handler, cfg ServerConfig) *http.Server {
+	h2 := &http2.Server{
+		MaxConcurrentStreams: cfg.MaxConcurrentStreams,
+		IdleTimeout:          cfg.IdleTimeout,
+	}
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           h2c.NewHandler(handler, h2),
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+	// It only fails if server already has TLS settings for HTTP/2.
+	http2.ConfigureServer(server, h2)
+	return server
+}
+
+// newMux returns the routes of the HTTP API of client, taking batches
+// with the request settings of cfg.
+func newMux(client *Client, cfg ServerConfig) *http.ServeMux {
+	return newRoutes(client, func(r *http.Request) *Client { return client }, cfg)
+}
+
+// newTenantMux returns the routes of the HTTP API of m: batches posted to
+// /process and /process-sync go to the client of the tenant in the
+// X-Tenant-ID header, the other routes are those of the client of
+// DefaultTenant.
+func newTenantMux(m *MultiTenantClient, cfg ServerConfig) *http.ServeMux {
+	return newRoutes(m.Client(DefaultTenant), func(r *http.Request) *Client {
+		return m.Client(r.Header.Get(tenantHeader))
+	}, cfg)
+}
+
+// newRoutes returns the routes of the HTTP API of client, submitting the
+// batches of a request to the client clientFor returns for it.
+func newRoutes(client *Client, clientFor func(r *http.Request) *Client, cfg ServerConfig) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/process", func(w http.ResponseWriter, r *http.Request) {
+		cfg.requestHandler(clientFor(r)).ServeHTTP(w, r)
+	})
+	mux.HandleFunc("/process-sync", func(w http.ResponseWriter, r *http.Request) {
+		h := cfg.requestHandler(clientFor(r))
+		h.sync = true
+		h.ServeHTTP(w, r)
+	})
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		handleStats(client, w, r)
+	})
+	mux.HandleFunc("/status/", func(w http.ResponseWriter, r *http.Request) {
+		handleStatus(client, w, r)
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		handleMetrics(client, w, r)
+	})
+	mux.HandleFunc("/pause", func(w http.ResponseWriter, r *http.Request) {
+		handlePause(client, w, r)
+	})
+	mux.HandleFunc("/resume", func(w http.ResponseWriter, r *http.Request) {
+		handleResume(client, w, r)
+	})
+	mux.Handle("/ws", newProgressHandler(client))
+	mux.HandleFunc("/healthz", handleHealthz)
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		handleReadyz(client, w, r)
+	})
+	return mux
+}
+
+// requestHandler returns a handler for client with the request settings
+// of cfg.
+func (cfg ServerConfig) requestHandler(client *Client) *requestHandler {
+	h := newRequestHandler(client)
+	h.maxItems = cfg.MaxRequestItems
+	h.lenient = cfg.LenientItems
+	return h
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+func TestNewServer(t *testing.T) {
+	cfg := ServerConfig{
+		ReadHeaderTimeout: time.Second,
+		ReadTimeout:       time.Second * 2,
+		WriteTimeout:      time.Second * 3,
+		IdleTimeout:       time.Second * 4,
+	}
+	server := newServer(":8080", http.NotFoundHandler(), cfg)
+
+	if server.Addr != ":8080" {
+		t.Errorf("expected the address :8080, got %q", server.Addr)
+	}
+	if server.ReadHeaderTimeout != cfg.ReadHeaderTimeout || server.ReadTimeout != cfg.ReadTimeout ||
+		server.WriteTimeout != cfg.WriteTimeout || server.IdleTimeout != cfg.IdleTimeout {
+		t.Errorf("expected the timeouts of %+v, got %v, %v, %v and %v", cfg,
+			server.ReadHeaderTimeout, server.ReadTimeout, server.WriteTimeout, server.IdleTimeout)
+	}
+
+	def := DefaultServerConfig()
+	if def.ReadHeaderTimeout <= 0 || def.ReadTimeout <= 0 || def.WriteTimeout <= 0 || def.IdleTimeout <= 0 {
+		t.Errorf("expected every default timeout to be set, got %+v", def)
+	}
+}
+
+func TestNewServerHTTP2(t *testing.T) {
+	client := NewClient(&recordingService{n: 2, p: time.Millisecond})
+	server := newServer("", newMux(client, DefaultServerConfig()), DefaultServerConfig())
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(listener)
+	defer server.Close()
+
+	// A cleartext HTTP/2 client with prior knowledge.
+	h2 := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}}
+	resp, err := h2.Get("http://" + listener.Addr().String() + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.ProtoMajor != 2 {
+		t.Errorf("expected 200 over HTTP/2, got %v over %v", resp.StatusCode, resp.Proto)
+	}
+}
+
+func TestNewMux(t *testing.T) {
+	client := NewClient(&recordingService{n: 2, p: time.Millisecond})
+	mux := newMux(client, DefaultServerConfig())
+
+	for _, path := range []string{"/process", "/process-sync", "/ws", "/stats", "/status/", "/metrics", "/pause", "/resume", "/healthz", "/readyz"} {
+		if _, pattern := mux.Handler(httptest.NewRequest("GET", path, nil)); pattern != path {
+			t.Errorf("expected %s to be routed, got %q", path, pattern)
+		}
+	}
+}
+
+func TestNewMuxRequestSettings(t *testing.T) {
+	cfg := DefaultServerConfig()
+	cfg.MaxRequestItems = 3
+	cfg.LenientItems = true
+	client := NewClient(&recordingService{n: 2, p: time.Millisecond})
+	mux := newMux(client, cfg)
+
+	for _, tt := range []struct {
+		body   string
+		status int
+	}{
+		{"[1, null, 2]", http.StatusOK},
+		{"[1, 2, 3, 4]", http.StatusRequestEntityTooLarge},
+	} {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest("POST", "/process", strings.NewReader(tt.body)))
+		if rr.Code != tt.status {
+			t.Errorf("%s: expected %d, got %d: %s", tt.body, tt.status, rr.Code, rr.Body)
+		}
+	}
+	if got := client.Stats().QueueLength; got != 1 {
+		t.Errorf("expected the lenient request to be enqueued, queue length is %d", got)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import "time"
+
+// WithSmoothing makes the client spread the n items the service takes per
+// p evenly across p instead of sending them at once: it sends sub-batches
+// of at most slice items, one every p*slice/n, so that the service gets a
+// steady trickle rather than a burst every p. A slice of 1 sends the items
+// one at a time. Zero, or a slice of n or more, turns smoothing off, which
+// is the default. It doesn't apply to WithItemRateLimit, which keeps its
+// own windows.
+func WithSmoothing(slice uint64) Option {
+	return func(c *Client) {
+		c.smooth = slice
+	}
+}
+
+// smoothing reports whether the client spreads the n items across p.
+func (c *Client) smoothing(n uint64) bool {
+	return c.smooth > 0 && c.smooth < n
+}
+
+// callInterval returns the interval between calls for the service limits
+// n and p: p itself, or the share of p of a slice when smoothing.
+func (c *Client) callInterval(n uint64, p time.Duration) time.Duration {
+	if !c.smoothing(n) {
+		return p
+	}
+	return time.Duration(float64(p) * float64(c.smooth) / float64(n))
+}
+
+// smoothSize caps the sub-batch size at the slice when smoothing the
+// service n.
+func (c *Client) smoothSize(size, n uint64) uint64 {
+	if c.smoothing(n) && c.smooth < size {
+		return c.smooth
+	}
+	return size
+}
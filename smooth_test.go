@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestClientSmoothing(t *testing.T) {
+	const p = time.Hour
+	for _, tc := range []struct {
+		name  string
+		slice uint64
+		calls int
+	}{
+		{"one at a time", 1, 8},
+		{"slices", 2, 4},
+		{"off", 4, 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clock := newFakeClock()
+			service := &recordingService{n: 4, p: p}
+			client := NewClient(service, WithClock(clock), WithSmoothing(tc.slice))
+			slots := recordSlots(client.limiter)
+			start := clock.Now()
+
+			done := make(chan error, 1)
+			go func() {
+				done <- client.ProcessAll(context.Background(), numberedBatch(0, 8))
+			}()
+			// The n items of a p are spread evenly across it.
+			spacing := p * time.Duration(tc.slice) / 4
+			for i := 1; i < tc.calls; i++ {
+				clock.waitTimers(t, 1)
+				clock.Advance(spacing)
+			}
+			if err := <-done; err != nil {
+				t.Fatal(err)
+			}
+
+			calls := service.recorded()
+			if len(calls) != tc.calls {
+				t.Fatalf("expected %d calls, got %d", tc.calls, len(calls))
+			}
+			for i, call := range calls {
+				if len(call.batch) != int(tc.slice) {
+					t.Errorf("call %d: expected %d items, got %d", i, tc.slice, len(call.batch))
+				}
+			}
+			for i, slot := range slots() {
+				if want := start.Add(time.Duration(i) * spacing); !slot.Equal(want) {
+					t.Errorf("slot %d: expected %v, got %v", i, want, slot)
+				}
+			}
+		})
+	}
+}
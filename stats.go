@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"math/bits"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// ClientStats is a snapshot of the client state.
+type ClientStats struct {
+	// QueueLength is the number of batches waiting in the queue.
+	QueueLength int `json:"queue_length"`
+	// QueuedItems is the number of items of the batches waiting in the
+	// queue, see WithMaxQueuedItems.
+	QueuedItems int `json:"queued_items"`
+	// InFlightBatches is the number of batches being processed.
+	InFlightBatches int64 `json:"in_flight_batches"`
+	// TotalProcessed is the number of items the service processed successfully.
+	TotalProcessed uint64 `json:"total_processed"`
+	// TotalErrors is the number of failed Process calls to the service.
+	TotalErrors uint64 `json:"total_errors"`
+	// TotalRetries is the number of Process calls retrying a failed
+	// sub-batch, a rising rate of which hints at the service struggling.
+	TotalRetries uint64 `json:"total_retries"`
+	// TotalThrottled is the time sub-batches spent waiting for the rate
+	// limiter, added up over concurrent waits. Close to the time spent
+	// processing, it means the service limits are the bottleneck.
+	TotalThrottled time.Duration `json:"total_throttled_ns"`
+	// LastError is the error of the last failed Process call,
+	// empty if there was none.
+	LastError string `json:"last_error,omitempty"`
+	// LastProcessTime is the time of the last Process call that processed
+	// items, even if others failed, zero if there was none.
+	LastProcessTime time.Time `json:"last_process_time"`
+	// ChunkSize is the size of the next sub-batch, which changes over time
+	// with WithAdaptiveChunkSize.
+	ChunkSize uint64 `json:"chunk_size"`
+	// Throughput is the recent rate of successfully processed items and
+	// sub-batches, see WithThroughputWindow.
+	Throughput Throughput `json:"throughput"`
+	// ErrorRate is the ratio of failed Process calls among the recent
+	// ones, see WithErrorRateWindow.
+	ErrorRate float64 `json:"error_rate"`
+	// Paused reports whether the client is paused.
+	Paused bool `json:"paused"`
+	// BatchSizes is the histogram of the sizes of the enqueued batches,
+	// omitting empty buckets.
+	BatchSizes []BatchSizeBucket `json:"batch_sizes,omitempty"`
+}
+
+// BatchSizeBucket counts the batches with more items than the previous
+// bucket and up to Max.
+type BatchSizeBucket struct {
+	// Max is the largest number of items in the batches of the bucket,
+	// a power of two.
+	Max uint64 `json:"max"`
+	// Count is the number of batches in the bucket.
+	Count uint64 `json:"count"`
+}
+
+// batchSizeBuckets is the number of batch size buckets, the last of which
+// also counts any larger batches.
+const batchSizeBuckets = 32
+
+// clientStats holds the counters behind ClientStats.
+type clientStats struct {
+	inFlight    atomic.Int64
+	processed   atomic.Uint64
+	errors      atomic.Uint64
+	retries     atomic.Uint64
+	throttled   atomic.Int64
+	lastProcess atomic.Int64
+	lastError   atomic.Pointer[string]
+	// batchSizes are the batch size buckets, the one with index i
+	// counting the batches of up to 1<<i items.
+	batchSizes [batchSizeBuckets]atomic.Uint64
+}
+
+// recordBatch adds an enqueued batch of items to the histogram.
+func (s *clientStats) recordBatch(items int) {
+	i := bits.Len(uint(items - 1))
+	if i >= batchSizeBuckets {
+		i = batchSizeBuckets - 1
+	}
+	s.batchSizes[i].Add(1)
+}
+
+// batchSizeHistogram returns the non-empty batch size buckets.
+func (s *clientStats) batchSizeHistogram() []BatchSizeBucket {
+	var buckets []BatchSizeBucket
+	for i := range s.batchSizes {
+		if n := s.batchSizes[i].Load(); n > 0 {
+			buckets = append(buckets, BatchSizeBucket{Max: 1 << i, Count: n})
+		}
+	}
+	return buckets
+}
+
+// recordCall updates the counters after a Process call finished at the
+// given time with err, which processed processed items. A partial failure
+// counts as a failed call that still processed the items not failed.
+func (s *clientStats) recordCall(at time.Time, processed int, err error) {
+	if err != nil {
+		s.errors.Add(1)
+		msg := err.Error()
+		s.lastError.Store(&msg)
+	}
+	if processed > 0 {
+		s.processed.Add(uint64(processed))
+		s.lastProcess.Store(at.UnixNano())
+	}
+}
+
+// Stats returns a snapshot of the client state.
+func (c *Client) Stats() ClientStats {
+	stats := ClientStats{
+		QueueLength:     c.queue.len(),
+		QueuedItems:     c.queue.itemCount(),
+		InFlightBatches: c.stats.inFlight.Load(),
+		TotalProcessed:  c.stats.processed.Load(),
+		TotalErrors:     c.stats.errors.Load(),
+		TotalRetries:    c.stats.retries.Load(),
+		TotalThrottled:  time.Duration(c.stats.throttled.Load()),
+		ChunkSize:       c.currentChunkSize(),
+		Throughput:      c.throughput.rate(c.clock.Now()),
+		ErrorRate:       c.errorRate.rate(),
+		Paused:          c.Paused(),
+		BatchSizes:      c.stats.batchSizeHistogram(),
+	}
+	if last := c.stats.lastProcess.Load(); last != 0 {
+		stats.LastProcessTime = time.Unix(0, last)
+	}
+	if msg := c.stats.lastError.Load(); msg != nil {
+		stats.LastError = *msg
+	}
+	return stats
+}
+
+// handleStats writes the client stats as JSON.
+func handleStats(client *Client, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(client.Stats()); err != nil {
+		client.logger.Errorf("Error writing stats: %v", err)
+	}
+}
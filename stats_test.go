@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestClientStats(t *testing.T) {
+	service := &flakyService{n: 2, p: time.Millisecond, failures: 1}
+	client := NewClient(service)
+
+	if stats := client.Stats(); !reflect.DeepEqual(stats, ClientStats{ChunkSize: 2}) {
+		t.Fatalf("expected empty stats but the chunk size, got %+v", stats)
+	}
+
+	if err := client.Process(make(Batch, 5)); err != nil {
+		t.Fatal(err)
+	}
+	if stats := client.Stats(); stats.QueueLength != 1 {
+		t.Errorf("expected 1 queued batch, got %d", stats.QueueLength)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	start := time.Now()
+	if err := client.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := client.Stats()
+	if stats.QueueLength != 0 || stats.InFlightBatches != 0 {
+		t.Errorf("expected nothing queued or in flight, got %+v", stats)
+	}
+	if stats.TotalProcessed != 3 {
+		t.Errorf("expected 3 processed items, got %d", stats.TotalProcessed)
+	}
+	if stats.TotalErrors != 1 {
+		t.Errorf("expected 1 error, got %d", stats.TotalErrors)
+	}
+	if stats.LastProcessTime.Before(start) {
+		t.Errorf("expected the last process time after %v, got %v", start, stats.LastProcessTime)
+	}
+}
+
+func TestClientStatsInFlight(t *testing.T) {
+	client := NewClient(&recordingService{n: 1, p: time.Hour})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	if err := client.Process(make(Batch, 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.After(time.Second)
+	for client.Stats().InFlightBatches != 1 {
+		select {
+		case <-deadline:
+			t.Fatal("batch is not in flight")
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+func TestHandleStats(t *testing.T) {
+	client := NewClient(&recordingService{n: 2, p: time.Millisecond})
+	if err := client.ProcessAll(context.Background(), make(Batch, 3)); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handleStats(client, rr, httptest.NewRequest("GET", "/stats", nil))
+
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected JSON content type, got %q", ct)
+	}
+
+	var stats ClientStats
+	if err := json.NewDecoder(rr.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.TotalProcessed != 3 {
+		t.Errorf("expected 3 processed items, got %d", stats.TotalProcessed)
+	}
+}
+
+func TestClientStatsRetries(t *testing.T) {
+	service := &flakyService{n: 2, p: time.Millisecond, failures: 3}
+	client := NewClient(service, WithRetryPolicy(RetryPolicy{MaxAttempts: 5}))
+
+	if err := client.ProcessAll(context.Background(), make(Batch, 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	calls, _ := service.counts()
+	stats := client.Stats()
+	if stats.TotalRetries != uint64(calls-1) || stats.TotalRetries != 3 {
+		t.Errorf("expected 3 retries for %d calls, got %d", calls, stats.TotalRetries)
+	}
+	if stats.LastError != "temporary failure" {
+		t.Errorf("expected the last error to be exposed, got %q", stats.LastError)
+	}
+}
+
+func TestClientStatsBatchSizes(t *testing.T) {
+	client := NewClient(&recordingService{n: 2, p: time.Millisecond}, WithQueueCapacity(10))
+
+	for _, n := range []int{1, 2, 3, 4, 5, 100} {
+		if err := client.Process(make(Batch, n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []BatchSizeBucket{
+		{Max: 1, Count: 1},
+		{Max: 2, Count: 1},
+		{Max: 4, Count: 2},
+		{Max: 8, Count: 1},
+		{Max: 128, Count: 1},
+	}
+	if got := client.Stats().BatchSizes; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected batch sizes %v, got %v", expected, got)
+	}
+}
+
+func TestClientStatsThrottled(t *testing.T) {
+	const p = time.Millisecond * 20
+	client := NewClient(&recordingService{n: 1, p: p})
+
+	start := time.Now()
+	if err := client.ProcessAll(context.Background(), make(Batch, 5)); err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+
+	// Every sub-batch but the first waits for an interval.
+	throttled := client.Stats().TotalThrottled
+	if least := 4 * p * 3 / 4; throttled < least || throttled > elapsed {
+		t.Errorf("expected between %v and %v throttled, got %v", least, elapsed, throttled)
+	}
+}
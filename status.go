@@ -0,0 +1,194 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultStatusTTL is how long the status of a finished batch is kept.
+const defaultStatusTTL = 5 * time.Minute
+
+// BatchState is the stage of a batch in its BatchStatus.
+type BatchState string
+
+const (
+	// BatchQueued is the state of a batch waiting in the queue.
+	BatchQueued BatchState = "queued"
+	// BatchProcessing is the state of a batch being processed.
+	BatchProcessing BatchState = "processing"
+	// BatchCompleted is the state of a batch processed successfully.
+	BatchCompleted BatchState = "completed"
+	// BatchFailed is the state of a batch finished with an error.
+	BatchFailed BatchState = "failed"
+)
+
+// BatchStatus is the progress of a batch submitted to the queue,
+// see Client.Status.
+type BatchStatus struct {
+	ID    string     `json:"id"`
+	State BatchState `json:"state"`
+	Items int        `json:"items"`
+	// SubBatchesDone and SubBatchesFailed count the sub-batches processed
+	// so far and those of them which failed terminally.
+	SubBatchesDone   int `json:"sub_batches_done"`
+	SubBatchesFailed int `json:"sub_batches_failed"`
+	// Error is the error the batch failed with, if any.
+	Error string `json:"error,omitempty"`
+	// Updated is when the status last changed by the client clock.
+	Updated time.Time `json:"updated"`
+}
+
+// WithStatusTTL sets how long the client keeps the status of a finished
+// batch for Status, defaultStatusTTL by default. Zero or less turns the
+// tracking off.
+func WithStatusTTL(ttl time.Duration) Option {
+	return func(c *Client) {
+		c.statuses.ttl = ttl
+	}
+}
+
+// Status returns the status of the batch with the given ID, as returned by
+// ProcessWithID or /process, or supplied to ProcessWithBatchID. It reports
+// false if the batch is unknown, e.g. because it finished more than the
+// TTL set by WithStatusTTL ago.
+func (c *Client) Status(id string) (BatchStatus, bool) {
+	return c.statuses.get(id)
+}
+
+// batchStatuses tracks the statuses of the batches by ID.
+type batchStatuses struct {
+	ttl time.Duration
+
+	mu       sync.Mutex
+	statuses map[string]*BatchStatus
+	// finished are the IDs of the finished batches in the order they
+	// finished, so that they expire in order.
+	finished []finishedBatch
+}
+
+type finishedBatch struct {
+	id string
+	at time.Time
+}
+
+// enabled reports whether statuses are tracked.
+func (s *batchStatuses) enabled() bool {
+	return s.ttl > 0
+}
+
+// queued starts tracking the batch of items with the given ID at now.
+func (s *batchStatuses) queued(id string, items int, now time.Time) {
+	if !s.enabled() {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire(now)
+	if s.statuses == nil {
+		s.statuses = make(map[string]*BatchStatus)
+	}
+	s.statuses[id] = &BatchStatus{ID: id, State: BatchQueued, Items: items, Updated: now}
+}
+
+// update changes the status of the batch with the given ID, if tracked.
+func (s *batchStatuses) update(id string, now time.Time, fn func(st *BatchStatus)) {
+	if !s.enabled() {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.statuses[id]
+	if !ok {
+		return
+	}
+	fn(st)
+	st.Updated = now
+}
+
+// finish records that the batch with the given ID finished with err at now.
+func (s *batchStatuses) finish(id string, err error, now time.Time) {
+	s.update(id, now, func(st *BatchStatus) {
+		st.State = BatchCompleted
+		if err != nil {
+			st.State = BatchFailed
+			st.Error = err.Error()
+		}
+	})
+	if s.enabled() {
+		s.mu.Lock()
+		s.finished = append(s.finished, finishedBatch{id: id, at: now})
+		s.mu.Unlock()
+	}
+}
+
+// forget stops tracking the batch with the given ID right away.
+func (s *batchStatuses) forget(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.statuses, id)
+}
+
+func (s *batchStatuses) get(id string) (BatchStatus, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.statuses[id]
+	if !ok {
+		return BatchStatus{}, false
+	}
+	return *st, true
+}
+
+// expire drops the statuses of the batches finished more than the TTL
+// before now.
+func (s *batchStatuses) expire(now time.Time) {
+	n := 0
+	for _, f := range s.finished {
+		if now.Sub(f.at) < s.ttl {
+			break
+		}
+		// A batch finished and submitted again with the same ID is
+		// tracked anew.
+		if st, ok := s.statuses[f.id]; ok && !st.Updated.After(f.at) {
+			delete(s.statuses, f.id)
+		}
+		n++
+	}
+	s.finished = s.finished[n:]
+}
+
+// trackStatus updates the statuses of j, or of the jobs merged into it.
+func (c *Client) trackStatus(j *job, fn func(st *BatchStatus)) {
+	if !c.statuses.enabled() {
+		return
+	}
+	now := c.clock.Now()
+	if len(j.merged) == 0 {
+		c.statuses.update(j.id, now, fn)
+		return
+	}
+	for _, m := range j.merged {
+		c.statuses.update(m.id, now, fn)
+	}
+}
+
+// handleStatus writes the BatchStatus of the batch whose ID follows
+// /status/ in the path, or 404 if it is unknown.
+func handleStatus(client *Client, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/status/")
+	status, ok := client.Status(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown_batch", "unknown batch")
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
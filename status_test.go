@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// getStatus queries the status of the batch with the given ID from server.
+func getStatus(t *testing.T, server *httptest.Server, id string) (int, BatchStatus) {
+	t.Helper()
+	resp, err := http.Get(server.URL + "/status/" + id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var status BatchStatus
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode, status
+}
+
+func TestHandleStatus(t *testing.T) {
+	client := NewClient(&recordingService{n: 2, p: time.Millisecond})
+	server := httptest.NewServer(newMux(client, DefaultServerConfig()))
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/process", "application/json", strings.NewReader("[1, 2, 3, 4, 5]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var accepted processResponse
+	err = json.NewDecoder(resp.Body).Decode(&accepted)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, status := getStatus(t, server, accepted.ID)
+	if code != http.StatusOK || status.State != BatchQueued || status.Items != 5 {
+		t.Fatalf("expected the batch to be queued, got %d %+v", code, status)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for status.State != BatchCompleted {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the batch to complete, got %+v", status)
+		}
+		time.Sleep(time.Millisecond)
+		_, status = getStatus(t, server, accepted.ID)
+	}
+	if status.SubBatchesDone != 3 || status.SubBatchesFailed != 0 || status.Error != "" {
+		t.Errorf("expected 3 sub-batches done, got %+v", status)
+	}
+
+	if code, _ := getStatus(t, server, "unknown"); code != http.StatusNotFound {
+		t.Errorf("expected %d for an unknown batch, got %d", http.StatusNotFound, code)
+	}
+	rr := httptest.NewRecorder()
+	handleStatus(client, rr, httptest.NewRequest("DELETE", "/status/"+accepted.ID, nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected %d, got %d", http.StatusMethodNotAllowed, rr.Code)
+	}
+}
+
+func TestClientStatusFailedAndExpired(t *testing.T) {
+	clock := newFakeClock()
+	service := &failingService{
+		recordingService: recordingService{n: 5, p: time.Millisecond},
+		fail:             map[string]bool{"0": true},
+	}
+	client := NewClient(service, WithClock(clock), WithStatusTTL(time.Minute))
+
+	id, err := client.ProcessWithID(numberedBatch(0, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+	flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer flushCancel()
+	if err := client.Flush(flushCtx); err != nil {
+		t.Fatal(err)
+	}
+
+	status, ok := client.Status(id)
+	if !ok || status.State != BatchFailed || status.SubBatchesFailed != 1 || status.Error == "" {
+		t.Fatalf("expected the batch to have failed, got %+v", status)
+	}
+
+	// The status expires once another batch is submitted after the TTL.
+	clock.Advance(time.Minute)
+	if _, err := client.ProcessWithID(numberedBatch(10, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if status, ok := client.Status(id); ok {
+		t.Errorf("expected the status to expire, got %+v", status)
+	}
+}
+
+func TestClientStatusDisabled(t *testing.T) {
+	client := NewClient(&recordingService{n: 2, p: time.Millisecond}, WithStatusTTL(0))
+	id, err := client.ProcessWithID(numberedBatch(0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := client.Status(id); ok {
+		t.Error("expected no status without tracking")
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// tenantHeader is the header carrying the tenant of a /process request,
+// see newTenantMux.
+const tenantHeader = "X-Tenant-ID"
+
+// DefaultTenant is the tenant of the batches without a known tenant.
+const DefaultTenant = ""
+
+// MultiTenantClient keeps a Client, and so a queue and a rate limit, per
+// tenant, so that a noisy tenant can't starve the others.
+type MultiTenantClient struct {
+	clients map[string]*Client
+}
+
+// NewMultiTenantClient creates a client per tenant and one for
+// DefaultTenant with newClient. Each client gets the limits of the service
+// newClient gives it, so tenants sharing a service should get a service
+// wrapper with their share of its limits.
+func NewMultiTenantClient(tenants []string, newClient func(tenant string) *Client) *MultiTenantClient {
+	m := &MultiTenantClient{clients: make(map[string]*Client, len(tenants)+1)}
+	m.clients[DefaultTenant] = newClient(DefaultTenant)
+	for _, tenant := range tenants {
+		if _, ok := m.clients[tenant]; !ok {
+			m.clients[tenant] = newClient(tenant)
+		}
+	}
+	return m
+}
+
+// Client returns the client of tenant, or that of DefaultTenant if tenant
+// is unknown.
+func (m *MultiTenantClient) Client(tenant string) *Client {
+	if c, ok := m.clients[tenant]; ok {
+		return c
+	}
+	return m.clients[DefaultTenant]
+}
+
+// Run runs every tenant client until ctx is done or they are shut down.
+// It returns the errors of the clients joined together.
+func (m *MultiTenantClient) Run(ctx context.Context) error {
+	errs := make(chan error, len(m.clients))
+	for _, c := range m.clients {
+		go func(c *Client) {
+			errs <- c.Run(ctx)
+		}(c)
+	}
+
+	var all []error
+	for range m.clients {
+		all = append(all, <-errs)
+	}
+	return errors.Join(all...)
+}
+
+// Shutdown shuts every tenant client down like Client.Shutdown.
+func (m *MultiTenantClient) Shutdown(ctx context.Context) error {
+	var wg sync.WaitGroup
+	errs := make(chan error, len(m.clients))
+	for _, c := range m.clients {
+		wg.Add(1)
+		go func(c *Client) {
+			defer wg.Done()
+			errs <- c.Shutdown(ctx)
+		}(c)
+	}
+	wg.Wait()
+	close(errs)
+
+	var all []error
+	for err := range errs {
+		all = append(all, err)
+	}
+	return errors.Join(all...)
+}
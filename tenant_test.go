@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMultiTenantClient(t *testing.T) {
+	const p = time.Millisecond * 30
+	services := map[string]*recordingService{}
+	slots := map[string]func() []time.Time{}
+	m := NewMultiTenantClient([]string{"a", "b"}, func(tenant string) *Client {
+		services[tenant] = &recordingService{n: 1, p: p}
+		c := NewClient(services[tenant])
+		slots[tenant] = recordSlots(c.limiter)
+		return c
+	})
+
+	mux := newTenantMux(m, DefaultServerConfig())
+	submit := func(tenant, body string) {
+		t.Helper()
+		r := httptest.NewRequest("POST", "/process", strings.NewReader(body))
+		if tenant != "" {
+			r.Header.Set("X-Tenant-ID", tenant)
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, r)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("tenant %q: expected %v, got %v", tenant, http.StatusOK, rr.Code)
+		}
+	}
+	submit("a", `["a1", "a2", "a3"]`)
+	submit("b", `["b1", "b2", "b3"]`)
+	submit("unknown", `["d1"]`)
+	submit("", `["d2"]`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	start := time.Now()
+	go m.Run(ctx)
+	if err := m.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for tenant, want := range map[string]int{"a": 3, "b": 3, DefaultTenant: 2} {
+		calls := services[tenant].recorded()
+		if len(calls) != want {
+			t.Fatalf("tenant %q: expected %d calls, got %d", tenant, want, len(calls))
+		}
+		prefix := tenant
+		if prefix == DefaultTenant {
+			prefix = "d"
+		}
+		for _, c := range calls {
+			if !strings.HasPrefix(c.batch[0].ID, prefix) {
+				t.Errorf("tenant %q: got the item %s of another tenant", tenant, c.batch[0].ID)
+			}
+		}
+		checkSlots(t, slots[tenant](), p)
+		// No tenant waits for the limit of another one.
+		if d := calls[0].at.Sub(start); d > p/2 {
+			t.Errorf("tenant %q: first call after %v, expected right away", tenant, d)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultThroughputWindow is the window of the throughput in Stats.
+const defaultThroughputWindow = time.Second * 10
+
+// throughputBuckets is the number of buckets the throughput window is
+// divided into.
+const throughputBuckets = 10
+
+// WithThroughputWindow sets the window the throughput in Stats is measured
+// over, defaultThroughputWindow by default. Shorter windows follow changes
+// faster but are noisier. Zero or less keeps the default.
+func WithThroughputWindow(window time.Duration) Option {
+	return func(c *Client) {
+		if window > 0 {
+			c.throughput = newThroughput(window)
+		}
+	}
+}
+
+// Throughput is the rate the service processes items at over the recent
+// window, see WithThroughputWindow.
+type Throughput struct {
+	ItemsPerSecond      float64 `json:"items_per_second"`
+	SubBatchesPerSecond float64 `json:"sub_batches_per_second"`
+}
+
+// throughput counts the Process calls which processed items in a ring of
+// buckets covering the window, so that old calls drop out without being
+// stored one by one.
+type throughput struct {
+	bucket time.Duration
+
+	mu      sync.Mutex
+	buckets [throughputBuckets]throughputBucket
+}
+
+// throughputBucket counts the calls of the bucket with index n since the
+// zero time.
+type throughputBucket struct {
+	n     int64
+	items uint64
+	calls uint64
+}
+
+func newThroughput(window time.Duration) *throughput {
+	bucket := window / throughputBuckets
+	if bucket <= 0 {
+		bucket = 1
+	}
+	return &throughput{bucket: bucket}
+}
+
+// add counts a call of items made at the given time.
+func (t *throughput) add(at time.Time, items int) {
+	n := at.UnixNano() / int64(t.bucket)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b := &t.buckets[n%throughputBuckets]
+	if b.n != n {
+		*b = throughputBucket{n: n}
+	}
+	b.items += uint64(items)
+	b.calls++
+}
+
+// rate returns the throughput over the window ending now. The current
+// bucket counts for the part of it that has passed.
+func (t *throughput) rate(now time.Time) Throughput {
+	n := now.UnixNano() / int64(t.bucket)
+
+	var items, calls uint64
+	t.mu.Lock()
+	for _, b := range t.buckets {
+		if b.n > n-throughputBuckets && b.n <= n {
+			items += b.items
+			calls += b.calls
+		}
+	}
+	t.mu.Unlock()
+
+	span := time.Duration(throughputBuckets-1)*t.bucket + time.Duration(now.UnixNano()%int64(t.bucket))
+	seconds := span.Seconds()
+	return Throughput{
+		ItemsPerSecond:      float64(items) / seconds,
+		SubBatchesPerSecond: float64(calls) / seconds,
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestThroughputWindow(t *testing.T) {
+	tp := newThroughput(time.Second)
+	start := time.Unix(100, 0)
+
+	// 10 calls of 5 items in the first half second.
+	for i := 0; i < 10; i++ {
+		tp.add(start.Add(time.Duration(i)*time.Millisecond*50), 5)
+	}
+	if got := tp.rate(start.Add(time.Second - time.Millisecond)); got.SubBatchesPerSecond < 9.9 || got.ItemsPerSecond < 49.9 {
+		t.Errorf("expected 10 sub-batches and 50 items per second, got %+v", got)
+	}
+	// The calls drop out once the window moves past them.
+	if got := tp.rate(start.Add(time.Second * 2)); got != (Throughput{}) {
+		t.Errorf("expected no throughput after the window, got %+v", got)
+	}
+}
+
+func TestClientStatsThroughput(t *testing.T) {
+	const p = time.Millisecond * 10
+	service := &recordingService{n: 2, p: p}
+	client := NewClient(service, WithThroughputWindow(time.Millisecond*200))
+
+	// 30 sub-batches take at least 300ms, longer than the window.
+	if err := client.ProcessAll(context.Background(), make(Batch, 60)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The limit allows 100 sub-batches of 2 items per second, scheduling
+	// can only slow them down.
+	got := client.Stats().Throughput
+	if got.SubBatchesPerSecond < 50 || got.SubBatchesPerSecond > 120 {
+		t.Errorf("expected about 100 sub-batches per second, got %v", got.SubBatchesPerSecond)
+	}
+	if ratio := got.ItemsPerSecond / got.SubBatchesPerSecond; ratio < 1.99 || ratio > 2.01 {
+		t.Errorf("expected 2 items per sub-batch, got %v", ratio)
+	}
+}
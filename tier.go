@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// ErrUnknownTier reports if a batch was submitted to a tier the client
+// wasn't configured with, see WithTiers.
+var ErrUnknownTier = errors.New("unknown tier")
+
+// Tier is a share of the service capacity reserved for some batches,
+// see WithTiers.
+type Tier struct {
+	Name string
+	// Share is the part of the service capacity of the tier, relative to
+	// the shares of the other tiers.
+	Share float64
+}
+
+// WithTiers splits the service capacity between tiers, each with its own
+// rate limiter, e.g. 0.8 for high priority batches and 0.2 for low priority
+// ones. The shares are scaled to add up to the service limits, so that the
+// tiers together never exceed them, while a tier swamped with batches
+// neither starves the others nor eats into their budget. The capacity of
+// an idle tier goes unused. Batches are submitted to a tier with
+// ProcessInTier, the other submit methods and ProcessAll use the first tier.
+// Tiers without a name or a share are ignored, as is WithItemRateLimit.
+func WithTiers(tiers ...Tier) Option {
+	return func(c *Client) {
+		c.tiers = nil
+		var total float64
+		for _, t := range tiers {
+			if t.Name != "" && t.Share > 0 {
+				total += t.Share
+			}
+		}
+		for _, t := range tiers {
+			if t.Name == "" || t.Share <= 0 {
+				continue
+			}
+			if c.tiers == nil {
+				c.tiers = make(map[string]*tierLimiter)
+				c.defaultTier = t.Name
+			}
+			c.tiers[t.Name] = &tierLimiter{share: t.Share / total, limiter: newLimiter(0)}
+		}
+	}
+}
+
+// ProcessInTier enqueues batch like Process, to be processed within the
+// capacity of tier. It fails with ErrUnknownTier if the client has no
+// such tier.
+func (c *Client) ProcessInTier(tier string, batch Batch) error {
+	if _, ok := c.tiers[tier]; !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownTier, tier)
+	}
+	return c.enqueue(&job{batch: batch, tier: tier}, false)
+}
+
+// tierLimiter is the rate limiter of a tier.
+type tierLimiter struct {
+	// share is the part of the service capacity, the shares of all tiers
+	// add up to 1.
+	share float64
+	*limiter
+}
+
+// setTierLimits configures the tier limiters after the client limiter
+// with the interval p between calls.
+func (c *Client) setTierLimits(p time.Duration) {
+	for _, t := range c.tiers {
+		t.setInterval(time.Duration(float64(p) / t.share))
+	}
+}
+
+// initTiers sets the tier limiters up like the client limiter.
+func (c *Client) initTiers() {
+	c.limiter.mu.Lock()
+	burst, jitter, waitFirst := c.limiter.burst, c.limiter.jitter, c.limiter.waitFirst
+	c.limiter.mu.Unlock()
+
+	for _, t := range c.tiers {
+		t.clock = c.clock
+		// The bursts are split like the capacity.
+		t.setBurst(int(math.Round(float64(burst) * t.share)))
+		t.setJitter(jitter)
+		t.setWaitFirst(waitFirst)
+	}
+	c.setTierLimits(c.callInterval(c.n, c.p))
+}
+
+// tierLimiter returns the limiter of tier, the default tier if it is
+// empty, or nil if the client has no tiers.
+func (c *Client) tierLimiter(tier string) *limiter {
+	if c.tiers == nil {
+		return nil
+	}
+	if tier == "" {
+		tier = c.defaultTier
+	}
+	return c.tiers[tier].limiter
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientTiers(t *testing.T) {
+	const p = 10 * time.Millisecond
+	clock := newFakeClock()
+	service := &recordingService{n: 1, p: p}
+	client := NewClient(service, WithClock(clock), WithTiers(Tier{Name: "high", Share: 0.8}, Tier{Name: "low", Share: 0.2}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	high, low := make(Batch, 200), make(Batch, 200)
+	for i := range high {
+		high[i].ID, low[i].ID = "high", "low"
+	}
+	if err := client.ProcessInTier("high", high); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.ProcessInTier("low", low); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both tiers have more work than they may send, so each always waits
+	// for its limiter. The high tier gets a call every 12.5ms and the low
+	// one every 50ms.
+	for elapsed := time.Duration(0); elapsed < time.Second; elapsed += p / 4 {
+		clock.waitTimers(t, 2)
+		clock.Advance(p / 4)
+	}
+	clock.waitTimers(t, 2)
+
+	counts := map[string]int{}
+	for _, call := range service.recorded() {
+		counts[call.batch[0].ID]++
+	}
+	total := counts["high"] + counts["low"]
+	if share := float64(counts["high"]) / float64(total); share < 0.75 || share > 0.85 {
+		t.Errorf("expected the high tier to get about 80%% of the calls, got %d of %d", counts["high"], total)
+	}
+	// Together the tiers stay within the service limit of one call per p,
+	// on top of the first call of each going out right away.
+	if limit := int(time.Second/p) + 2; total > limit {
+		t.Errorf("expected at most %d calls, got %d", limit, total)
+	}
+}
+
+func TestClientTiersDefault(t *testing.T) {
+	service := &recordingService{n: 2, p: time.Millisecond}
+	client := NewClient(service, WithTiers(Tier{Name: "high", Share: 4}, Tier{Name: "low", Share: 1}))
+
+	if l := client.tierLimiter(""); l != client.tiers["high"].limiter {
+		t.Error("expected the first tier to be the default")
+	}
+	if interval := client.tiers["high"].interval; interval != time.Millisecond*5/4 {
+		t.Errorf("expected the shares to be scaled to the service limits, got an interval of %v", interval)
+	}
+	if err := client.ProcessAll(context.Background(), make(Batch, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.ProcessInTier("medium", make(Batch, 1)); !errors.Is(err, ErrUnknownTier) || !strings.Contains(err.Error(), "medium") {
+		t.Errorf("expected %v, got %v", ErrUnknownTier, err)
+	}
+
+	// Refreshed limits are split likewise.
+	client.setLimits(2, time.Second)
+	if interval := client.tiers["low"].interval; interval != 5*time.Second {
+		t.Errorf("expected the low tier to follow the refreshed limits, got %v", interval)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import "context"
+
+// Tracer starts spans around batch processing: a "batch" span per batch and
+// a "sub-batch" child span per sub-batch. The context passed to
+// Service.Process holds the sub-batch span, so the service spans are its
+// children. It mirrors the part of the
+// OpenTelemetry tracer API the client needs, so adapting an OpenTelemetry
+// tracer takes a few lines. Implementations must be safe for concurrent use.
+type Tracer interface {
+	// Start starts a span as a child of the span in ctx, if any,
+	// and returns a context holding the new span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a single traced operation.
+type Span interface {
+	// SetAttribute records an attribute of the operation.
+	SetAttribute(key string, value any)
+	// RecordError marks the operation as failed with err.
+	RecordError(err error)
+	// End completes the span.
+	End()
+}
+
+// WithTracer sets the tracer the client starts its spans with.
+func WithTracer(tracer Tracer) Option {
+	return func(c *Client) {
+		c.tracer = tracer
+	}
+}
+
+// spanContext is a context cancelled along with Context but looking up
+// values in spans first, so that it carries the current span and the
+// values of the submit context to the service while processing keeps its
+// own cancellation.
+type spanContext struct {
+	context.Context
+	spans context.Context
+}
+
+func (c spanContext) Value(key any) any {
+	if v := c.spans.Value(key); v != nil {
+		return v
+	}
+	return c.Context.Value(key)
+}
+
+// noopTracer starts spans that record nothing.
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+// noopSpan records nothing.
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(string, any) {}
+func (noopSpan) RecordError(error)        {}
+func (noopSpan) End()                     {}
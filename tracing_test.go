@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordedSpan is a span recorded by memoryTracer.
+type recordedSpan struct {
+	tracer *memoryTracer
+	name   string
+	parent *recordedSpan
+	attrs  map[string]any
+	err    error
+	ended  bool
+}
+
+func (s *recordedSpan) SetAttribute(key string, value any) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.attrs[key] = value
+}
+
+func (s *recordedSpan) RecordError(err error) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.err = err
+}
+
+func (s *recordedSpan) End() {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.ended = true
+}
+
+type spanKey struct{}
+
+// memoryTracer keeps every started span in memory.
+type memoryTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+func (t *memoryTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	parent, _ := ctx.Value(spanKey{}).(*recordedSpan)
+	span := &recordedSpan{tracer: t, name: name, parent: parent, attrs: map[string]any{}}
+	t.spans = append(t.spans, span)
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// named returns the recorded spans with the given name.
+func (t *memoryTracer) named(name string) []*recordedSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var spans []*recordedSpan
+	for _, s := range t.spans {
+		if s.name == name {
+			spans = append(spans, s)
+		}
+	}
+	return spans
+}
+
+func TestClientTracing(t *testing.T) {
+	tracer := &memoryTracer{}
+	service := &failingService{
+		recordingService: recordingService{n: 2, p: time.Millisecond},
+		fail:             map[string]bool{"3": true},
+	}
+	client := NewClient(service, WithTracer(tracer))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	reqCtx, reqSpan := tracer.Start(context.Background(), "request")
+	// The request is done as soon as the batch is enqueued.
+	if err := client.ProcessContext(reqCtx, Batch{{ID: "1"}, {ID: "2"}, {ID: "3"}}); err != nil {
+		t.Fatal(err)
+	}
+	reqSpan.End()
+
+	if err := client.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+
+	if len(tracer.spans) != 4 {
+		t.Fatalf("expected 4 spans, got %d", len(tracer.spans))
+	}
+	batch, first, second := tracer.spans[1], tracer.spans[2], tracer.spans[3]
+
+	if batch.name != "batch" || batch.parent != reqSpan {
+		t.Errorf("expected a batch span child of the request span, got %q child of %v", batch.name, batch.parent)
+	}
+	if batch.attrs["batch.items"] != 3 || batch.attrs["batch.sub_batches"] != 2 {
+		t.Errorf("unexpected batch span attributes: %v", batch.attrs)
+	}
+	if batch.err == nil {
+		t.Error("expected the batch span to record the error")
+	}
+
+	for i, span := range []*recordedSpan{first, second} {
+		if span.name != "sub-batch" || span.parent != batch {
+			t.Errorf("span %d: expected a sub-batch span child of the batch span, got %q", i, span.name)
+		}
+		if span.attrs["sub_batch.index"] != i {
+			t.Errorf("span %d: unexpected index %v", i, span.attrs["sub_batch.index"])
+		}
+		if !span.ended {
+			t.Errorf("span %d was not ended", i)
+		}
+	}
+	if first.err != nil {
+		t.Errorf("expected the first sub-batch span to succeed, got %v", first.err)
+	}
+	if first.attrs["sub_batch.items"] != 2 || second.attrs["sub_batch.items"] != 1 {
+		t.Errorf("unexpected sub-batch item counts: %v and %v", first.attrs, second.attrs)
+	}
+	if second.err == nil {
+		t.Error("expected the second sub-batch span to record the error")
+	}
+}
+
+func TestHandleRequestContinuesTrace(t *testing.T) {
+	tracer := &memoryTracer{}
+	client := NewClient(&recordingService{n: 2, p: time.Millisecond}, WithTracer(tracer))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	reqCtx, reqSpan := tracer.Start(context.Background(), "request")
+	req := httptest.NewRequest("POST", "/process", strings.NewReader("[1, 2]")).WithContext(reqCtx)
+	handleRequest(client, httptest.NewRecorder(), req)
+
+	if err := client.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	batches := tracer.named("batch")
+	if len(batches) != 1 || batches[0].parent != reqSpan {
+		t.Errorf("expected a batch span child of the request span, got %v", batches)
+	}
+}
+
+// spanService starts a "process" span on every Process call,
+// like an instrumented service would.
+type spanService struct {
+	recordingService
+	tracer *memoryTracer
+}
+
+func (s *spanService) Process(ctx context.Context, batch Batch) error {
+	_, span := s.tracer.Start(ctx, "process")
+	defer span.End()
+	return s.recordingService.Process(ctx, batch)
+}
+
+func TestClientTracingPropagatesToService(t *testing.T) {
+	tracer := &memoryTracer{}
+	service := &spanService{recordingService: recordingService{n: 2, p: time.Millisecond}, tracer: tracer}
+	client := NewClient(service, WithTracer(tracer))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	// The request context is cancelled before the batch is processed,
+	// which must not cancel the Process calls.
+	reqCtx, cancelReq := context.WithCancel(context.Background())
+	if err := client.ProcessContext(reqCtx, Batch{{ID: "1"}, {ID: "2"}, {ID: "3"}}); err != nil {
+		t.Fatal(err)
+	}
+	cancelReq()
+
+	if err := client.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	subBatches, processes := tracer.named("sub-batch"), tracer.named("process")
+	if len(processes) != 2 || len(subBatches) != 2 {
+		t.Fatalf("expected 2 process and 2 sub-batch spans, got %d and %d", len(processes), len(subBatches))
+	}
+	for i, span := range processes {
+		if span.parent != subBatches[i] {
+			t.Errorf("process span %d: expected a child of sub-batch span %d", i, i)
+		}
+	}
+	if calls := len(service.recorded()); calls != 2 {
+		t.Errorf("expected 2 calls, got %d", calls)
+	}
+}
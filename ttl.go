@@ -0,0 +1,25 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrExpired reports if a batch waited in the queue longer than its TTL.
+var ErrExpired = errors.New("batch expired in queue")
+
+// WithBatchTTL makes the client skip batches that waited in the queue for
+// longer than ttl by the time they are about to be processed. A skipped
+// batch is passed to the dead-letter hook and finished with an error
+// wrapping ErrUnprocessed and ErrExpired. Zero or less means no TTL.
+func WithBatchTTL(ttl time.Duration) Option {
+	return func(c *Client) {
+		c.ttl = ttl
+	}
+}
+
+// expired reports whether j outlived the client TTL in the queue.
+// Jobs that were never queued don't expire.
+func (c *Client) expired(j *job) bool {
+	return c.ttl > 0 && !j.enqueued.IsZero() && c.clock.Now().Sub(j.enqueued) > c.ttl
+}
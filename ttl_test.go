@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientBatchTTL(t *testing.T) {
+	var mu sync.Mutex
+	var expired []string
+	service := newSlowService()
+	client := NewClient(service,
+		WithOrdered(true),
+		WithBatchTTL(time.Millisecond*20),
+		WithDeadLetter(func(batch Batch, err error) {
+			if !errors.Is(err, ErrExpired) || !errors.Is(err, ErrUnprocessed) {
+				t.Errorf("expected %v and %v, got %v", ErrExpired, ErrUnprocessed, err)
+			}
+			mu.Lock()
+			expired = append(expired, batch[0].ID)
+			mu.Unlock()
+		}),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	if err := client.Process(Batch{{ID: "fresh"}}); err != nil {
+		t.Fatal(err)
+	}
+	<-service.started
+	stale := client.ProcessWithResult(Batch{{ID: "stale"}})
+
+	time.Sleep(time.Millisecond * 40)
+	close(service.release)
+
+	if err := receiveResult(t, stale); !errors.Is(err, ErrExpired) {
+		t.Errorf("expected %v, got %v", ErrExpired, err)
+	}
+	if err := client.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls := service.recorded(); len(calls) != 1 || calls[0].batch[0].ID != "fresh" {
+		t.Errorf("expected only the fresh batch to be processed, got %v", calls)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(expired) != 1 || expired[0] != "stale" {
+		t.Errorf("expected the stale batch to expire, got %v", expired)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// TypedItem is an item with a payload of type T.
+type TypedItem[T any] struct {
+	// ID identifies the item.
+	ID string
+	// Payload is the item data.
+	Payload T
+	// Weight is the cost of the item for the service, see WithMaxWeight.
+	Weight uint64
+}
+
+// TypedBatch is a batch of items with payloads of type T.
+type TypedBatch[T any] []TypedItem[T]
+
+// TypedService is Service taking batches of items with payloads of type T.
+type TypedService[T any] interface {
+	GetLimits() (n uint64, p time.Duration)
+	Process(ctx context.Context, batch TypedBatch[T]) error
+}
+
+// TypedPartialService is PartialService taking batches of items with
+// payloads of type T.
+type TypedPartialService[T any] interface {
+	TypedService[T]
+	// ProcessItems processes batch and returns a result for every item in
+	// the same order, as PartialService.ProcessItems.
+	ProcessItems(ctx context.Context, batch TypedBatch[T]) ([]ItemResult, error)
+}
+
+// TypedClient is a Client for a TypedService. The payloads travel through
+// the client queue encoded as JSON, so that they may be kept by any Queue
+// backend, and are decoded right before TypedService.Process. Batches
+// passed to the dead-letter hook carry the encoded payloads too.
+type TypedClient[T any] struct {
+	*Client
+}
+
+// NewTypedClient creates a client to service configured by opts. The
+// client makes use of the optional interfaces of service as it would for
+// a Service: it is checked for health if it is a HealthChecker, its calls
+// are cut by the size of the encoded payloads if it is a PayloadLimiter,
+// and only the failed items are retried if it is a TypedPartialService.
+func NewTypedClient[T any](service TypedService[T], opts ...Option) *TypedClient[T] {
+	var s Service = typedService[T]{service}
+	if partial, ok := service.(TypedPartialService[T]); ok {
+		s = typedPartialService[T]{typedService[T]{service}, partial}
+	}
+	return &TypedClient[T]{Client: NewClient(s, opts...)}
+}
+
+// Process enqueues batch like Client.Process.
+func (c *TypedClient[T]) Process(batch TypedBatch[T]) error {
+	b, err := encodeBatch(batch)
+	if err != nil {
+		return err
+	}
+	return c.Client.Process(b)
+}
+
+// ProcessContext enqueues batch like Client.ProcessContext.
+func (c *TypedClient[T]) ProcessContext(ctx context.Context, batch TypedBatch[T]) error {
+	b, err := encodeBatch(batch)
+	if err != nil {
+		return err
+	}
+	return c.Client.ProcessContext(ctx, b)
+}
+
+// ProcessWithResult enqueues batch like Client.ProcessWithResult.
+func (c *TypedClient[T]) ProcessWithResult(batch TypedBatch[T]) <-chan error {
+	b, err := encodeBatch(batch)
+	if err != nil {
+		result := make(chan error, 1)
+		result <- err
+		close(result)
+		return result
+	}
+	return c.Client.ProcessWithResult(b)
+}
+
+// ProcessAll processes batch inline like Client.ProcessAll.
+func (c *TypedClient[T]) ProcessAll(ctx context.Context, batch TypedBatch[T]) error {
+	b, err := encodeBatch(batch)
+	if err != nil {
+		return err
+	}
+	return c.Client.ProcessAll(ctx, b)
+}
+
+// encodeBatch returns batch with the payloads encoded as JSON.
+func encodeBatch[T any](batch TypedBatch[T]) (Batch, error) {
+	b := make(Batch, len(batch))
+	for i, item := range batch {
+		payload, err := json.Marshal(item.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("encode item %d: %w", i, err)
+		}
+		b[i] = Item{ID: item.ID, Payload: payload, Weight: item.Weight}
+	}
+	return b, nil
+}
+
+// decodeBatch returns batch with the payloads decoded by decode.
+func decodeBatch[T any](batch Batch, decode func(raw []byte) (T, error)) (TypedBatch[T], error) {
+	b := make(TypedBatch[T], len(batch))
+	for i, item := range batch {
+		payload, err := decode(item.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("decode item %d: %w", i, err)
+		}
+		b[i] = TypedItem[T]{ID: item.ID, Payload: payload, Weight: item.Weight}
+	}
+	return b, nil
+}
+
+// decodeJSON decodes raw into a T.
+func decodeJSON[T any](raw []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(raw, &v)
+	return v, err
+}
+
+// ConvertRequest decodes the request body into a batch like
+// convertRequestToBatch, with the payloads decoded by decode, e.g. to
+// validate them on top of decoding JSON.
+func ConvertRequest[T any](r *http.Request, maxItems int, decode func(raw []byte) (T, error)) (TypedBatch[T], error) {
+	batch, err := convertRequestToBatch(r, maxItems)
+	if err != nil {
+		return nil, err
+	}
+	return decodeBatch(batch, decode)
+}
+
+// typedService adapts a TypedService to Service.
+type typedService[T any] struct {
+	service TypedService[T]
+}
+
+func (s typedService[T]) GetLimits() (uint64, time.Duration) {
+	return s.service.GetLimits()
+}
+
+// Process decodes the payloads of batch and passes it to the service.
+// A payload that can't be decoded fails the sub-batch.
+func (s typedService[T]) Process(ctx context.Context, batch Batch) error {
+	b, err := decodeBatch(batch, decodeJSON[T])
+	if err != nil {
+		return err
+	}
+	return s.service.Process(ctx, b)
+}
+
+// Healthy checks the health of the service if it is a HealthChecker.
+func (s typedService[T]) Healthy(ctx context.Context) error {
+	if h, ok := s.service.(HealthChecker); ok {
+		return h.Healthy(ctx)
+	}
+	return nil
+}
+
+// MaxPayloadBytes returns the payload limit of the service if it is
+// a PayloadLimiter, zero otherwise.
+func (s typedService[T]) MaxPayloadBytes() int {
+	if l, ok := s.service.(PayloadLimiter); ok {
+		return l.MaxPayloadBytes()
+	}
+	return 0
+}
+
+// typedPartialService adapts a TypedPartialService to PartialService.
+type typedPartialService[T any] struct {
+	typedService[T]
+	partial TypedPartialService[T]
+}
+
+// ProcessItems decodes the payloads of batch and passes it to the service.
+// A payload that can't be decoded fails the whole sub-batch.
+func (s typedPartialService[T]) ProcessItems(ctx context.Context, batch Batch) ([]ItemResult, error) {
+	b, err := decodeBatch(batch, decodeJSON[T])
+	if err != nil {
+		return nil, err
+	}
+	return s.partial.ProcessItems(ctx, b)
+}
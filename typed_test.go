@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type order struct {
+	SKU      string `json:"sku"`
+	Quantity int    `json:"quantity"`
+}
+
+// orderService records the orders it gets.
+type orderService struct {
+	mu     sync.Mutex
+	orders []TypedBatch[order]
+}
+
+func (s *orderService) GetLimits() (uint64, time.Duration) {
+	return 2, time.Millisecond
+}
+
+func (s *orderService) Process(ctx context.Context, batch TypedBatch[order]) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.orders = append(s.orders, batch)
+	return nil
+}
+
+func TestTypedClient(t *testing.T) {
+	service := &orderService{}
+	client := NewTypedClient[order](service)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	batch := TypedBatch[order]{
+		{ID: "1", Payload: order{SKU: "apple", Quantity: 3}},
+		{ID: "2", Payload: order{SKU: "pear", Quantity: 1}},
+		{ID: "3", Payload: order{SKU: "plum", Quantity: 2}},
+	}
+	if err := receiveResult(t, client.ProcessWithResult(batch)); err != nil {
+		t.Fatal(err)
+	}
+
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	if len(service.orders) != 2 {
+		t.Fatalf("expected 2 sub-batches, got %d", len(service.orders))
+	}
+	var got TypedBatch[order]
+	for _, b := range service.orders {
+		got = append(got, b...)
+	}
+	for i, item := range got {
+		if item != batch[i] {
+			t.Errorf("item %d: expected %+v, got %+v", i, batch[i], item)
+		}
+	}
+}
+
+func TestTypedClientEncodeError(t *testing.T) {
+	client := NewTypedClient[chan int](noopTypedService[chan int]{})
+	if err := client.Process(TypedBatch[chan int]{{Payload: make(chan int)}}); err == nil {
+		t.Error("expected a payload that can't be encoded to be rejected")
+	}
+}
+
+// noopTypedService is NoopService for typed batches.
+type noopTypedService[T any] struct{}
+
+func (noopTypedService[T]) GetLimits() (uint64, time.Duration) {
+	return 1, time.Millisecond
+}
+
+func (noopTypedService[T]) Process(ctx context.Context, batch TypedBatch[T]) error {
+	return nil
+}
+
+func TestConvertRequest(t *testing.T) {
+	r := httptest.NewRequest("POST", "/process", strings.NewReader(`[{"id":"a","sku":"apple","quantity":3}]`))
+	batch, err := ConvertRequest(r, 10, decodeJSON[order])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batch) != 1 || batch[0].ID != "a" || batch[0].Payload != (order{SKU: "apple", Quantity: 3}) {
+		t.Errorf("unexpected batch %+v", batch)
+	}
+
+	errInvalid := errors.New("no quantity")
+	r = httptest.NewRequest("POST", "/process", strings.NewReader(`[{"sku":"apple"}]`))
+	_, err = ConvertRequest(r, 10, func(raw []byte) (order, error) {
+		o, err := decodeJSON[order](raw)
+		if err == nil && o.Quantity == 0 {
+			err = errInvalid
+		}
+		return o, err
+	})
+	if !errors.Is(err, errInvalid) {
+		t.Errorf("expected %v, got %v", errInvalid, err)
+	}
+}
+
+// partialOrderService is an orderService failing the orders of a SKU the
+// first time they are processed, and limiting the payload of its calls.
+type partialOrderService struct {
+	orderService
+	fail     string
+	maxBytes int
+	failed   map[string]bool
+}
+
+func (s *partialOrderService) ProcessItems(ctx context.Context, batch TypedBatch[order]) ([]ItemResult, error) {
+	s.Process(ctx, batch)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	results := make([]ItemResult, len(batch))
+	for i, item := range batch {
+		if item.Payload.SKU == s.fail && !s.failed[item.ID] {
+			s.failed[item.ID] = true
+			results[i].Err = errors.New("out of stock")
+		}
+	}
+	return results, nil
+}
+
+func (s *partialOrderService) MaxPayloadBytes() int {
+	return s.maxBytes
+}
+
+func (s *partialOrderService) Healthy(ctx context.Context) error {
+	return errors.New("down for maintenance")
+}
+
+func TestTypedClientOptionalInterfaces(t *testing.T) {
+	service := &partialOrderService{fail: "pear", failed: make(map[string]bool)}
+	client := NewTypedClient[order](service, WithRetryPolicy(RetryPolicy{MaxAttempts: 2}))
+
+	if err := client.checkHealth(context.Background()); err == nil {
+		t.Error("expected the health check of the service to be used")
+	}
+
+	batch := TypedBatch[order]{
+		{ID: "1", Payload: order{SKU: "apple", Quantity: 3}},
+		{ID: "2", Payload: order{SKU: "pear", Quantity: 1}},
+	}
+	if err := client.ProcessAll(context.Background(), batch); err != nil {
+		t.Fatalf("expected the retry to succeed, got %v", err)
+	}
+	service.mu.Lock()
+	if len(service.orders) != 2 || len(service.orders[1]) != 1 || service.orders[1][0].ID != "2" {
+		t.Errorf("expected only the failed order to be retried, got %+v", service.orders)
+	}
+	service.orders = nil
+	service.mu.Unlock()
+
+	// The encoded orders take 27 and 28 bytes, so both exceed the limit.
+	service.maxBytes = 40
+	service.fail = ""
+	if err := client.ProcessAll(context.Background(), batch); err != nil {
+		t.Fatal(err)
+	}
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	if len(service.orders) != 2 {
+		t.Errorf("expected the payload limit to split the batch, got %+v", service.orders)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+// WithMaxWeight makes the client cut sub-batches once the total weight of
+// their items would exceed max, on top of the n items limit. An item
+// heavier than max goes to the service alone. Zero means no weight limit,
+// which is the default.
+func WithMaxWeight(max uint64) Option {
+	return func(c *Client) {
+		c.maxWeight = max
+	}
+}
+
+// weight returns the weight of the item for chunking.
+func (item Item) weight() uint64 {
+	if item.Weight == 0 {
+		return 1
+	}
+	return item.Weight
+}
+
+// cutByWeight returns where the sub-batch of batch starting at start and
+// ending at end at the latest has to end to keep within the client
+// weight limit. The sub-batch has at least one item.
+func (c *Client) cutByWeight(batch Batch, start, end uint64) uint64 {
+	if c.maxWeight == 0 {
+		return end
+	}
+
+	total := batch[start].weight()
+	for i := start + 1; i < end; i++ {
+		total += batch[i].weight()
+		if total > c.maxWeight {
+			return i
+		}
+	}
+	return end
+}
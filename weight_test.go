@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientMaxWeight(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights []uint64
+		want    [][]uint64
+	}{
+		{
+			name:    "mixed",
+			weights: []uint64{2, 2, 2, 1, 4, 6, 1},
+			want:    [][]uint64{{2, 2}, {2, 1}, {4}, {6}, {1}},
+		},
+		{
+			// Without weights every item counts as one, so the n limit
+			// cuts the sub-batches first.
+			name:    "absent",
+			weights: []uint64{0, 0, 0, 0, 0},
+			want:    [][]uint64{{0, 0, 0}, {0, 0}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &recordingService{n: 3, p: time.Millisecond}
+			client := NewClient(service, WithMaxWeight(5))
+
+			batch := make(Batch, len(tt.weights))
+			for i, w := range tt.weights {
+				batch[i].Weight = w
+			}
+			if err := client.ProcessAll(context.Background(), batch); err != nil {
+				t.Fatal(err)
+			}
+
+			var got [][]uint64
+			for _, c := range service.recorded() {
+				var weights []uint64
+				for _, item := range c.batch {
+					weights = append(weights, item.Weight)
+				}
+				got = append(got, weights)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected sub-batches weighing %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestConvertRequestToBatchWeight(t *testing.T) {
+	body := `[{"id": 1, "weight": 3}, {"id": 2}, {"id": 3, "weight": "heavy"}, 4]`
+	batch, err := convertRequestToBatch(httptest.NewRequest("POST", "/process", strings.NewReader(body)), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []uint64
+	for _, item := range batch {
+		got = append(got, item.Weight)
+	}
+	if want := []uint64{3, 0, 0, 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected weights %v, got %v", want, got)
+	}
+	if batch[2].ID != "3" {
+		t.Errorf("expected an invalid weight to leave the ID alone, got %q", batch[2].ID)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import "context"
+
+// WithWorkers makes Run process batches by n workers at most n at a time.
+// While every worker is busy new batches wait in the queue.
+// Zero or less means a goroutine per batch without a bound.
+func WithWorkers(n int) Option {
+	return func(c *Client) {
+		if n < 0 {
+			n = 0
+		}
+		c.workers = n
+	}
+}
+
+// WithOrdered makes the client process batches one at a time in the order
+// they were enqueued, so that items reach the service in submission order.
+// It overrides WithWorkers and WithInFlightLimit.
+func WithOrdered(ordered bool) Option {
+	return func(c *Client) {
+		c.ordered = ordered
+	}
+}
+
+// startWorkers starts the client workers processing batches from work
+// until it is closed.
+func (c *Client) startWorkers(ctx context.Context) {
+	c.work = make(chan *job)
+	for i := 0; i < c.workers; i++ {
+		c.inFlight.Add(1)
+		go func() {
+			defer c.inFlight.Done()
+			for j := range c.work {
+				j.finish(c.runBatch(ctx, j))
+			}
+		}()
+	}
+}
+
+// WithMaxConcurrentBatches caps the number of batches processed at once at
+// n, so that the goroutines and memory stay bounded under a flood of
+// batches. Run stops dequeuing while n batches are in flight and the others
+// wait in the queue, subject to its backpressure. Unlike WithWorkers it
+// keeps a goroutine per batch, started only once a slot is free.
+// WithWorkers overrides it. Zero or less means no cap, which is the default.
+func WithMaxConcurrentBatches(n int) Option {
+	return func(c *Client) {
+		c.batchSlots = nil
+		if n > 0 {
+			c.batchSlots = make(chan struct{}, n)
+		}
+	}
+}
+
+// waitBatchSlot waits until a batch can be spawned without exceeding
+// WithMaxConcurrentBatches. Run is the only one taking slots, so the slot
+// stays free until it spawns. It returns false if ctx is done first.
+func (c *Client) waitBatchSlot(ctx context.Context) bool {
+	if c.batchSlots == nil {
+		return true
+	}
+	select {
+	case c.batchSlots <- struct{}{}:
+		<-c.batchSlots
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// WithInFlightLimit lets up to n sub-batches of a batch be processed at
+// once instead of one after another. They still share the client rate
+// limit, and every sub-batch is retried and reported on its own.
+// Values less than 2 keep sub-batches in order, which is the default.
+func WithInFlightLimit(n int) Option {
+	return func(c *Client) {
+		c.subBatches = n
+	}
+}
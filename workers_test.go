@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// maxConcurrentBatches returns how many batches overlapped at most judging
+// by calls, given that item IDs are the IDs of their batches.
+func maxConcurrentBatches(calls []call) int {
+	first := map[string]time.Time{}
+	last := map[string]time.Time{}
+	for _, c := range calls {
+		id := c.batch[0].ID
+		if _, ok := first[id]; !ok {
+			first[id] = c.at
+		}
+		last[id] = c.at
+	}
+
+	max := 0
+	for _, c := range calls {
+		active := 0
+		for id := range first {
+			if !c.at.Before(first[id]) && !c.at.After(last[id]) {
+				active++
+			}
+		}
+		if active > max {
+			max = active
+		}
+	}
+	return max
+}
+
+func TestClientWorkers(t *testing.T) {
+	tests := []struct {
+		name    string
+		workers int
+		want    int
+	}{
+		{name: "bounded", workers: 2, want: 2},
+		{name: "unbounded", workers: 0, want: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &recordingService{n: 1, p: time.Millisecond * 2}
+			client := NewClient(service, WithWorkers(tt.workers))
+			slots := recordSlots(client.limiter)
+
+			results := make([]<-chan error, 5)
+			for i := range results {
+				id := fmt.Sprintf("batch-%d", i)
+				results[i] = client.ProcessWithResult(Batch{{ID: id}, {ID: id}, {ID: id}})
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go client.Run(ctx)
+
+			for _, result := range results {
+				if err := receiveResult(t, result); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			calls := service.recorded()
+			if got := maxConcurrentBatches(calls); got != tt.want {
+				t.Errorf("expected %d concurrent batches, got %d", tt.want, got)
+			}
+			if got := len(slots()); got != len(calls) {
+				t.Errorf("expected a limiter slot per call, got %d slots for %d calls", got, len(calls))
+			}
+			checkSlots(t, slots(), service.p)
+		})
+	}
+}
+
+// numberedBatch returns a batch of n items with IDs numbered from first.
+func numberedBatch(first, n int) Batch {
+	batch := make(Batch, n)
+	for i := range batch {
+		batch[i].ID = strconv.Itoa(first + i)
+	}
+	return batch
+}
+
+// checkOrder checks that calls got items numbered from 0 in order.
+func checkOrder(t *testing.T, calls []call) {
+	t.Helper()
+
+	next := 0
+	for i, c := range calls {
+		for _, item := range c.batch {
+			if item.ID != strconv.Itoa(next) {
+				t.Fatalf("call %d: expected item %d, got %s", i, next, item.ID)
+			}
+			next++
+		}
+	}
+}
+
+func TestClientSubBatchOrder(t *testing.T) {
+	service := &recordingService{n: 3, p: time.Millisecond}
+	client := NewClient(service, WithRetryPolicy(RetryPolicy{MaxAttempts: 2}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	receiveResult(t, client.ProcessWithResult(numberedBatch(0, 10)))
+
+	calls := service.recorded()
+	if len(calls) != 4 {
+		t.Fatalf("expected 4 sub-batches, got %d", len(calls))
+	}
+	checkOrder(t, calls)
+}
+
+func TestClientOrdered(t *testing.T) {
+	service := &recordingService{n: 2, p: time.Millisecond}
+	client := NewClient(service, WithOrdered(true), WithWorkers(4))
+
+	for i := 0; i < 3; i++ {
+		if err := client.Process(numberedBatch(i*3, 3)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	if err := client.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := service.recorded()
+	if len(calls) != 6 {
+		t.Fatalf("expected 6 sub-batches, got %d", len(calls))
+	}
+	checkOrder(t, calls)
+}
+
+func TestClientWorkersShutdown(t *testing.T) {
+	service := &recordingService{n: 2, p: time.Millisecond}
+	client := NewClient(service, WithWorkers(1))
+
+	for i := 0; i < 3; i++ {
+		if err := client.Process(make(Batch, 2)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	if err := client.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if calls := len(service.recorded()); calls != 3 {
+		t.Errorf("expected 3 calls, got %d", calls)
+	}
+}
+
+// concurrencyService takes d to process a batch and tracks how many
+// Process calls overlap at most.
+type concurrencyService struct {
+	recordingService
+	d time.Duration
+
+	mu      sync.Mutex
+	current int
+	max     int
+}
+
+func (s *concurrencyService) Process(ctx context.Context, batch Batch) error {
+	s.mu.Lock()
+	s.current++
+	if s.current > s.max {
+		s.max = s.current
+	}
+	s.mu.Unlock()
+
+	time.Sleep(s.d)
+	s.recordingService.Process(ctx, batch)
+
+	s.mu.Lock()
+	s.current--
+	s.mu.Unlock()
+	if batch[0].ID == "2" {
+		return errors.New("failed sub-batch 2")
+	}
+	return nil
+}
+
+func (s *concurrencyService) maxConcurrent() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.max
+}
+
+func TestClientInFlightLimit(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		want int
+	}{
+		{name: "limited", opts: []Option{WithInFlightLimit(2)}, want: 2},
+		{name: "default", want: 1},
+		{name: "ordered", opts: []Option{WithInFlightLimit(2), WithOrdered(true)}, want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &concurrencyService{
+				recordingService: recordingService{n: 1, p: time.Millisecond},
+				d:                time.Millisecond * 20,
+			}
+			client := NewClient(service, tt.opts...)
+			slots := recordSlots(client.limiter)
+
+			err := client.ProcessAll(context.Background(), numberedBatch(0, 6))
+			if err == nil || err.Error() != "failed sub-batch 2" {
+				t.Errorf("expected the error of sub-batch 2 only, got %v", err)
+			}
+			if got := service.maxConcurrent(); got != tt.want {
+				t.Errorf("expected at most %d concurrent calls, got %d", tt.want, got)
+			}
+			if got := len(service.recorded()); got != 6 {
+				t.Errorf("expected 6 calls, got %d", got)
+			}
+			checkSlots(t, slots(), service.p)
+		})
+	}
+}
+
+// gateService holds every Process call until release is closed.
+type gateService struct {
+	concurrencyService
+	release chan struct{}
+}
+
+func (s *gateService) Process(ctx context.Context, batch Batch) error {
+	s.mu.Lock()
+	s.current++
+	if s.current > s.max {
+		s.max = s.current
+	}
+	s.mu.Unlock()
+
+	<-s.release
+
+	s.mu.Lock()
+	s.current--
+	s.mu.Unlock()
+	return nil
+}
+
+func TestClientMaxConcurrentBatches(t *testing.T) {
+	const limit = 3
+	service := &gateService{
+		concurrencyService: concurrencyService{recordingService: recordingService{n: 1, p: time.Microsecond}},
+		release:            make(chan struct{}),
+	}
+	client := NewClient(service, WithMaxConcurrentBatches(limit))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	results := make([]<-chan error, 20)
+	for i := range results {
+		results[i] = client.ProcessWithResult(numberedBatch(i, 1))
+	}
+
+	deadline := time.After(time.Second * 5)
+	for client.Stats().InFlightBatches < limit {
+		select {
+		case <-deadline:
+			t.Fatalf("expected %d batches in flight, got %d", limit, client.Stats().InFlightBatches)
+		case <-time.After(time.Millisecond):
+		}
+	}
+	// Give Run the chance to dequeue more than it may.
+	time.Sleep(time.Millisecond * 20)
+	if stats := client.Stats(); stats.InFlightBatches != limit || stats.QueueLength != len(results)-limit {
+		t.Errorf("expected %d batches in flight and the others queued, got %+v", limit, stats)
+	}
+
+	close(service.release)
+	for _, result := range results {
+		if err := receiveResult(t, result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := service.maxConcurrent(); got > limit {
+		t.Errorf("expected at most %d concurrent batches, got %d", limit, got)
+	}
+}